@@ -0,0 +1,229 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// | Authorization: Bearer {token} | Authorization: HMAC {key id}:{unix seconds}:{nonce}:{hex hmac-sha256 of "{key id}:{unix seconds}:{nonce}:{Service.Method}"} |
+
+const (
+	bearerScheme = "Bearer "
+	hmacScheme   = "HMAC "
+
+	defaultMaxSkew = 5 * time.Minute
+)
+
+// ErrNoCredentials tells the request carries no credentials the authenticator understands, so that the next one is tried
+var ErrNoCredentials = errors.New("auth: no credentials")
+
+// Request includes what a call is authenticated by
+type Request struct {
+	ServiceMethod string
+	Authorization string
+	Identity      string // verified client identity of mutual tls, empty otherwise
+}
+
+// Authenticator is to authenticate request and return its principal
+type Authenticator interface {
+	Authenticate(req *Request) (principal string, err error)
+}
+
+// AuthenticatorFunc is an authenticator in func
+type AuthenticatorFunc func(req *Request) (string, error)
+
+// Authenticate is to call f
+func (f AuthenticatorFunc) Authenticate(req *Request) (string, error) {
+	return f(req)
+}
+
+// StaticTokens maps static bearer tokens to principals
+type StaticTokens map[string]string
+
+// Authenticate is to look up the bearer token
+func (t StaticTokens) Authenticate(req *Request) (string, error) {
+	if !strings.HasPrefix(req.Authorization, bearerScheme) {
+		return "", ErrNoCredentials
+	}
+
+	token := strings.TrimPrefix(req.Authorization, bearerScheme)
+	for known, principal := range t {
+		if subtle.ConstantTimeCompare([]byte(known), []byte(token)) == 1 {
+			return principal, nil
+		}
+	}
+	return "", fmt.Errorf("auth: unknown bearer token")
+}
+
+// HMACAuthenticator includes secrets by key id, the max clock skew of signed tokens, and nonces seen until their tokens expire
+type HMACAuthenticator struct {
+	secrets map[string][]byte
+	maxSkew time.Duration
+
+	nonces    map[string]time.Time
+	sweptAt   time.Time
+	muForSeen sync.Mutex
+}
+
+// NewHMACAuthenticator is to create authenticator of tokens signed by secrets of key ids, whose principal is the key id,
+// maxSkew 0 means 5 minutes. Each token authenticates one call, a token seen before is rejected as a replay.
+// The token signs the service method but not the args, so HMAC must run over tls to keep the args from being tampered with
+func NewHMACAuthenticator(secrets map[string][]byte, maxSkew time.Duration) *HMACAuthenticator {
+	if maxSkew <= 0 {
+		maxSkew = defaultMaxSkew
+	}
+	return &HMACAuthenticator{secrets: secrets, maxSkew: maxSkew, nonces: make(map[string]time.Time)}
+}
+
+// Authenticate is to verify the signature, timestamp and nonce of the signed token
+func (a *HMACAuthenticator) Authenticate(req *Request) (string, error) {
+	if !strings.HasPrefix(req.Authorization, hmacScheme) {
+		return "", ErrNoCredentials
+	}
+
+	parts := strings.Split(strings.TrimPrefix(req.Authorization, hmacScheme), ":")
+	if len(parts) != 4 || parts[2] == "" {
+		return "", fmt.Errorf("auth: hmac token format not correct")
+	}
+	id, timestamp, nonce, signature := parts[0], parts[1], parts[2], parts[3]
+
+	secret, ok := a.secrets[id]
+	if !ok {
+		return "", fmt.Errorf("auth: unknown hmac key id %s", id)
+	}
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("auth: hmac token timestamp not correct")
+	}
+	if skew := time.Since(time.Unix(unix, 0)); skew > a.maxSkew || skew < -a.maxSkew {
+		return "", fmt.Errorf("auth: hmac token expired")
+	}
+
+	expected := sign(id, timestamp, nonce, req.ServiceMethod, secret)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return "", fmt.Errorf("auth: hmac token signature not correct")
+	}
+	// only verified tokens are remembered, until they expire by timestamp
+	if !a.remember(id+":"+nonce, time.Unix(unix, 0).Add(a.maxSkew)) {
+		return "", fmt.Errorf("auth: hmac token replayed")
+	}
+	return id, nil
+}
+
+// remember is to record nonce until expiry, false if it has been seen, expired nonces are swept once per max skew
+func (a *HMACAuthenticator) remember(nonce string, expiry time.Time) bool {
+	a.muForSeen.Lock()
+	defer a.muForSeen.Unlock()
+
+	now := time.Now()
+	if now.Sub(a.sweptAt) > a.maxSkew {
+		for seen, seenExpiry := range a.nonces {
+			if now.After(seenExpiry) {
+				delete(a.nonces, seen)
+			}
+		}
+		a.sweptAt = now
+	}
+
+	if _, ok := a.nonces[nonce]; ok {
+		return false
+	}
+	a.nonces[nonce] = expiry
+	return true
+}
+
+// MTLSAuthenticator authenticates by verified client identity of mutual tls
+type MTLSAuthenticator struct{}
+
+// Authenticate is to take the verified client identity as principal
+func (MTLSAuthenticator) Authenticate(req *Request) (string, error) {
+	if req.Identity == "" {
+		return "", ErrNoCredentials
+	}
+	return req.Identity, nil
+}
+
+// Chain is to try authenticators in order until one understands the credentials
+func Chain(authenticators ...Authenticator) Authenticator {
+	return AuthenticatorFunc(func(req *Request) (string, error) {
+		for _, authenticator := range authenticators {
+			principal, err := authenticator.Authenticate(req)
+			if err == ErrNoCredentials {
+				continue
+			}
+			return principal, err
+		}
+		return "", ErrNoCredentials
+	})
+}
+
+// Credentials is to get authorization sent with every call
+type Credentials interface {
+	Authorization(serviceMethod string) (string, error)
+}
+
+// BearerToken is a static bearer token
+type BearerToken string
+
+// Authorization is to get bearer authorization
+func (t BearerToken) Authorization(serviceMethod string) (string, error) {
+	return bearerScheme + string(t), nil
+}
+
+// HMACCredentials includes key id and secret signing every call
+type HMACCredentials struct {
+	ID     string
+	Secret []byte
+}
+
+// Authorization is to sign service method at now
+func (c HMACCredentials) Authorization(serviceMethod string) (string, error) {
+	return SignHMAC(c.ID, c.Secret, serviceMethod, time.Now()), nil
+}
+
+// SignHMAC is to get hmac authorization of service method signed by secret of key id at time with a random nonce,
+// which authenticates one call
+func SignHMAC(id string, secret []byte, serviceMethod string, at time.Time) string {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	nonce := newNonce()
+	return hmacScheme + id + ":" + timestamp + ":" + nonce + ":" + sign(id, timestamp, nonce, serviceMethod, secret)
+}
+
+func sign(id, timestamp, nonce, serviceMethod string, secret []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	_, _ = mac.Write([]byte(id + ":" + timestamp + ":" + nonce + ":" + serviceMethod))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// newNonce is to get 16 random bytes in hex, nonces only need to be unique as they are signed
+func newNonce() string {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		// crypto rand never fails on supported platforms, fall back to time to keep nonces unique enough
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(nonce)
+}
+
+type principalKey struct{}
+
+// ContextWithPrincipal is to carry authenticated principal of a call
+func ContextWithPrincipal(ctx context.Context, principal string) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFromContext is to get authenticated principal of the call carried by ctx
+func PrincipalFromContext(ctx context.Context) (string, bool) {
+	principal, ok := ctx.Value(principalKey{}).(string)
+	return principal, ok
+}
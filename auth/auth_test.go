@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+var testSecrets = map[string][]byte{"alice": []byte("alice secret")}
+
+func TestStaticTokens(t *testing.T) {
+	tokens := StaticTokens{"token": "alice"}
+
+	tests := []struct {
+		name          string
+		authorization string
+		wantPrincipal string
+		wantErr       bool
+	}{
+		{"known token", "Bearer token", "alice", false},
+		{"unknown token", "Bearer other", "", true},
+		{"other scheme", SignHMAC("alice", testSecrets["alice"], "Foo.Sum", time.Now()), "", true},
+		{"no credentials", "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			principal, err := tokens.Authenticate(&Request{ServiceMethod: "Foo.Sum", Authorization: tt.authorization})
+			if principal != tt.wantPrincipal || (err != nil) != tt.wantErr {
+				t.Fatalf("Authenticate = %q, %v, want %q and error %v", principal, err, tt.wantPrincipal, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHMACAuthenticator(t *testing.T) {
+	tests := []struct {
+		name          string
+		authorization string
+		wantPrincipal string
+		wantErr       bool
+	}{
+		{"signed now", SignHMAC("alice", testSecrets["alice"], "Foo.Sum", time.Now()), "alice", false},
+		{"signed within skew", SignHMAC("alice", testSecrets["alice"], "Foo.Sum", time.Now().Add(-30*time.Second)), "alice", false},
+		{"expired", SignHMAC("alice", testSecrets["alice"], "Foo.Sum", time.Now().Add(-2*time.Minute)), "", true},
+		{"signed in future", SignHMAC("alice", testSecrets["alice"], "Foo.Sum", time.Now().Add(2*time.Minute)), "", true},
+		{"wrong secret", SignHMAC("alice", []byte("wrong"), "Foo.Sum", time.Now()), "", true},
+		{"unknown key id", SignHMAC("bob", testSecrets["alice"], "Foo.Sum", time.Now()), "", true},
+		{"other method", SignHMAC("alice", testSecrets["alice"], "Foo.Div", time.Now()), "", true},
+		{"without nonce", "HMAC alice:1:signature", "", true},
+		{"malformed", "HMAC alice", "", true},
+	}
+
+	a := NewHMACAuthenticator(testSecrets, time.Minute)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			principal, err := a.Authenticate(&Request{ServiceMethod: "Foo.Sum", Authorization: tt.authorization})
+			if principal != tt.wantPrincipal || (err != nil) != tt.wantErr {
+				t.Fatalf("Authenticate = %q, %v, want %q and error %v", principal, err, tt.wantPrincipal, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHMACReplay(t *testing.T) {
+	a := NewHMACAuthenticator(testSecrets, time.Minute)
+	credentials := HMACCredentials{ID: "alice", Secret: testSecrets["alice"]}
+
+	authorization, _ := credentials.Authorization("Foo.Sum")
+	if _, err := a.Authenticate(&Request{ServiceMethod: "Foo.Sum", Authorization: authorization}); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+	if _, err := a.Authenticate(&Request{ServiceMethod: "Foo.Sum", Authorization: authorization}); err == nil {
+		t.Fatalf("replayed token authenticated")
+	}
+
+	// every call signs a fresh nonce
+	authorization, _ = credentials.Authorization("Foo.Sum")
+	if _, err := a.Authenticate(&Request{ServiceMethod: "Foo.Sum", Authorization: authorization}); err != nil {
+		t.Fatalf("fresh token: %v", err)
+	}
+}
+
+func TestChain(t *testing.T) {
+	chain := Chain(StaticTokens{"token": "bob"}, NewHMACAuthenticator(testSecrets, 0), MTLSAuthenticator{})
+
+	tests := []struct {
+		name          string
+		req           *Request
+		wantPrincipal string
+		wantErr       error
+	}{
+		{"bearer", &Request{ServiceMethod: "Foo.Sum", Authorization: "Bearer token"}, "bob", nil},
+		{"hmac", &Request{ServiceMethod: "Foo.Sum", Authorization: SignHMAC("alice", testSecrets["alice"], "Foo.Sum", time.Now())}, "alice", nil},
+		{"mtls identity", &Request{ServiceMethod: "Foo.Sum", Identity: "carol"}, "carol", nil},
+		{"no credentials", &Request{ServiceMethod: "Foo.Sum"}, "", ErrNoCredentials},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			principal, err := chain.Authenticate(tt.req)
+			if principal != tt.wantPrincipal || !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Authenticate = %q, %v, want %q, %v", principal, err, tt.wantPrincipal, tt.wantErr)
+			}
+		})
+	}
+
+	// a rejected credential is not passed on to the next authenticator
+	if _, err := chain.Authenticate(&Request{ServiceMethod: "Foo.Sum", Authorization: "Bearer other", Identity: "carol"}); err == nil {
+		t.Fatalf("unknown bearer token fell through to mtls")
+	}
+}
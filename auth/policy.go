@@ -0,0 +1,36 @@
+package auth
+
+import (
+	"path"
+)
+
+// AnyPrincipal is the principal whose patterns are allowed for every authenticated principal
+const AnyPrincipal = "*"
+
+// Policy maps principals to allowed "Service.Method" patterns like "Foo.Sum", "Foo.*" or "*"
+type Policy struct {
+	rules map[string][]string
+}
+
+// NewPolicy is to create policy from rules of principals and their allowed patterns, a call is denied unless matched
+func NewPolicy(rules map[string][]string) *Policy {
+	copied := make(map[string][]string, len(rules))
+	for principal, patterns := range rules {
+		copied[principal] = append([]string(nil), patterns...)
+	}
+	return &Policy{rules: copied}
+}
+
+// Allow is to check whether principal may call service method
+func (p *Policy) Allow(principal, serviceMethod string) bool {
+	return matchAny(p.rules[principal], serviceMethod) || matchAny(p.rules[AnyPrincipal], serviceMethod)
+}
+
+func matchAny(patterns []string, serviceMethod string) bool {
+	for _, pattern := range patterns {
+		if ok, err := path.Match(pattern, serviceMethod); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"testing"
+)
+
+func TestPolicyAllow(t *testing.T) {
+	policy := NewPolicy(map[string][]string{
+		"alice":      {"Foo.*"},
+		"bob":        {"Foo.Sum", "Bar.Get"},
+		"admin":      {"*"},
+		AnyPrincipal: {"gingle.Health.*"},
+	})
+
+	tests := []struct {
+		principal     string
+		serviceMethod string
+		want          bool
+	}{
+		{"alice", "Foo.Sum", true},
+		{"alice", "Foo.Div", true},
+		{"alice", "Bar.Get", false},
+		{"bob", "Foo.Sum", true},
+		{"bob", "Foo.Div", false},
+		{"bob", "Bar.Get", true},
+		{"admin", "Bar.Put", true},
+		{"admin", "gingle.Reflection.ListServices", true},
+		{"carol", "Foo.Sum", false},
+		{"carol", "gingle.Health.Check", true},
+		{"alice", "gingle.Health.Watch", true},
+		{"", "Foo.Sum", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.principal+" "+tt.serviceMethod, func(t *testing.T) {
+			if got := policy.Allow(tt.principal, tt.serviceMethod); got != tt.want {
+				t.Fatalf("Allow(%q, %q) = %v, want %v", tt.principal, tt.serviceMethod, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyCopiesRules(t *testing.T) {
+	rules := map[string][]string{"alice": {"Foo.*"}}
+	policy := NewPolicy(rules)
+	rules["alice"][0] = "*"
+	rules["bob"] = []string{"*"}
+
+	if policy.Allow("alice", "Bar.Get") || policy.Allow("bob", "Bar.Get") {
+		t.Fatalf("policy changed with the rules it was created from")
+	}
+}
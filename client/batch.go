@@ -0,0 +1,70 @@
+package client
+
+import (
+	"context"
+	"gingle-rpc/codec"
+	"gingle-rpc/status"
+	"time"
+)
+
+// BatchCall includes service method, args, reply and error of one call in a batch
+type BatchCall struct {
+	ServiceMethod string
+	Args          interface{}
+	Reply         interface{}
+	Error         error
+}
+
+// Batch is to invoke calls in one round trip, in order if ordered or in parallel otherwise, the error of each call is set to
+// its Error and the returned error fails the whole batch, e.g. more calls than the server limit, streaming methods are not supported
+func (c *Client) Batch(ctx context.Context, calls []*BatchCall, ordered bool) error {
+	marshal, unmarshal := codec.MarshalFuncMap[c.opt.CodecType], codec.UnmarshalFuncMap[c.opt.CodecType]
+	if marshal == nil || unmarshal == nil {
+		return status.Errorf(status.Unimplemented, "client: failed to call batch, err: codec %s does not support batches", c.opt.CodecType)
+	}
+
+	request := &codec.BatchRequest{Items: make([]codec.BatchItem, len(calls)), Ordered: ordered}
+	for i, call := range calls {
+		args, err := marshal(call.Args)
+		if err != nil {
+			return status.Errorf(status.Internal, "client: failed to marshal batch args of %s, err: %v", call.ServiceMethod, err)
+		}
+		// each call is authorized on its own as credentials may sign the service method
+		authorization, err := c.authorize(call.ServiceMethod)
+		if err != nil {
+			return err
+		}
+		if authorization == "" {
+			authorization = authorizationFromContext(ctx)
+		}
+		request.Items[i] = codec.BatchItem{ServiceMethod: call.ServiceMethod, Args: args, Authorization: authorization}
+	}
+
+	reply := &codec.BatchReply{}
+	if err := c.invoke(ctx, &Call{
+		ServiceMethod: codec.BatchServiceMethod,
+		Args:          request,
+		Reply:         reply,
+		Done:          make(chan *Call, 1),
+		startAt:       time.Now(),
+		flags:         codec.FlagBatch,
+	}); err != nil {
+		return err
+	}
+	if len(reply.Results) != len(calls) {
+		return status.Errorf(status.Internal, "client: failed to call batch, err: %d results for %d calls", len(reply.Results), len(calls))
+	}
+
+	for i, result := range reply.Results {
+		if result.Error != nil {
+			calls[i].Error = result.Error
+			continue
+		}
+		if calls[i].Reply != nil && result.Reply != nil {
+			if err := unmarshal(result.Reply, calls[i].Reply); err != nil {
+				calls[i].Error = status.Errorf(status.Internal, "client: failed to unmarshal batch reply, err: %v", err)
+			}
+		}
+	}
+	return nil
+}
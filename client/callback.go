@@ -0,0 +1,145 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"gingle-rpc/codec"
+	"gingle-rpc/logger"
+	"gingle-rpc/service"
+	"gingle-rpc/status"
+	"reflect"
+	"strings"
+)
+
+// RegisterService is to register service whose methods the server calls back over this connection,
+// streaming methods are not supported by callbacks
+func (c *Client) RegisterService(instance interface{}) error {
+	c.muForCall.Lock()
+	l := c.logger
+	c.muForCall.Unlock()
+
+	svc, err := service.NewService(instance, l)
+	if err != nil {
+		return err
+	}
+	if _, ok := c.services.LoadOrStore(svc.Name, svc); ok {
+		return fmt.Errorf("client: service %s already defined", svc.Name)
+	}
+	return nil
+}
+
+func (c *Client) retrieveService(serviceMethod string) (*service.Service, *service.RpcMethod, error) {
+	dot := strings.LastIndex(serviceMethod, ".")
+	if dot < 0 {
+		return nil, nil, status.Errorf(status.InvalidArgument, "client: service.method %s format not correct", serviceMethod)
+	}
+
+	svcInterface, ok := c.services.Load(serviceMethod[:dot])
+	if !ok {
+		return nil, nil, status.Errorf(status.NotFound, "client: service.method %s service not found", serviceMethod)
+	}
+	svc := svcInterface.(*service.Service)
+
+	rpcMethod, ok := svc.RpcMethods[serviceMethod[dot+1:]]
+	if !ok {
+		return nil, nil, status.Errorf(status.NotFound, "client: service.method %s method not found", serviceMethod)
+	}
+	if rpcMethod.ServerStreaming {
+		return nil, nil, status.Errorf(status.Unimplemented, "client: service.method %s streams cannot be called back", serviceMethod)
+	}
+	return svc, rpcMethod, nil
+}
+
+// receiveCallback is to read a callback request from the server and call the registered method without blocking the connection,
+// the response is written back unless the callback is one way
+func (c *Client) receiveCallback(header *codec.Header) error {
+	svc, rpcMethod, err := c.retrieveService(header.ServiceMethod)
+	if err != nil {
+		readErr := c.cc.ReadBody(nil)
+		go c.respondCallback(header, struct{}{}, err)
+		return readErr
+	}
+
+	argsValue := rpcMethod.NewArgsValue()
+	argsInterface := argsValue.Interface()
+	if argsValue.Type().Kind() != reflect.Ptr {
+		argsInterface = argsValue.Addr().Interface()
+	}
+	if err := c.cc.ReadBody(argsInterface); err != nil {
+		go c.respondCallback(header, struct{}{}, status.Errorf(status.InvalidArgument, "client: failed to read callback body, err: %v", err))
+		return err
+	}
+
+	handle := func() {
+		replyValue := rpcMethod.NewReplyValue()
+		err := svc.CallMethod(context.Background(), rpcMethod, argsValue, replyValue)
+		if err != nil {
+			rpcMethod.RecordError(service.ErrorKind(err))
+		}
+		if err != nil || !replyValue.IsValid() {
+			c.respondCallback(header, struct{}{}, err)
+			return
+		}
+		c.respondCallback(header, replyValue.Interface(), nil)
+	}
+	if header.Flags&codec.FlagOneWay != 0 {
+		c.notify(handle)
+	} else {
+		go handle()
+	}
+	return nil
+}
+
+// notify is to queue the handling of a one way callback, which runs in order of arrival so that pushed messages keep their order
+func (c *Client) notify(handle func()) {
+	c.muForNotify.Lock()
+	defer c.muForNotify.Unlock()
+
+	c.notifications = append(c.notifications, handle)
+	if !c.notifying {
+		c.notifying = true
+		go c.runNotifications()
+	}
+}
+
+func (c *Client) runNotifications() {
+	for {
+		c.muForNotify.Lock()
+		if len(c.notifications) == 0 {
+			c.notifying = false
+			c.muForNotify.Unlock()
+			return
+		}
+		handle := c.notifications[0]
+		c.notifications = c.notifications[1:]
+		c.muForNotify.Unlock()
+
+		handle()
+	}
+}
+
+// respondCallback is to write the response of a callback, which is only logged if the callback is one way
+func (c *Client) respondCallback(header *codec.Header, reply interface{}, err error) {
+	c.muForCall.Lock()
+	l := c.logger
+	c.muForCall.Unlock()
+
+	if header.Flags&codec.FlagOneWay != 0 {
+		if err != nil {
+			l.Log(logger.Warn, "client: failed to handle one way callback", logger.Method(header.ServiceMethod), logger.Seq(header.SequenceNumber),
+				logger.Peer(c.peer), logger.Err(err))
+		}
+		return
+	}
+
+	response := &codec.Header{
+		ServiceMethod:  header.ServiceMethod,
+		SequenceNumber: header.SequenceNumber,
+		Flags:          codec.FlagCallback,
+	}
+	response.SetError(err)
+	if err := c.writeFrame(response, reply); err != nil {
+		l.Log(logger.Error, "client: failed to respond callback", logger.Method(header.ServiceMethod), logger.Seq(header.SequenceNumber),
+			logger.Peer(c.peer), logger.Err(err))
+	}
+}
@@ -3,11 +3,16 @@ package client
 import (
 	"bufio"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"gingle-rpc/auth"
 	"gingle-rpc/codec"
+	"gingle-rpc/logger"
+	"gingle-rpc/status"
+	"gingle-rpc/trace"
+	"gingle-rpc/websocket"
 	"io"
-	"log"
 	"net"
 	"net/http"
 	"strings"
@@ -18,6 +23,7 @@ import (
 const (
 	defaultHandlePath = "/gingle/handle"
 	defaultDebugPath  = "/gingle/debug"
+	defaultWSPath     = "/gingle/ws"
 )
 
 // Call includes service method, sequence number, args, reply, error and done
@@ -30,15 +36,30 @@ type Call struct {
 
 	Error error
 	Done  chan *Call
+
+	startAt       time.Time
+	traceparent   string
+	authorization string // forwarded by ctx, used if client has no credentials
+	flags         uint8
+	metrics       *Metrics
+	stream        *Stream
 }
 
 func (c *Call) done() {
+	if c.metrics != nil {
+		c.metrics.recordDone(c)
+	}
+	if c.stream != nil {
+		c.stream.finish(c.Error)
+	}
 	c.Done <- c
 }
 
-// Client includes sequence number, codec params, mutexs and states
+// Client includes sequence number, peer address, codec params, mutexs, states, metrics, tracer, logger, credentials,
+// services called back by server and one way callbacks waiting to be handled in order
 type Client struct {
-	seq uint64
+	seq  uint64
+	peer string
 
 	cc     codec.Codec
 	opt    *codec.Option
@@ -51,6 +72,16 @@ type Client struct {
 	pending  map[uint64]*Call
 	shutdown bool
 	closing  bool
+
+	metrics     *Metrics
+	tracer      *trace.Tracer
+	logger      logger.Logger
+	credentials auth.Credentials
+
+	services      sync.Map
+	notifications []func()
+	notifying     bool
+	muForNotify   sync.Mutex
 }
 
 var _ io.Closer = (*Client)(nil)
@@ -58,26 +89,33 @@ var _ io.Closer = (*Client)(nil)
 // NewClientFunc is to create client with connection and option
 type NewClientFunc func(net.Conn, *codec.Option) (*Client, error)
 
-// NewRPCClient is to create rpc client
+// NewRPCClient is to create rpc client, which logs by the option logger or the default logger
 func NewRPCClient(conn net.Conn, opt *codec.Option) (*Client, error) {
+	l := logger.OrDefault(opt.Logger)
+	peer := conn.RemoteAddr().String()
+
 	fn := codec.NewCodecFuncMap[opt.CodecType]
 	if fn == nil {
-		errMsg := "client: failed to generate codec func"
-		log.Printf(errMsg + "\n")
-		return nil, fmt.Errorf(errMsg)
+		err := fmt.Errorf("client: failed to generate codec func")
+		l.Log(logger.Error, err.Error(), logger.Peer(peer), logger.Any("codec", opt.CodecType))
+		return nil, err
 	}
 
 	if err := json.NewEncoder(conn).Encode(opt); err != nil {
-		errMsg := fmt.Sprintf("client: failed to decode option, err: %v", err)
-		log.Printf(errMsg + "\n")
-		return nil, fmt.Errorf(errMsg)
+		l.Log(logger.Error, "client: failed to encode option", logger.Peer(peer), logger.Err(err))
+		return nil, fmt.Errorf("client: failed to encode option, err: %v", err)
 	}
 
 	client := &Client{
 		seq:     1,
+		peer:    peer,
 		cc:      fn(conn),
 		opt:     opt,
 		pending: make(map[uint64]*Call),
+		logger:  l,
+	}
+	if setter, ok := client.cc.(codec.LoggerSetter); ok {
+		setter.SetLogger(l)
 	}
 	go client.receive()
 
@@ -99,6 +137,64 @@ func NewHTTPClient(conn net.Conn, opt *codec.Option) (*Client, error) {
 	return nil, err
 }
 
+// NewWebSocketClientFunc is to create client func opening websocket at path of host before creating rpc client,
+// each frame is carried by a websocket message of its own
+func NewWebSocketClientFunc(host, path string) NewClientFunc {
+	return func(conn net.Conn, opt *codec.Option) (*Client, error) {
+		wsConn, err := websocket.Handshake(conn, host, path)
+		if err != nil {
+			return nil, err
+		}
+		return NewRPCClient(wsConn, opt)
+	}
+}
+
+// SetMetrics is to collect metrics of later calls into m
+func (c *Client) SetMetrics(m *Metrics) {
+	c.muForCall.Lock()
+	defer c.muForCall.Unlock()
+
+	c.metrics = m
+}
+
+// SetTracer is to trace later calls by tracer
+func (c *Client) SetTracer(tracer *trace.Tracer) {
+	c.muForCall.Lock()
+	defer c.muForCall.Unlock()
+
+	c.tracer = tracer
+}
+
+// SetLogger is to log later calls by l, nil means the default logger
+func (c *Client) SetLogger(l logger.Logger) {
+	c.muForCall.Lock()
+	defer c.muForCall.Unlock()
+
+	c.logger = logger.OrDefault(l)
+}
+
+// SetCredentials is to authorize later calls by credentials, nil means no authorization
+func (c *Client) SetCredentials(credentials auth.Credentials) {
+	c.muForCall.Lock()
+	defer c.muForCall.Unlock()
+
+	c.credentials = credentials
+}
+
+type authorizationKey struct{}
+
+// ContextWithAuthorization is to carry authorization forwarded by calls made with ctx by clients without credentials,
+// e.g. the authorization of the caller of a gateway
+func ContextWithAuthorization(ctx context.Context, authorization string) context.Context {
+	return context.WithValue(ctx, authorizationKey{}, authorization)
+}
+
+// authorizationFromContext is to get the authorization forwarded by ctx, empty if none
+func authorizationFromContext(ctx context.Context) string {
+	authorization, _ := ctx.Value(authorizationKey{}).(string)
+	return authorization
+}
+
 // IsAvailable is to check whether client works
 func (c *Client) IsAvailable() bool {
 	c.muForCall.Lock()
@@ -112,55 +208,161 @@ func (c *Client) Close() error {
 	c.muForCall.Lock()
 	defer c.muForCall.Unlock()
 
-	if !c.IsAvailable() {
+	if c.closing || c.shutdown {
 		return fmt.Errorf("client: failed to close connection, err: connection has already been closed or shut down")
 	}
 	c.closing = true
 	return c.cc.Close()
 }
 
-// Go is to invoke the named function asynchronously
+// Go is to invoke the named function asynchronously, done must be buffered since calls are never waited to be received,
+// an unbuffered one is replaced by a buffered one on which the call fails at once
 func (c *Client) Go(serviceMethod string, args, reply interface{}, done chan *Call) *Call {
-	if done == nil {
-		done = make(chan *Call)
-	} else if cap(done) == 0 {
-		log.Panic("client: done channel is unbuffered")
-	}
-
 	call := &Call{
 		ServiceMethod: serviceMethod,
 		Args:          args,
 		Reply:         reply,
 		Done:          done,
+		startAt:       time.Now(),
+	}
+	if done == nil {
+		call.Done = make(chan *Call, 1)
+	} else if cap(done) == 0 {
+		call.Done = make(chan *Call, 1)
+		call.Error = status.Errorf(status.InvalidArgument, "client: failed to call, err: done channel is unbuffered")
+		call.done()
+		return call
 	}
 
-	c.send(call)
+	_ = c.send(call)
 	return call
 }
 
-// Call is to invoke the named function and wait for it to complete
-func (c *Client) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+// Call is to invoke the named function and wait for it to complete, as the child span of span context carried by ctx
+func (c *Client) Call(ctx context.Context, serviceMethod string, args, reply interface{}) (err error) {
+	return c.invoke(ctx, &Call{
+		ServiceMethod: serviceMethod,
+		Args:          args,
+		Reply:         reply,
+		Done:          make(chan *Call, 1),
+		startAt:       time.Now(),
+	})
+}
+
+// invoke is to send call and wait for it to complete, as the child span of span context carried by ctx,
+// or carrying the span context as is without tracer
+func (c *Client) invoke(ctx context.Context, call *Call) (err error) {
+	serviceMethod := call.ServiceMethod
+	call.authorization = authorizationFromContext(ctx)
+
+	c.muForCall.Lock()
+	tracer, l := c.tracer, c.logger
+	c.muForCall.Unlock()
+	defer func() {
+		l.Log(logger.Debug, "client: finished call", logger.Method(serviceMethod), logger.Seq(call.SequenceNumber),
+			logger.Peer(c.peer), logger.Latency(time.Since(call.startAt)), logger.Err(err))
+	}()
+	if tracer != nil {
+		var span *trace.Span
+		ctx, span = tracer.Start(ctx, serviceMethod, trace.Client)
+		span.Peer = c.peer
+		call.traceparent = span.Context().Traceparent()
+		defer func() { span.Finish(err) }()
+	} else if sc, ok := trace.SpanContextFromContext(ctx); ok {
+		call.traceparent = sc.Traceparent()
+	}
+
 	// handle client timeout for call by customed context
-	call := c.Go(serviceMethod, args, reply, make(chan *Call, 1))
+	_ = c.send(call)
 	select {
 	case <-ctx.Done():
-		c.cancelCall(call.SequenceNumber)
-		return fmt.Errorf("client: failed to call, err: %v", ctx.Err())
+		err := status.Errorf(status.CodeOf(ctx.Err()), "client: failed to call, err: %v", ctx.Err())
+		if call := c.cancelCall(call.SequenceNumber); call != nil {
+			call.Error = err
+			call.done()
+		}
+		return err
 	case call := <-call.Done:
 		return call.Error
 	}
 }
 
+// Notify is to invoke the named function one way, which returns once the request is written,
+// the server sends nothing back so that neither reply nor handler error is received
+func (c *Client) Notify(ctx context.Context, serviceMethod string, args interface{}) (err error) {
+	c.muForCall.Lock()
+	tracer, l := c.tracer, c.logger
+	c.muForCall.Unlock()
+
+	var seq uint64
+	startAt := time.Now()
+	defer func() {
+		l.Log(logger.Debug, "client: notified", logger.Method(serviceMethod), logger.Seq(seq),
+			logger.Peer(c.peer), logger.Latency(time.Since(startAt)), logger.Err(err))
+	}()
+
+	var traceparent string
+	if tracer != nil {
+		var span *trace.Span
+		ctx, span = tracer.Start(ctx, serviceMethod, trace.Client)
+		span.Peer = c.peer
+		traceparent = span.Context().Traceparent()
+		defer func() { span.Finish(err) }()
+	} else if sc, ok := trace.SpanContextFromContext(ctx); ok {
+		traceparent = sc.Traceparent()
+	}
+	if err := ctx.Err(); err != nil {
+		return status.Errorf(status.CodeOf(err), "client: failed to notify, err: %v", err)
+	}
+
+	authorization, err := c.authorize(serviceMethod)
+	if err != nil {
+		return err
+	}
+	if authorization == "" {
+		authorization = authorizationFromContext(ctx)
+	}
+
+	c.muForCodec.Lock()
+	defer c.muForCodec.Unlock()
+
+	// take a sequence number for logs without keeping a pending call
+	c.muForCall.Lock()
+	if c.closing || c.shutdown {
+		c.muForCall.Unlock()
+		return status.Errorf(status.Unavailable, "client: failed to notify, err: connection has already been closed or shut down")
+	}
+	seq = c.seq
+	c.seq++
+	c.muForCall.Unlock()
+
+	header := &codec.Header{
+		ServiceMethod:  serviceMethod,
+		SequenceNumber: seq,
+		Flags:          codec.FlagOneWay,
+		Traceparent:    traceparent,
+		Authorization:  authorization,
+	}
+	if err := c.cc.Write(header, args); err != nil {
+		return status.Errorf(status.Unavailable, "client: failed to notify, err: %v", err)
+	}
+	return nil
+}
+
 func (c *Client) registerCall(call *Call) (uint64, error) {
 	c.muForCall.Lock()
 	defer c.muForCall.Unlock()
 
-	if !c.IsAvailable() {
-		return 0, fmt.Errorf("client: failed to close connection, err: connection has already been closed or shut down")
+	if c.closing || c.shutdown {
+		return 0, status.Errorf(status.Unavailable, "client: failed to call, err: connection has already been closed or shut down")
 	}
 	call.SequenceNumber = c.seq
+	call.metrics = c.metrics
 	c.pending[call.SequenceNumber] = call
 	c.seq++
+	if c.metrics != nil {
+		c.metrics.addPending(1)
+	}
 	return call.SequenceNumber, nil
 }
 
@@ -168,27 +370,48 @@ func (c *Client) cancelCall(seq uint64) *Call {
 	c.muForCall.Lock()
 	defer c.muForCall.Unlock()
 
-	call := c.pending[seq]
+	call, ok := c.pending[seq]
+	if ok && call.metrics != nil {
+		call.metrics.addPending(-1)
+	}
 	delete(c.pending, seq)
 	return call
 }
 
-func (c *Client) send(call *Call) {
-	c.muForCall.Lock()
-	defer c.muForCall.Unlock()
+// send is to register, sign and write call, and return the error it has completed the call with if any
+func (c *Client) send(call *Call) error {
+	c.muForCodec.Lock()
+	defer c.muForCodec.Unlock()
 
 	// register this call
 	seq, err := c.registerCall(call)
 	if err != nil {
 		call.Error = err
 		call.done()
-		return
+		return err
+	}
+
+	// sign this call
+	authorization, err := c.authorize(call.ServiceMethod)
+	if err != nil {
+		if call := c.cancelCall(seq); call != nil {
+			call.Error = err
+			call.done()
+			return err
+		}
+		return nil
 	}
 
 	// prepare request header
 	c.header.ServiceMethod = call.ServiceMethod
 	c.header.SequenceNumber = seq
-	c.header.Error = ""
+	c.header.Flags = call.flags
+	c.header.SetError(nil)
+	c.header.Traceparent = call.traceparent
+	c.header.Authorization = authorization
+	if authorization == "" {
+		c.header.Authorization = call.authorization
+	}
 
 	// prepare request body
 	c.body = call.Args
@@ -197,11 +420,27 @@ func (c *Client) send(call *Call) {
 	if err := c.cc.Write(&c.header, c.body); err != nil {
 		call := c.cancelCall(seq)
 		if call != nil {
-			call.Error = err
+			call.Error = status.Errorf(status.Unavailable, "client: failed to send request, err: %v", err)
 			call.done()
-			return
+			return call.Error
 		}
 	}
+	return nil
+}
+
+func (c *Client) authorize(serviceMethod string) (string, error) {
+	c.muForCall.Lock()
+	credentials := c.credentials
+	c.muForCall.Unlock()
+
+	if credentials == nil {
+		return "", nil
+	}
+	authorization, err := credentials.Authorization(serviceMethod)
+	if err != nil {
+		return "", status.Errorf(status.Unauthenticated, "client: failed to authorize, err: %v", err)
+	}
+	return authorization, nil
 }
 
 func (c *Client) receive() {
@@ -214,6 +453,18 @@ func (c *Client) receive() {
 			break
 		}
 
+		// dispatch callback request from server
+		if header.Flags&codec.FlagCallback != 0 {
+			err = c.receiveCallback(header)
+			continue
+		}
+
+		// dispatch stream frame
+		if header.Flags&codec.FlagStream != 0 {
+			err = c.receiveStream(header)
+			continue
+		}
+
 		// cancel this call
 		call := c.cancelCall(header.SequenceNumber)
 
@@ -221,14 +472,14 @@ func (c *Client) receive() {
 		switch {
 		case call == nil:
 			err = c.cc.ReadBody(nil)
-		case header.Error != "":
-			call.Error = fmt.Errorf(header.Error)
+		case header.Err() != nil:
+			call.Error = header.Err()
 			err = c.cc.ReadBody(nil)
 			call.done()
 		default:
 			err = c.cc.ReadBody(call.Reply)
 			if err != nil {
-				call.Error = fmt.Errorf("client: failed to read body, err: %v", err)
+				call.Error = status.Errorf(status.Internal, "client: failed to read body, err: %v", err)
 			}
 			call.done()
 		}
@@ -240,8 +491,12 @@ func (c *Client) receive() {
 	defer c.muForCall.Unlock()
 
 	c.shutdown = true
-	for _, call := range c.pending {
-		call.Error = err
+	for seq, call := range c.pending {
+		if call.metrics != nil {
+			call.metrics.addPending(-1)
+		}
+		delete(c.pending, seq)
+		call.Error = status.Errorf(status.Unavailable, "client: connection shut down, err: %v", err)
 		call.done()
 	}
 }
@@ -267,13 +522,20 @@ func parseOptions(opts ...*codec.Option) (*codec.Option, error) {
 	return opt, nil
 }
 
-func dialTimeout(fn NewClientFunc, network, address string, opts ...*codec.Option) (client *Client, err error) {
+// dialTimeout is to connect within connect timeout and create client, over tls if config is not nil
+func dialTimeout(fn NewClientFunc, network, address string, config *tls.Config, opts ...*codec.Option) (client *Client, err error) {
 	opt, err := parseOptions(opts...)
 	if err != nil {
 		return nil, err
 	}
 
-	conn, err := net.DialTimeout(network, address, opt.ConnectTimeout)
+	var conn net.Conn
+	if config != nil {
+		// the handshake is bounded by connect timeout as well
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: opt.ConnectTimeout}, network, address, config)
+	} else {
+		conn, err = net.DialTimeout(network, address, opt.ConnectTimeout)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -305,25 +567,72 @@ func dialTimeout(fn NewClientFunc, network, address string, opts ...*codec.Optio
 
 // DialRPC is to connect the rpc server and parse options
 func DialRPC(network, address string, opts ...*codec.Option) (client *Client, err error) {
-	return dialTimeout(NewRPCClient, network, address, opts...)
+	return dialTimeout(NewRPCClient, network, address, nil, opts...)
 }
 
 // DialHTTP is to connect the http server and parse options
 func DialHTTP(network, address string, opts ...*codec.Option) (client *Client, err error) {
-	return dialTimeout(NewHTTPClient, network, address, opts...)
+	return dialTimeout(NewHTTPClient, network, address, nil, opts...)
 }
 
-// XDial is to choose to access http protocol or rpc protocol
+// DialTLS is to connect the rpc server over tls and parse options, config.Certificates enables mutual tls
+func DialTLS(network, address string, config *tls.Config, opts ...*codec.Option) (client *Client, err error) {
+	return dialTimeout(NewRPCClient, network, address, tlsConfig(config), opts...)
+}
+
+// DialHTTPS is to connect the http server over tls and parse options, config.Certificates enables mutual tls
+func DialHTTPS(network, address string, config *tls.Config, opts ...*codec.Option) (client *Client, err error) {
+	return dialTimeout(NewHTTPClient, network, address, tlsConfig(config), opts...)
+}
+
+// DialWebSocket is to connect the websocket server at path and parse options
+func DialWebSocket(network, address, path string, opts ...*codec.Option) (client *Client, err error) {
+	return dialTimeout(NewWebSocketClientFunc(address, path), network, address, nil, opts...)
+}
+
+// DialWebSocketTLS is to connect the websocket server at path over tls and parse options, config.Certificates enables mutual tls
+func DialWebSocketTLS(network, address, path string, config *tls.Config, opts ...*codec.Option) (client *Client, err error) {
+	return dialTimeout(NewWebSocketClientFunc(address, path), network, address, tlsConfig(config), opts...)
+}
+
+// tlsConfig is to get config, or the default config verifying server by system roots if nil
+func tlsConfig(config *tls.Config) *tls.Config {
+	if config == nil {
+		return &tls.Config{}
+	}
+	return config
+}
+
+// XDial is to choose to access http protocol, websocket or rpc protocol, tls@, https@ and wss@ use tls config of option,
+// ws@host:port/path and wss@host:port/path open websocket at path, the default path if omitted
 func XDial(pattern string, opts ...*codec.Option) (client *Client, err error) {
 	pair := strings.Split(pattern, "@")
 	if len(pair) != 2 {
 		return nil, fmt.Errorf("client: failed to dail, err: dial pattern %s format not correct", pattern)
 	}
 
+	var config *tls.Config
+	if len(opts) > 0 && opts[0] != nil {
+		config = opts[0].TLSConfig
+	}
+
 	protocol, address := pair[0], pair[1]
 	switch protocol {
+	case "tls": // rpc over tls -> tcp
+		return DialTLS("tcp", address, config, opts...)
+	case "https": // http over tls ->
+		return DialHTTPS("tcp", address, config, opts...)
 	case "http": // http ->
 		return DialHTTP("tcp", address, opts...)
+	case "ws", "wss": // websocket over http or https ->
+		path := defaultWSPath
+		if i := strings.Index(address, "/"); i >= 0 {
+			address, path = address[:i], address[i:]
+		}
+		if protocol == "wss" {
+			return DialWebSocketTLS("tcp", address, path, config, opts...)
+		}
+		return DialWebSocket("tcp", address, path, opts...)
 	default: // rpc -> tcp or unix
 		return DialRPC(protocol, address, opts...)
 	}
@@ -0,0 +1,185 @@
+package client
+
+import (
+	"context"
+	"gingle-rpc/service"
+	"gingle-rpc/status"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultHealthPeriod = 10 * time.Second
+
+// xclientHealth includes statuses of services on each server, services to check and stop channel
+type xclientHealth struct {
+	statuses map[string]map[string]service.HealthStatus
+	services map[string]bool
+	stop     chan struct{}
+
+	mu sync.Mutex
+}
+
+func newXClientHealth() *xclientHealth {
+	return &xclientHealth{
+		statuses: make(map[string]map[string]service.HealthStatus),
+		services: map[string]bool{"": true},
+	}
+}
+
+// isHealthy is to check whether both the server and the service are not known to be unhealthy
+func (h *xclientHealth) isHealthy(server, serviceMethod string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	name := serviceName(serviceMethod)
+	h.services[name] = true
+
+	statuses, ok := h.statuses[server]
+	if !ok {
+		return true
+	}
+	return statuses[""] != service.NotServing && statuses[name] != service.NotServing
+}
+
+func (h *xclientHealth) update(server string, statuses map[string]service.HealthStatus) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.statuses[server] = statuses
+}
+
+func (h *xclientHealth) checkedServices() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	services := make([]string, 0, len(h.services))
+	for name := range h.services {
+		services = append(services, name)
+	}
+	return services
+}
+
+func serviceName(serviceMethod string) string {
+	dot := strings.LastIndex(serviceMethod, ".")
+	if dot < 0 {
+		return serviceMethod
+	}
+	return serviceMethod[:dot]
+}
+
+// EnableHealthCheck is to check health of all servers periodically and eject unhealthy ones from selection
+func (xc *XClient) EnableHealthCheck(period time.Duration) {
+	if period == 0 {
+		period = defaultHealthPeriod
+	}
+
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+
+	if xc.health != nil {
+		return
+	}
+	xc.health = newXClientHealth()
+	xc.health.stop = make(chan struct{})
+	go xc.checkHealthPeriodically(xc.health, period)
+}
+
+func (xc *XClient) checkHealthPeriodically(health *xclientHealth, period time.Duration) {
+	t := time.NewTicker(period)
+	defer t.Stop()
+
+	for {
+		xc.checkHealth(health, period)
+
+		select {
+		case <-health.stop:
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// checkHealth is to check the whole server and every called service on all servers, unreachable servers are not serving
+func (xc *XClient) checkHealth(health *xclientHealth, timeout time.Duration) {
+	servers, err := xc.lb.GetAll()
+	if err != nil {
+		return
+	}
+	services := health.checkedServices()
+
+	var wg sync.WaitGroup
+	for _, server := range servers {
+		wg.Add(1)
+		go func(server string) {
+			defer wg.Done()
+
+			select {
+			case <-health.stop:
+				return
+			default:
+			}
+
+			statuses := make(map[string]service.HealthStatus)
+			client, err := xc.Dial(server)
+			if err != nil {
+				statuses[""] = service.NotServing
+				health.update(server, statuses)
+				return
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			for _, name := range services {
+				var reply service.HealthReply
+				if err := client.Call(ctx, service.HealthServiceName+".Check", service.HealthArgs{Service: name}, &reply); err != nil {
+					statuses[""] = service.NotServing
+					break
+				}
+				statuses[name] = reply.Status
+			}
+			health.update(server, statuses)
+		}(server)
+	}
+	wg.Wait()
+}
+
+// selectServer is to get one server by load balance, skipping the ones ejected by health check
+func (xc *XClient) selectServer(serviceMethod string) (string, error) {
+	xc.mu.Lock()
+	health, m := xc.health, xc.metrics
+	xc.mu.Unlock()
+
+	server, err := xc.lb.GetOne(xc.mode)
+	if err != nil || health == nil || health.isHealthy(server, serviceMethod) {
+		if err == nil && m != nil {
+			m.recordSelected(server)
+		}
+		return server, err
+	}
+
+	servers, err := xc.lb.GetAll()
+	if err != nil {
+		return "", err
+	}
+	for i := 0; i < len(servers); i++ {
+		if m != nil {
+			m.recordRetry()
+		}
+		if server, err = xc.lb.GetOne(xc.mode); err == nil && health.isHealthy(server, serviceMethod) {
+			if m != nil {
+				m.recordSelected(server)
+			}
+			return server, nil
+		}
+	}
+	for _, server := range servers {
+		if health.isHealthy(server, serviceMethod) {
+			if m != nil {
+				m.recordSelected(server)
+			}
+			return server, nil
+		}
+	}
+	return "", status.Errorf(status.Unavailable, "client: failed to select server, err: no healthy servers for %s", serviceMethod)
+}
@@ -0,0 +1,78 @@
+package client
+
+import (
+	"gingle-rpc/logger"
+	"gingle-rpc/metrics"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Metrics includes pending calls, retries, calls, errors, latency and selected servers collected on client side
+type Metrics struct {
+	pending int64
+	retries uint64
+
+	calls    metrics.CounterVec   // service method -> calls
+	errors   metrics.CounterVec   // service method -> errors
+	latency  metrics.HistogramVec // service method -> latency
+	selected metrics.CounterVec   // server -> selections
+}
+
+// NewMetrics is to create client side metrics collector
+func NewMetrics() *Metrics {
+	return &Metrics{}
+}
+
+func (m *Metrics) recordDone(call *Call) {
+	m.calls.Add(call.ServiceMethod, 1)
+	if call.Error != nil {
+		m.errors.Add(call.ServiceMethod, 1)
+	}
+	m.latency.Get(call.ServiceMethod).Observe(time.Since(call.startAt))
+}
+
+func (m *Metrics) addPending(delta int64) {
+	atomic.AddInt64(&m.pending, delta)
+}
+
+func (m *Metrics) recordRetry() {
+	atomic.AddUint64(&m.retries, 1)
+}
+
+func (m *Metrics) recordSelected(server string) {
+	m.selected.Add(server, 1)
+}
+
+// ServeHTTP is to write client metrics in prometheus text exposition format
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", metrics.ContentType)
+	if _, err := m.WriteMetrics(w); err != nil {
+		logger.Default().Log(logger.Error, "metrics: failed to serve http", logger.Err(err))
+	}
+}
+
+// WriteMetrics is to write client metrics in prometheus text exposition format
+func (m *Metrics) WriteMetrics(w io.Writer) (int64, error) {
+	mw := metrics.NewWriter(w)
+
+	mw.Header("gingle_client_pending_calls", "Number of calls waiting for replies.", "gauge")
+	mw.Sample("gingle_client_pending_calls", float64(atomic.LoadInt64(&m.pending)))
+	mw.Header("gingle_client_retries_total", "Number of server reselections and reconnections.", "counter")
+	mw.Sample("gingle_client_retries_total", float64(atomic.LoadUint64(&m.retries)))
+	mw.Header("gingle_client_calls_total", "Number of finished calls by service method.", "counter")
+	mw.CounterVec("gingle_client_calls_total", "service_method", &m.calls)
+	mw.Header("gingle_client_errors_total", "Number of failed calls by service method.", "counter")
+	mw.CounterVec("gingle_client_errors_total", "service_method", &m.errors)
+	mw.Header("gingle_client_selected_total", "Number of times each server is selected.", "counter")
+	mw.CounterVec("gingle_client_selected_total", "server", &m.selected)
+
+	mw.Header("gingle_client_latency_seconds", "Latency of calls by service method.", "histogram")
+	snapshots := m.latency.Snapshot()
+	for _, serviceMethod := range metrics.SortedLabels(snapshots) {
+		mw.Histogram("gingle_client_latency_seconds", snapshots[serviceMethod], "service_method", serviceMethod)
+	}
+
+	return mw.Result()
+}
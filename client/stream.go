@@ -0,0 +1,230 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"gingle-rpc/codec"
+	"gingle-rpc/flowcontrol"
+	"gingle-rpc/status"
+	"io"
+	"sync"
+	"time"
+)
+
+// Stream includes call, context, send window, received replies queue and whether sending is closed of a streaming call
+type Stream struct {
+	client *Client
+	call   *Call
+	ctx    context.Context
+
+	window *flowcontrol.Window
+	queue  *flowcontrol.Queue
+
+	sendClosed bool
+	muForSend  sync.Mutex
+}
+
+// NewServerStream is to invoke the named server streaming function, whose replies are received by Recv,
+// the stream ends as well when ctx is done, an error is returned if the call fails to be sent
+func (c *Client) NewServerStream(ctx context.Context, serviceMethod string, args interface{}) (*Stream, error) {
+	stream, err := c.newStream(ctx, serviceMethod, args)
+	if err != nil {
+		return nil, err
+	}
+	stream.sendClosed = true
+	return stream, nil
+}
+
+// NewStream is to invoke the named bidirectional streaming function, whose args are sent by Send until CloseSend
+// and replies are received by Recv, the stream ends as well when ctx is done, an error is returned if the call fails to be sent
+func (c *Client) NewStream(ctx context.Context, serviceMethod string) (*Stream, error) {
+	return c.newStream(ctx, serviceMethod, struct{}{})
+}
+
+func (c *Client) newStream(ctx context.Context, serviceMethod string, args interface{}) (*Stream, error) {
+	stream := &Stream{
+		client: c,
+		ctx:    ctx,
+		window: flowcontrol.NewWindow(flowcontrol.DefaultWindow),
+		queue:  flowcontrol.NewQueue(flowcontrol.DefaultWindow),
+	}
+	stream.call = &Call{
+		ServiceMethod: serviceMethod,
+		Args:          args,
+		Done:          make(chan *Call, 1),
+		startAt:       time.Now(),
+		stream:        stream,
+	}
+
+	if err := c.send(stream.call); err != nil {
+		return nil, err
+	}
+	go stream.watch()
+	return stream, nil
+}
+
+// watch is to cancel the stream once ctx is done before the stream ends
+func (s *Stream) watch() {
+	select {
+	case <-s.ctx.Done():
+		s.cancel(status.Errorf(status.CodeOf(s.ctx.Err()), "client: failed to receive stream, err: %v", s.ctx.Err()))
+	case <-s.call.Done:
+	}
+}
+
+// cancel is to end the stream locally with err and reset it on the server, later frames of the stream are discarded
+func (s *Stream) cancel(err error) {
+	if call := s.client.cancelCall(s.call.SequenceNumber); call != nil {
+		_ = s.client.writeFrame(s.header(codec.FlagReset, 0))
+		s.queue.Abort(err)
+		call.Error = err
+		call.done()
+	}
+}
+
+func (s *Stream) header(flags uint8, window uint32) *codec.Header {
+	return &codec.Header{
+		ServiceMethod:  s.call.ServiceMethod,
+		SequenceNumber: s.call.SequenceNumber,
+		StreamID:       s.call.SequenceNumber,
+		Flags:          codec.FlagStream | flags,
+		Window:         window,
+	}
+}
+
+// Send is to send one args as a stream frame, waiting while the server has not granted window for it,
+// io.EOF once the server ends the stream without error, whose error is returned by Recv
+func (s *Stream) Send(args interface{}) error {
+	s.muForSend.Lock()
+	defer s.muForSend.Unlock()
+
+	if s.sendClosed {
+		return status.Errorf(status.FailedPrecondition, "client: failed to send stream, err: sending already closed")
+	}
+
+	marshal := codec.MarshalFuncMap[s.client.opt.CodecType]
+	if marshal == nil {
+		return status.Errorf(status.Unimplemented, "client: failed to send stream, err: codec %s does not support streams", s.client.opt.CodecType)
+	}
+	message, err := marshal(args)
+	if err != nil {
+		return status.Errorf(status.Internal, "client: failed to marshal stream message, err: %v", err)
+	}
+	if err := s.window.Acquire(s.ctx, message); err != nil {
+		if errors.Is(err, flowcontrol.ErrTooLarge) {
+			return status.Errorf(status.ResourceExhausted, "client: failed to send stream, err: %v", err)
+		}
+		if err == s.ctx.Err() {
+			return status.Errorf(status.CodeOf(err), "client: failed to send stream, err: %v", err)
+		}
+		return err
+	}
+
+	header := s.header(0, 0)
+	return s.client.writeFrame(header, message)
+}
+
+// CloseSend is to half close the stream, after which the server receives io.EOF and replies can still be received
+func (s *Stream) CloseSend() error {
+	s.muForSend.Lock()
+	defer s.muForSend.Unlock()
+
+	if s.sendClosed {
+		return nil
+	}
+	s.sendClosed = true
+	return s.client.writeFrame(s.header(codec.FlagEndStream, 0))
+}
+
+// Recv is to wait for the next reply and copy it into reply, io.EOF after the server ends the stream without error,
+// window is granted back to the server as replies are consumed
+func (s *Stream) Recv(reply interface{}) error {
+	message, grant, err := s.queue.Pop(context.Background())
+	if err != nil {
+		return err
+	}
+
+	if grant > 0 {
+		_ = s.client.writeFrame(s.header(codec.FlagWindowUpdate, uint32(grant)))
+	}
+
+	unmarshal := codec.UnmarshalFuncMap[s.client.opt.CodecType]
+	if unmarshal == nil {
+		return status.Errorf(status.Unimplemented, "client: failed to receive stream, err: codec %s does not support streams", s.client.opt.CodecType)
+	}
+	if err := unmarshal(message, reply); err != nil {
+		return status.Errorf(status.Internal, "client: failed to unmarshal stream message, err: %v", err)
+	}
+	return nil
+}
+
+// Close is to cancel the stream, replies not received yet are dropped
+func (s *Stream) Close() error {
+	s.cancel(status.Errorf(status.Canceled, "client: failed to receive stream, err: stream closed"))
+	return nil
+}
+
+// finish is to end the stream with err, nil meaning io.EOF
+func (s *Stream) finish(err error) {
+	if err == nil {
+		err = io.EOF
+	}
+	s.queue.Close(err)
+	s.window.Close(err)
+}
+
+// writeFrame is to write a stream frame or callback response, whose body is empty if not given
+func (c *Client) writeFrame(header *codec.Header, body ...codec.Body) error {
+	c.muForCodec.Lock()
+	defer c.muForCodec.Unlock()
+
+	var b codec.Body = struct{}{}
+	if len(body) > 0 {
+		b = body[0]
+	}
+	if err := c.cc.Write(header, b); err != nil {
+		return status.Errorf(status.Unavailable, "client: failed to write frame, err: %v", err)
+	}
+	return nil
+}
+
+// receiveStream is to dispatch a stream frame to its stream without blocking the connection,
+// a server exceeding the window fails its stream
+func (c *Client) receiveStream(header *codec.Header) error {
+	if header.Flags&codec.FlagEndStream != 0 {
+		call := c.cancelCall(header.StreamID)
+		err := c.cc.ReadBody(nil)
+		if call != nil {
+			call.Error = header.Err()
+			call.done()
+		}
+		return err
+	}
+
+	c.muForCall.Lock()
+	call := c.pending[header.StreamID]
+	c.muForCall.Unlock()
+
+	if header.Flags&codec.FlagWindowUpdate != 0 {
+		err := c.cc.ReadBody(nil)
+		if call != nil && call.stream != nil {
+			call.stream.window.Grant(int(header.Window))
+		}
+		return err
+	}
+
+	var message []byte
+	if err := c.cc.ReadBody(&message); err != nil {
+		if call != nil && call.stream != nil {
+			call.stream.cancel(status.Errorf(status.Internal, "client: failed to read stream body, err: %v", err))
+		}
+		return err
+	}
+	if call == nil || call.stream == nil {
+		return nil
+	}
+	if err := call.stream.queue.Push(message); err != nil {
+		call.stream.cancel(status.Errorf(status.ResourceExhausted, "client: failed to receive stream, err: %v", err))
+	}
+	return nil
+}
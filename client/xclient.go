@@ -2,21 +2,28 @@ package client
 
 import (
 	"context"
+	"gingle-rpc/auth"
 	"gingle-rpc/codec"
 	"gingle-rpc/loadbalance"
+	"gingle-rpc/status"
+	"gingle-rpc/trace"
 	"io"
 	"reflect"
 	"sync"
 )
 
-// XClient includes option, load balance, algorithm mode, clients and mutex
+// XClient includes option, load balance, algorithm mode, clients, health, metrics, tracer, credentials and mutex
 type XClient struct {
 	opt *codec.Option
 
 	lb   loadbalance.LoadBalance
 	mode loadbalance.LbAlgo
 
-	clients map[string]*Client
+	clients     map[string]*Client
+	health      *xclientHealth
+	metrics     *Metrics
+	tracer      *trace.Tracer
+	credentials auth.Credentials
 
 	mu sync.Mutex
 }
@@ -44,9 +51,46 @@ func (xc *XClient) Close() error {
 		_ = client.Close()
 		delete(xc.clients, key)
 	}
+	if xc.health != nil {
+		close(xc.health.stop)
+		xc.health = nil
+	}
 	return nil
 }
 
+// SetMetrics is to collect metrics of later calls and selections into m
+func (xc *XClient) SetMetrics(m *Metrics) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+
+	xc.metrics = m
+	for _, client := range xc.clients {
+		client.SetMetrics(m)
+	}
+}
+
+// SetTracer is to trace later calls by tracer
+func (xc *XClient) SetTracer(tracer *trace.Tracer) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+
+	xc.tracer = tracer
+	for _, client := range xc.clients {
+		client.SetTracer(tracer)
+	}
+}
+
+// SetCredentials is to authorize later calls by credentials
+func (xc *XClient) SetCredentials(credentials auth.Credentials) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+
+	xc.credentials = credentials
+	for _, client := range xc.clients {
+		client.SetCredentials(credentials)
+	}
+}
+
 // Dail is to connect client in or not in map
 func (xc *XClient) Dial(pattern string) (*Client, error) {
 	xc.mu.Lock()
@@ -59,14 +103,27 @@ func (xc *XClient) Dial(pattern string) (*Client, error) {
 		_ = client.Close()
 		delete(xc.clients, pattern)
 		client = nil
+		if xc.metrics != nil {
+			xc.metrics.recordRetry()
+		}
 	}
 
 	// not found
 	if client == nil {
-		client, err := XDial(pattern, xc.opt)
+		var err error
+		client, err = XDial(pattern, xc.opt)
 		if err != nil {
 			return nil, err
 		}
+		if xc.metrics != nil {
+			client.SetMetrics(xc.metrics)
+		}
+		if xc.tracer != nil {
+			client.SetTracer(xc.tracer)
+		}
+		if xc.credentials != nil {
+			client.SetCredentials(xc.credentials)
+		}
 		xc.clients[pattern] = client
 	}
 
@@ -75,11 +132,15 @@ func (xc *XClient) Dial(pattern string) (*Client, error) {
 
 // PeerToPeer is to call service method for one server
 func (xc *XClient) PeerToPeer(ctx context.Context, serviceMethod string, args, reply interface{}) error {
-	server, err := xc.lb.GetOne(xc.mode)
+	server, err := xc.selectServer(serviceMethod)
 	if err != nil {
 		return err
 	}
 
+	return xc.call(ctx, server, serviceMethod, args, reply)
+}
+
+func (xc *XClient) call(ctx context.Context, server, serviceMethod string, args, reply interface{}) error {
 	client, err := xc.Dial(server)
 	if err != nil {
 		return err
@@ -93,25 +154,43 @@ func (xc *XClient) Broadcast(ctx context.Context, serviceMethod string, args, re
 	var globalErr error
 	isReplyNil := reply == nil
 	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
 	servers, globalErr := xc.lb.GetAll()
 	if globalErr != nil {
 		return globalErr
 	}
 
+	// skip the servers ejected by health check
+	xc.mu.Lock()
+	health := xc.health
+	xc.mu.Unlock()
+	if health != nil && len(servers) > 0 {
+		var healthy []string
+		for _, server := range servers {
+			if health.isHealthy(server, serviceMethod) {
+				healthy = append(healthy, server)
+			}
+		}
+		if len(healthy) == 0 {
+			return status.Errorf(status.Unavailable, "client: failed to broadcast, err: no healthy servers for %s", serviceMethod)
+		}
+		servers = healthy
+	}
+
 	var mu sync.Mutex
 	var wg sync.WaitGroup
 	for _, server := range servers {
 		wg.Add(1)
 		go func(server string) {
-			wg.Done()
+			defer wg.Done()
 
 			var replyCopy interface{}
 			if reply != nil {
 				replyCopy = reflect.New(reflect.ValueOf(reply).Elem().Type()).Interface()
 			}
 
-			err := xc.PeerToPeer(ctx, serviceMethod, args, replyCopy)
+			err := xc.call(ctx, server, serviceMethod, args, replyCopy)
 
 			mu.Lock()
 			if err != nil && globalErr == nil {
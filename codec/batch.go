@@ -0,0 +1,35 @@
+package codec
+
+import (
+	"gingle-rpc/status"
+)
+
+// | Header{ServiceMethod: BatchServiceMethod, SequenceNumber: n, Flags: FlagBatch} | BatchRequest{Items: [...]} |
+// | Header{ServiceMethod: BatchServiceMethod, SequenceNumber: n, Flags: FlagBatch} | BatchReply{Results: [...]} |
+
+// BatchServiceMethod is the service method of batch request headers, which never names a service
+const BatchServiceMethod = "batch"
+
+// BatchItem includes service method, args marshaled alone and authorization of one call in a batch
+type BatchItem struct {
+	ServiceMethod string
+	Args          []byte
+	Authorization string
+}
+
+// BatchRequest includes calls of a batch and whether they are called in order rather than in parallel
+type BatchRequest struct {
+	Items   []BatchItem
+	Ordered bool
+}
+
+// BatchResult includes reply marshaled alone or status error of one call in a batch
+type BatchResult struct {
+	Reply []byte
+	Error *status.Error
+}
+
+// BatchReply includes results in the order of batch items
+type BatchReply struct {
+	Results []BatchResult
+}
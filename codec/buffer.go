@@ -0,0 +1,38 @@
+package codec
+
+import (
+	"bytes"
+	"io"
+)
+
+// frameBuffer includes the connection and the encoding of a frame being written, which is written to the connection
+// in one write so that message based transports like websocket carry one frame per message
+type frameBuffer struct {
+	conn io.Writer
+	buf  bytes.Buffer
+}
+
+func newFrameBuffer(conn io.Writer) *frameBuffer {
+	return &frameBuffer{conn: conn}
+}
+
+// Write is to buffer p of the frame
+func (b *frameBuffer) Write(p []byte) (int, error) {
+	return b.buf.Write(p)
+}
+
+// Flush is to write the frame buffered in one write
+func (b *frameBuffer) Flush() error {
+	defer b.buf.Reset()
+
+	if b.buf.Len() == 0 {
+		return nil
+	}
+	_, err := b.conn.Write(b.buf.Bytes())
+	return err
+}
+
+// Reset is to drop the frame buffered
+func (b *frameBuffer) Reset() {
+	b.buf.Reset()
+}
@@ -1,6 +1,10 @@
 package codec
 
 import (
+	"crypto/tls"
+	"encoding/json"
+	"gingle-rpc/logger"
+	"gingle-rpc/status"
 	"io"
 	"time"
 )
@@ -13,12 +17,15 @@ import (
 // MagicNumber marks it's a gingle-rpc request
 const MagicNumber = 0x3bef5c
 
-// Option includes magic number and codec type
+// Option includes magic number, codec type, timeouts, and the client side logger and tls config which are never sent
 type Option struct {
 	MagicNumber    int
 	CodecType      string
 	ConnectTimeout time.Duration
 	HandleTimeout  time.Duration
+
+	Logger    logger.Logger `json:"-"`
+	TLSConfig *tls.Config   `json:"-"` // used by tls@, https@ and wss@ dial patterns
 }
 
 var DefaultOption *Option = &Option{
@@ -27,11 +34,59 @@ var DefaultOption *Option = &Option{
 	ConnectTimeout: 10 * time.Second,
 }
 
-// Header includes service method, sequence number and error
+// | Header{SequenceNumber: n} | Header{StreamID: n, Flags: FlagStream} | ... | Header{StreamID: n, Flags: FlagStream|FlagEndStream, Error: xxx} |
+// | <-- request of stream --> | <-----     message of stream     -----> | ... | <----------         end of stream with error         ----------> |
+
+// Flags of header marking one way requests, callbacks, batches and stream frames, stream frames are sent by both sides and
+// identified by stream id, the body of a message frame is the message marshaled alone and the body of other frames is empty
+const (
+	FlagStream       uint8 = 1 << iota // the frame belongs to a stream
+	FlagEndStream                      // the sender half closes the stream, and the server ends it with the error of stream if any
+	FlagWindowUpdate                   // the receiver grants the sender Window more bytes of messages
+	FlagReset                          // the client cancels the stream
+	FlagOneWay                         // the request expects no response, even for errors
+	FlagCallback                       // the frame is a request from server to client or the response to it, numbered by server
+	FlagBatch                          // the request carries BatchRequest and the response BatchReply
+)
+
+// Header includes service method, sequence number, stream id and flags, window update, error with status code and details,
+// w3c traceparent of caller span and authorization of request
 type Header struct {
 	ServiceMethod  string
 	SequenceNumber uint64
+	StreamID       uint64 // sequence number of the request opening the stream
+	Flags          uint8
+	Window         uint32
 	Error          string
+	Code           status.Code
+	Details        map[string]string
+	Traceparent    string
+	Authorization  string
+}
+
+// SetError is to carry err as status code, message and details, nil clears them
+func (h *Header) SetError(err error) {
+	if err == nil {
+		h.Error, h.Code, h.Details = "", status.OK, nil
+		return
+	}
+
+	st := status.FromError(err)
+	h.Error, h.Code, h.Details = st.Message, st.Code, st.Details
+}
+
+// Err is to reconstruct status error carried by header, nil if no error,
+// a message without code from a peer not knowing codes is unknown
+func (h *Header) Err() error {
+	if h.Error == "" && h.Code == status.OK {
+		return nil
+	}
+
+	code := h.Code
+	if code == status.OK {
+		code = status.Unknown
+	}
+	return &status.Error{Code: code, Message: h.Error, Details: h.Details}
 }
 
 // Body includes data
@@ -45,19 +100,44 @@ type Codec interface {
 	Write(*Header, Body) error
 }
 
+// LoggerSetter is implemented by codecs which log through the given logger
+type LoggerSetter interface {
+	SetLogger(logger.Logger)
+}
+
 // NewCodecFunc is to create codec with io closer
 type NewCodecFunc func(io.ReadWriteCloser) Codec
 
 const (
 	GobType  string = "application/gob"
-	JsonType string = "application/gob"
+	JsonType string = "application/json"
 )
 
+// MarshalFunc is to encode a stream message alone, so that it can be decoded after the frame is read
+type MarshalFunc func(v interface{}) ([]byte, error)
+
+// UnmarshalFunc is to decode a stream message encoded by MarshalFunc of the same encoding type
+type UnmarshalFunc func(data []byte, v interface{}) error
+
 // NewCodecFuncMap corresponds encoding types and codec funcs
 var NewCodecFuncMap map[string]NewCodecFunc
 
+// MarshalFuncMap and UnmarshalFuncMap correspond encoding types and stream message funcs
+var (
+	MarshalFuncMap   map[string]MarshalFunc
+	UnmarshalFuncMap map[string]UnmarshalFunc
+)
+
 func init() {
 	NewCodecFuncMap = make(map[string]NewCodecFunc)
 	NewCodecFuncMap[GobType] = NewGobCodecFunc
 	NewCodecFuncMap[JsonType] = NewJsonCodecFunc
+
+	MarshalFuncMap = make(map[string]MarshalFunc)
+	MarshalFuncMap[GobType] = GobMarshal
+	MarshalFuncMap[JsonType] = json.Marshal
+
+	UnmarshalFuncMap = make(map[string]UnmarshalFunc)
+	UnmarshalFuncMap[GobType] = GobUnmarshal
+	UnmarshalFuncMap[JsonType] = json.Unmarshal
 }
@@ -1,30 +1,34 @@
 package codec
 
 import (
-	"bufio"
+	"bytes"
 	"encoding/gob"
+	"gingle-rpc/logger"
 	"io"
-	"log"
 )
 
-// GobCodec includes io closer, bufio writer, gob encoder and gob decoder
+// GobCodec includes io closer, frame buffer, gob encoder, gob decoder and logger
 type GobCodec struct {
 	conn io.ReadWriteCloser
-	buf  *bufio.Writer
+	buf  *frameBuffer
 	enc  *gob.Encoder
 	dec  *gob.Decoder
+
+	logger logger.Logger
 }
 
 var _ Codec = (*GobCodec)(nil)
 
 // NewGobCodecFunc is to create gob codec with io closer
 func NewGobCodecFunc(conn io.ReadWriteCloser) Codec {
-	buf := bufio.NewWriter(conn)
+	buf := newFrameBuffer(conn)
 	return &GobCodec{
 		conn: conn,
 		buf:  buf,
-		enc:  gob.NewEncoder(conn),
+		enc:  gob.NewEncoder(buf),
 		dec:  gob.NewDecoder(conn),
+
+		logger: logger.Default(),
 	}
 }
 
@@ -41,26 +45,49 @@ func (c *GobCodec) ReadBody(b Body) error {
 // Write is to god encode header and body
 func (c *GobCodec) Write(h *Header, b Body) (err error) {
 	defer func() {
-		_ = c.buf.Flush()
+		if err != nil {
+			c.buf.Reset()
+		} else if err = c.buf.Flush(); err != nil {
+			c.logger.Log(logger.Error, "gob codec: failed to write frame", logger.Method(h.ServiceMethod), logger.Seq(h.SequenceNumber), logger.Err(err))
+		}
 		if err != nil {
 			_ = c.Close()
 		}
 	}()
 
 	if err = c.enc.Encode(h); err != nil {
-		log.Printf("gob codec: failed to encode header, err: %v\n", err)
+		c.logger.Log(logger.Error, "gob codec: failed to encode header", logger.Method(h.ServiceMethod), logger.Seq(h.SequenceNumber), logger.Err(err))
 		return
 	}
 
 	if err = c.enc.Encode(b); err != nil {
-		log.Printf("gob codec: failed to encode body, err: %v\n", err)
+		c.logger.Log(logger.Error, "gob codec: failed to encode body", logger.Method(h.ServiceMethod), logger.Seq(h.SequenceNumber), logger.Err(err))
 		return
 	}
 
 	return
 }
 
+// SetLogger is to log encoding errors by l
+func (c *GobCodec) SetLogger(l logger.Logger) {
+	c.logger = logger.OrDefault(l)
+}
+
 // Close is to close io connection
 func (c *GobCodec) Close() error {
 	return c.conn.Close()
 }
+
+// GobMarshal is to gob encode v alone, with its type information
+func GobMarshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// GobUnmarshal is to gob decode data encoded by GobMarshal into v
+func GobUnmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
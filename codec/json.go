@@ -1,30 +1,33 @@
 package codec
 
 import (
-	"bufio"
 	"encoding/json"
+	"gingle-rpc/logger"
 	"io"
-	"log"
 )
 
-// JsonCodec includes io closer, bufio writer, json encoder and json decoder
+// JsonCodec includes io closer, frame buffer, json encoder, json decoder and logger
 type JsonCodec struct {
 	conn io.ReadWriteCloser
-	buf  *bufio.Writer
+	buf  *frameBuffer
 	enc  *json.Encoder
 	dec  *json.Decoder
+
+	logger logger.Logger
 }
 
 var _ Codec = (*JsonCodec)(nil)
 
 // NewJsonCodecFunc is to create json codec with io closer
 func NewJsonCodecFunc(conn io.ReadWriteCloser) Codec {
-	buf := bufio.NewWriter(conn)
+	buf := newFrameBuffer(conn)
 	return &JsonCodec{
 		conn: conn,
 		buf:  buf,
-		enc:  json.NewEncoder(conn),
+		enc:  json.NewEncoder(buf),
 		dec:  json.NewDecoder(conn),
+
+		logger: logger.Default(),
 	}
 }
 
@@ -33,33 +36,46 @@ func (c *JsonCodec) ReadHeader(h *Header) error {
 	return c.dec.Decode(h)
 }
 
-// ReadBody is to json decode body
+// ReadBody is to json decode body, a nil body is decoded and discarded
 func (c *JsonCodec) ReadBody(b Body) error {
+	if b == nil {
+		var discard json.RawMessage
+		return c.dec.Decode(&discard)
+	}
 	return c.dec.Decode(b)
 }
 
 // Write is to json encode header and body
 func (c *JsonCodec) Write(h *Header, b Body) (err error) {
 	defer func() {
-		_ = c.buf.Flush()
+		if err != nil {
+			c.buf.Reset()
+		} else if err = c.buf.Flush(); err != nil {
+			c.logger.Log(logger.Error, "json codec: failed to write frame", logger.Method(h.ServiceMethod), logger.Seq(h.SequenceNumber), logger.Err(err))
+		}
 		if err != nil {
 			_ = c.Close()
 		}
 	}()
 
 	if err = c.enc.Encode(h); err != nil {
-		log.Printf("json codec: failed to encode header, err: %v\n", err)
+		c.logger.Log(logger.Error, "json codec: failed to encode header", logger.Method(h.ServiceMethod), logger.Seq(h.SequenceNumber), logger.Err(err))
 		return
 	}
 
 	if err = c.enc.Encode(b); err != nil {
-		log.Printf("json codec: failed to encode body, err: %v\n", err)
+		c.logger.Log(logger.Error, "json codec: failed to encode body", logger.Method(h.ServiceMethod), logger.Seq(h.SequenceNumber), logger.Err(err))
 		return
 	}
 
 	return
 }
 
+// SetLogger is to log encoding errors by l
+func (c *JsonCodec) SetLogger(l logger.Logger) {
+	c.logger = logger.OrDefault(l)
+}
+
 // Close is to close io connection
 func (c *JsonCodec) Close() error {
 	return c.conn.Close()
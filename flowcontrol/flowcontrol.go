@@ -0,0 +1,193 @@
+package flowcontrol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// DefaultWindow is the number of message bytes a stream receiver buffers at most, which is the initial credit of its sender
+// and the largest message of a stream
+const DefaultWindow = 1 << 20
+
+// ErrTooLarge is returned by Acquire for a message larger than the whole window
+var ErrTooLarge = errors.New("flowcontrol: message larger than window")
+
+// cost is the credit taken by message, at least one byte so that empty messages are bounded as well
+func cost(message []byte) int {
+	if len(message) == 0 {
+		return 1
+	}
+	return len(message)
+}
+
+// Window includes window size, send credit in bytes granted by the receiver, the error closing it and the channel broadcasting changes
+type Window struct {
+	size    int
+	credit  int
+	err     error
+	changed chan struct{}
+	mu      sync.Mutex
+}
+
+// NewWindow is to create send window of size bytes, which is the initial credit
+func NewWindow(size int) *Window {
+	return &Window{
+		size:    size,
+		credit:  size,
+		changed: make(chan struct{}),
+	}
+}
+
+// Acquire is to take the credit of message before sending it, waiting for the receiver to grant more if not enough left,
+// a message larger than the window can never be sent
+func (w *Window) Acquire(ctx context.Context, message []byte) error {
+	n := cost(message)
+	if n > w.size {
+		return fmt.Errorf("%w, message of %d bytes, window of %d bytes", ErrTooLarge, n, w.size)
+	}
+
+	for {
+		w.mu.Lock()
+		if w.err != nil {
+			w.mu.Unlock()
+			return w.err
+		}
+		if w.credit >= n {
+			w.credit -= n
+			w.mu.Unlock()
+			return nil
+		}
+		changed := w.changed
+		w.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// Grant is to add credit in bytes granted by a window update from the receiver
+func (w *Window) Grant(credit int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.credit += credit
+	w.notifyChanged()
+}
+
+// Close is to fail waiting and later acquires with err
+func (w *Window) Close(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.err == nil {
+		w.err = err
+		w.notifyChanged()
+	}
+}
+
+func (w *Window) notifyChanged() {
+	close(w.changed)
+	w.changed = make(chan struct{})
+}
+
+// Queue includes messages received but not consumed, bytes of them, the window bounding them, bytes consumed since the last update,
+// the error ending the queue and the channel broadcasting changes
+type Queue struct {
+	messages [][]byte
+	buffered int
+	window   int
+	consumed int
+	err      error
+	changed  chan struct{}
+	mu       sync.Mutex
+}
+
+// NewQueue is to create receive queue bounded by window bytes
+func NewQueue(window int) *Queue {
+	return &Queue{
+		window:  window,
+		changed: make(chan struct{}),
+	}
+}
+
+// Push is to queue one message, which fails if the sender exceeds the window
+func (q *Queue) Push(message []byte) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.err != nil {
+		return nil
+	}
+	if q.buffered+cost(message) > q.window {
+		return fmt.Errorf("flowcontrol: window of %d bytes exceeded", q.window)
+	}
+	q.messages = append(q.messages, message)
+	q.buffered += cost(message)
+	q.notifyChanged()
+	return nil
+}
+
+// Pop is to wait for the next message, and return the credit in bytes to grant to the sender if half of the window is consumed
+// or the queue is drained, so that a sender waiting for a large message is never starved, messages queued before the end are still popped
+func (q *Queue) Pop(ctx context.Context) (message []byte, grant int, err error) {
+	for {
+		q.mu.Lock()
+		if len(q.messages) > 0 {
+			message = q.messages[0]
+			q.messages = q.messages[1:]
+			q.buffered -= cost(message)
+			q.consumed += cost(message)
+			if q.consumed >= (q.window+1)/2 || len(q.messages) == 0 {
+				grant, q.consumed = q.consumed, 0
+			}
+			q.mu.Unlock()
+			return message, grant, nil
+		}
+		if q.err != nil {
+			q.mu.Unlock()
+			return nil, 0, q.err
+		}
+		changed := q.changed
+		q.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, 0, ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// Close is to end the queue with err after queued messages, e.g. io.EOF when the sender half closes
+func (q *Queue) Close(err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.err == nil {
+		q.err = err
+		q.notifyChanged()
+	}
+}
+
+// Abort is to end the queue with err at once, dropping queued messages
+func (q *Queue) Abort(err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.messages = nil
+	q.buffered = 0
+	if q.err == nil {
+		q.err = err
+	}
+	q.notifyChanged()
+}
+
+func (q *Queue) notifyChanged() {
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
@@ -0,0 +1,158 @@
+package flowcontrol
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestWindowAcquire(t *testing.T) {
+	tests := []struct {
+		name     string
+		size     int
+		sent     []int
+		granted  int
+		message  int
+		wantErr  error
+		wantWait bool
+	}{
+		{"within credit", 10, []int{4}, 0, 6, nil, false},
+		{"exhausted", 10, []int{4, 4}, 0, 3, nil, true},
+		{"exhausted then granted", 10, []int{4, 4}, 4, 3, nil, false},
+		{"empty message costs one byte", 2, []int{1, 1}, 0, 0, nil, true},
+		{"larger than window", 10, nil, 0, 11, ErrTooLarge, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := NewWindow(tt.size)
+			for _, n := range tt.sent {
+				if err := w.Acquire(context.Background(), make([]byte, n)); err != nil {
+					t.Fatalf("acquire %d bytes: %v", n, err)
+				}
+			}
+			w.Grant(tt.granted)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			err := w.Acquire(ctx, make([]byte, tt.message))
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if waited := err == context.DeadlineExceeded; waited != tt.wantWait {
+				t.Fatalf("waited = %v, want %v, err: %v", waited, tt.wantWait, err)
+			}
+		})
+	}
+}
+
+func TestWindowGrantWakesAcquire(t *testing.T) {
+	w := NewWindow(4)
+	if err := w.Acquire(context.Background(), make([]byte, 4)); err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+
+	acquired := make(chan error, 1)
+	go func() {
+		acquired <- w.Acquire(context.Background(), make([]byte, 3))
+	}()
+	select {
+	case err := <-acquired:
+		t.Fatalf("acquired without credit, err: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	w.Grant(4)
+	if err := <-acquired; err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+}
+
+func TestWindowClose(t *testing.T) {
+	w := NewWindow(1)
+	_ = w.Acquire(context.Background(), []byte{1})
+
+	acquired := make(chan error, 1)
+	go func() {
+		acquired <- w.Acquire(context.Background(), []byte{1})
+	}()
+	w.Close(io.ErrClosedPipe)
+	if err := <-acquired; err != io.ErrClosedPipe {
+		t.Fatalf("err = %v, want %v", err, io.ErrClosedPipe)
+	}
+}
+
+func TestQueue(t *testing.T) {
+	tests := []struct {
+		name       string
+		window     int
+		pushed     []int
+		wantErr    bool
+		popped     int
+		wantGrants []int
+	}{
+		{"within window", 10, []int{4, 4}, false, 2, []int{0, 8}},
+		{"window exceeded", 10, []int{4, 4, 3}, true, 0, nil},
+		{"half window consumed", 10, []int{3, 3, 3}, false, 3, []int{0, 6, 3}},
+		{"drained", 10, []int{2}, false, 1, []int{2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := NewQueue(tt.window)
+			var err error
+			for _, n := range tt.pushed {
+				if err = q.Push(make([]byte, n)); err != nil {
+					break
+				}
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("push err = %v, want error %v", err, tt.wantErr)
+			}
+
+			for i := 0; i < tt.popped; i++ {
+				message, grant, err := q.Pop(context.Background())
+				if err != nil {
+					t.Fatalf("pop: %v", err)
+				}
+				if len(message) != tt.pushed[i] || grant != tt.wantGrants[i] {
+					t.Fatalf("pop %d = %d bytes granting %d, want %d bytes granting %d", i, len(message), grant, tt.pushed[i], tt.wantGrants[i])
+				}
+			}
+		})
+	}
+}
+
+func TestQueueEnd(t *testing.T) {
+	tests := []struct {
+		name    string
+		abort   bool
+		wantErr []error
+	}{
+		{"closed after queued messages", false, []error{nil, io.EOF}},
+		{"aborted dropping queued messages", true, []error{io.ErrUnexpectedEOF}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := NewQueue(DefaultWindow)
+			_ = q.Push([]byte("message"))
+			if tt.abort {
+				q.Abort(io.ErrUnexpectedEOF)
+			} else {
+				q.Close(io.EOF)
+			}
+
+			for i, want := range tt.wantErr {
+				if _, _, err := q.Pop(context.Background()); err != want {
+					t.Fatalf("pop %d err = %v, want %v", i, err, want)
+				}
+			}
+		})
+	}
+}
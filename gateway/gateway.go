@@ -0,0 +1,370 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"gingle-rpc/client"
+	"gingle-rpc/codec"
+	"gingle-rpc/logger"
+	"gingle-rpc/server"
+	"gingle-rpc/service"
+	"gingle-rpc/status"
+	"gingle-rpc/trace"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const defaultMaxBodyBytes = 4 << 20
+
+// Backend is to retrieve the method of service.method and invoke it, *server.Server is a backend called in process
+type Backend interface {
+	RetrieveService(serviceMethod string) (*service.Service, *service.RpcMethod, error)
+	Invoke(ctx context.Context, header *codec.Header, args, reply interface{}) error
+}
+
+var _ Backend = (*server.Server)(nil)
+
+// XClientBackend includes xclient proxying calls to remote servers and services registered only for the types of their methods
+type XClientBackend struct {
+	xc       *client.XClient
+	services sync.Map
+}
+
+var _ Backend = (*XClientBackend)(nil)
+
+// NewXClientBackend is to proxy calls through xc, whose credentials authorize the calls if set,
+// otherwise the http authorization is forwarded
+func NewXClientBackend(xc *client.XClient) *XClientBackend {
+	return &XClientBackend{xc: xc}
+}
+
+// RegisterService is to register service for the types of its methods, instance is never called
+func (b *XClientBackend) RegisterService(instance interface{}) error {
+	svc, err := service.NewService(instance, logger.Nop())
+	if err != nil {
+		return err
+	}
+	if _, ok := b.services.LoadOrStore(svc.Name, svc); ok {
+		return fmt.Errorf("gateway: service %s already defined", svc.Name)
+	}
+	return nil
+}
+
+// RetrieveService is to get the registered service and method of service.method
+func (b *XClientBackend) RetrieveService(serviceMethod string) (*service.Service, *service.RpcMethod, error) {
+	dot := strings.LastIndex(serviceMethod, ".")
+	if dot < 0 {
+		return nil, nil, status.Errorf(status.InvalidArgument, "gateway: service.method %s format not correct", serviceMethod)
+	}
+
+	svcInterface, ok := b.services.Load(serviceMethod[:dot])
+	if !ok {
+		return nil, nil, status.Errorf(status.NotFound, "gateway: service.method %s service not found", serviceMethod)
+	}
+	svc := svcInterface.(*service.Service)
+
+	rpcMethod, ok := svc.RpcMethods[serviceMethod[dot+1:]]
+	if !ok {
+		return nil, nil, status.Errorf(status.NotFound, "gateway: service.method %s method not found", serviceMethod)
+	}
+	return svc, rpcMethod, nil
+}
+
+// Invoke is to call service.method of header on one of the servers, forwarding the authorization and trace context of header
+func (b *XClientBackend) Invoke(ctx context.Context, header *codec.Header, args, reply interface{}) error {
+	if header.Authorization != "" {
+		ctx = client.ContextWithAuthorization(ctx, header.Authorization)
+	}
+	if header.Traceparent != "" {
+		if sc, err := trace.ParseTraceparent(header.Traceparent); err == nil {
+			ctx = trace.ContextWithSpanContext(ctx, sc)
+		}
+	}
+	return b.xc.PeerToPeer(ctx, header.ServiceMethod, args, reply)
+}
+
+// Gateway includes backend, routes in order of handling, logger and mutex, it serves rest style http/json requests
+// by calling the service methods their routes map to
+type Gateway struct {
+	backend Backend
+
+	routes []*route
+	logger logger.Logger
+
+	mu sync.RWMutex
+}
+
+var _ http.Handler = (*Gateway)(nil)
+
+// ErrorBody is the json body of a failed request, which includes name of code, message and details of the status error
+type ErrorBody struct {
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// NewGateway is to create gateway calling backend
+func NewGateway(backend Backend) *Gateway {
+	return &Gateway{backend: backend, logger: logger.Default()}
+}
+
+// SetLogger is to set logger, nil means the default logger
+func (g *Gateway) SetLogger(l logger.Logger) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.logger = logger.OrDefault(l)
+}
+
+func (g *Gateway) getLogger() logger.Logger {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	return g.logger
+}
+
+// Handle is to add routes, the service methods must be known by backend, streams are not supported,
+// and path params must name fields of args
+func (g *Gateway) Handle(routes ...Route) error {
+	parsed := make([]*route, 0, len(routes))
+	for _, r := range routes {
+		rt, err := newRoute(r)
+		if err != nil {
+			return err
+		}
+
+		_, rpcMethod, err := g.backend.RetrieveService(rt.ServiceMethod)
+		if err != nil {
+			return err
+		}
+		if rpcMethod.ServerStreaming {
+			return fmt.Errorf("gateway: service.method %s streams are not supported", rt.ServiceMethod)
+		}
+		for _, name := range rt.params {
+			if _, ok := argsField(rpcMethod.ArgsType, name); !ok {
+				return fmt.Errorf("gateway: path param %s of %s is not a field of %s", name, rt.ServiceMethod, rpcMethod.ArgsType)
+			}
+		}
+		parsed = append(parsed, rt)
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	all := append(append([]*route(nil), g.routes...), parsed...)
+	for i, rt := range parsed {
+		for _, existing := range all[:len(g.routes)+i] {
+			if existing.Method == rt.Method && existing.Path == rt.Path {
+				return fmt.Errorf("gateway: route %s %s already defined", rt.Method, rt.Path)
+			}
+		}
+	}
+	g.routes = all
+	return nil
+}
+
+// ServeHTTP is to decode the json body, query params and path params in order into args of the matched route,
+// where later ones override, call its service method, and write the reply as json or the error with its http status
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rt, params, allowed := g.match(r.Method, r.URL.Path)
+	if rt == nil {
+		if len(allowed) > 0 {
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			g.writeError(w, r, http.StatusMethodNotAllowed, status.New(status.Unimplemented,
+				fmt.Sprintf("gateway: http method %s of %s not allowed", r.Method, r.URL.Path)))
+			return
+		}
+		g.writeError(w, r, http.StatusNotFound, status.New(status.NotFound, fmt.Sprintf("gateway: route of %s not found", r.URL.Path)))
+		return
+	}
+
+	_, rpcMethod, err := g.backend.RetrieveService(rt.ServiceMethod)
+	if err != nil {
+		st := status.FromError(err)
+		g.writeError(w, r, st.Code.HTTPStatus(), st)
+		return
+	}
+
+	argsValue := rpcMethod.NewArgsValue()
+	if err := decodeArgs(w, r, params, argsValue); err != nil {
+		g.writeError(w, r, http.StatusBadRequest, status.New(status.InvalidArgument, fmt.Sprintf("gateway: failed to decode args, err: %v", err)))
+		return
+	}
+
+	var reply interface{}
+	replyValue := rpcMethod.NewReplyValue()
+	if replyValue.IsValid() {
+		reply = replyValue.Interface()
+	}
+
+	ctx := server.ContextWithPeer(r.Context(), &server.Peer{Addr: r.RemoteAddr, TLS: r.TLS})
+	header := &codec.Header{
+		ServiceMethod: rt.ServiceMethod,
+		Traceparent:   r.Header.Get("Traceparent"),
+		Authorization: r.Header.Get("Authorization"),
+	}
+	if err := g.backend.Invoke(ctx, header, argsValue.Interface(), reply); err != nil {
+		st := status.FromError(err)
+		g.writeError(w, r, st.Code.HTTPStatus(), st)
+		return
+	}
+
+	if reply == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	g.writeJSON(w, r, http.StatusOK, reply)
+}
+
+// match is to find the route of method and path with its path params, otherwise the methods allowed for path
+func (g *Gateway) match(method, path string) (*route, map[string]string, []string) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	segments := splitPath(path)
+	var allowed []string
+	for _, rt := range g.routes {
+		params, ok := rt.match(segments)
+		if !ok {
+			continue
+		}
+		if rt.Method == method {
+			return rt, params, nil
+		}
+		allowed = append(allowed, rt.Method)
+	}
+	sort.Strings(allowed)
+	return nil, nil, allowed
+}
+
+func (g *Gateway) writeError(w http.ResponseWriter, r *http.Request, code int, st *status.Error) {
+	g.writeJSON(w, r, code, &ErrorBody{Code: st.Code.String(), Message: st.Message, Details: st.Details})
+}
+
+func (g *Gateway) writeJSON(w http.ResponseWriter, r *http.Request, code int, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		g.getLogger().Log(logger.Error, "gateway: failed to marshal response", logger.Peer(r.RemoteAddr), logger.Err(err))
+		http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if _, err := w.Write(append(data, '\n')); err != nil {
+		g.getLogger().Log(logger.Error, "gateway: failed to write response", logger.Peer(r.RemoteAddr), logger.Err(err))
+	}
+}
+
+// decodeArgs is to decode the json body, query params and path params into args in order, params only apply to struct args
+func decodeArgs(w http.ResponseWriter, r *http.Request, params map[string]string, argsValue reflect.Value) error {
+	argsInterface := argsValue.Interface()
+	if argsValue.Kind() != reflect.Ptr {
+		argsInterface = argsValue.Addr().Interface()
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, defaultMaxBodyBytes))
+	if err != nil {
+		return err
+	}
+	if body = bytes.TrimSpace(body); len(body) > 0 {
+		if err := json.Unmarshal(body, argsInterface); err != nil {
+			return err
+		}
+	}
+
+	structValue := reflect.Indirect(reflect.ValueOf(argsInterface))
+	for structValue.Kind() == reflect.Ptr {
+		if structValue.IsNil() {
+			structValue.Set(reflect.New(structValue.Type().Elem()))
+		}
+		structValue = structValue.Elem()
+	}
+	query := r.URL.Query()
+	if structValue.Kind() != reflect.Struct {
+		if len(query) > 0 {
+			return fmt.Errorf("query params not supported by args of %s", structValue.Type())
+		}
+		return nil
+	}
+
+	for name, values := range query {
+		field, ok := argsField(structValue.Type(), name)
+		if !ok {
+			return fmt.Errorf("query param %s is not a field of %s", name, structValue.Type())
+		}
+		if err := setField(structValue.FieldByIndex(field.Index), values); err != nil {
+			return fmt.Errorf("query param %s, %v", name, err)
+		}
+	}
+	for name, value := range params {
+		field, _ := argsField(structValue.Type(), name)
+		if err := setField(structValue.FieldByIndex(field.Index), []string{value}); err != nil {
+			return fmt.Errorf("path param %s, %v", name, err)
+		}
+	}
+	return nil
+}
+
+// argsField is to find the exported field of args struct named by name or its json name, case insensitive like json
+func argsField(argsType reflect.Type, name string) (reflect.StructField, bool) {
+	for argsType.Kind() == reflect.Ptr {
+		argsType = argsType.Elem()
+	}
+	if argsType.Kind() != reflect.Struct {
+		return reflect.StructField{}, false
+	}
+
+	for i := 0; i < argsType.NumField(); i++ {
+		field := argsType.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+
+		fieldName := field.Name
+		if tag := strings.Split(field.Tag.Get("json"), ",")[0]; tag == "-" {
+			continue
+		} else if tag != "" {
+			fieldName = tag
+		}
+		if strings.EqualFold(fieldName, name) {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// setField is to set field by the text of values as json, strings are quoted and repeated values fill slices
+func setField(field reflect.Value, values []string) error {
+	elemType := field.Type()
+	isSlice := elemType.Kind() == reflect.Slice && elemType.Elem().Kind() != reflect.Uint8
+	if isSlice {
+		elemType = elemType.Elem()
+	} else {
+		values = values[len(values)-1:]
+	}
+	for elemType.Kind() == reflect.Ptr {
+		elemType = elemType.Elem()
+	}
+
+	texts := make([]string, len(values))
+	for i, value := range values {
+		if elemType.Kind() == reflect.String || elemType.Kind() == reflect.Slice {
+			quoted, _ := json.Marshal(value)
+			value = string(quoted)
+		}
+		texts[i] = value
+	}
+
+	text := texts[0]
+	if isSlice {
+		text = "[" + strings.Join(texts, ",") + "]"
+	}
+	return json.Unmarshal([]byte(text), field.Addr().Interface())
+}
@@ -0,0 +1,305 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"gingle-rpc/auth"
+	"gingle-rpc/client"
+	"gingle-rpc/loadbalance"
+	"gingle-rpc/server"
+	"gingle-rpc/service"
+	"gingle-rpc/status"
+	"gingle-rpc/trace"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// ItemArgs includes id, name and tags of the item asked for
+type ItemArgs struct {
+	ID   int `json:"id"`
+	Name string
+	Tags []string
+}
+
+// Item includes the args asked for, and the principal and traceparent the call is seen with
+type Item struct {
+	ID          int
+	Name        string
+	Tags        []string
+	Principal   string
+	Traceparent string
+}
+
+// FailArgs includes the code of status error returned, a plain error if 0
+type FailArgs struct {
+	Code int
+}
+
+// Items is the service of tests echoing args with what the call is seen with
+type Items struct{}
+
+func (Items) Get(ctx context.Context, args ItemArgs, reply *Item) error {
+	*reply = Item{ID: args.ID, Name: args.Name, Tags: args.Tags}
+	reply.Principal, _ = auth.PrincipalFromContext(ctx)
+	if sc, ok := trace.SpanContextFromContext(ctx); ok {
+		reply.Traceparent = sc.Traceparent()
+	}
+	return nil
+}
+
+func (Items) Fail(args FailArgs, reply *Item) error {
+	if args.Code == 0 {
+		return errors.New("failed")
+	}
+	return status.Errorf(status.Code(args.Code), "failed")
+}
+
+func (Items) Forget(args ItemArgs, reply service.OneWay) error {
+	return nil
+}
+
+var testRoutes = []Route{
+	{Method: "GET", Path: "/v1/items/{id}", ServiceMethod: "Items.Get"},
+	{Method: "POST", Path: "/v1/items/{id}", ServiceMethod: "Items.Get"},
+	{Method: "GET", Path: "/v1/items/{id}/name/{name}", ServiceMethod: "Items.Get"},
+	{Method: "POST", Path: "/v1/fail", ServiceMethod: "Items.Fail"},
+	{Method: "DELETE", Path: "/v1/items/{id}", ServiceMethod: "Items.Forget"},
+}
+
+// startTestGateway is to serve gateway of routes calling backend
+func startTestGateway(t *testing.T, backend Backend) string {
+	g := NewGateway(backend)
+	if err := g.Handle(testRoutes...); err != nil {
+		t.Fatalf("handle: %v", err)
+	}
+	ts := httptest.NewServer(g)
+	t.Cleanup(ts.Close)
+	return ts.URL
+}
+
+func newTestServer(t *testing.T) *server.Server {
+	s := server.NewServer()
+	if err := s.RegisterService(Items{}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	return s
+}
+
+// request is to send request of method, path, body and headers, and return http status and the body of response
+func request(t *testing.T, method, url, body string, header http.Header) (int, http.Header, []byte) {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("do request: %v", err)
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+
+	var data json.RawMessage
+	if res.StatusCode != http.StatusNoContent {
+		if err := json.NewDecoder(res.Body).Decode(&data); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+	}
+	return res.StatusCode, res.Header, data
+}
+
+func TestGatewayHandle(t *testing.T) {
+	tests := []struct {
+		name    string
+		route   Route
+		wantErr bool
+	}{
+		{"valid", Route{Method: "put", Path: "/v1/items/{id}", ServiceMethod: "Items.Get"}, false},
+		{"duplicated", testRoutes[0], true},
+		{"unsupported http method", Route{Method: "TRACE", Path: "/v1/trace", ServiceMethod: "Items.Get"}, true},
+		{"relative path", Route{Method: "GET", Path: "v1/items", ServiceMethod: "Items.Get"}, true},
+		{"unknown method", Route{Method: "GET", Path: "/v1/unknown", ServiceMethod: "Items.Unknown"}, true},
+		{"param not a field", Route{Method: "GET", Path: "/v1/items/{size}", ServiceMethod: "Items.Get"}, true},
+		{"duplicated param", Route{Method: "GET", Path: "/v1/items/{id}/{id}", ServiceMethod: "Items.Get"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGateway(newTestServer(t))
+			if err := g.Handle(testRoutes...); err != nil {
+				t.Fatalf("handle: %v", err)
+			}
+			if err := g.Handle(tt.route); (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want err %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGatewayRoutes(t *testing.T) {
+	url := startTestGateway(t, newTestServer(t))
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+		wantItem   *Item
+		wantAllow  string
+	}{
+		{"path param", "GET", "/v1/items/7", "", http.StatusOK, &Item{ID: 7}, ""},
+		{"path params", "GET", "/v1/items/7/name/foo", "", http.StatusOK, &Item{ID: 7, Name: "foo"}, ""},
+		{"query params", "GET", "/v1/items/7?name=foo&tags=a&tags=b", "", http.StatusOK, &Item{ID: 7, Name: "foo", Tags: []string{"a", "b"}}, ""},
+		{"query and path params override body", "POST", "/v1/items/7?name=foo", `{"id": 1, "Name": "bar", "Tags": ["c"]}`, http.StatusOK, &Item{ID: 7, Name: "foo", Tags: []string{"c"}}, ""},
+		{"one way method", "DELETE", "/v1/items/7", "", http.StatusNoContent, nil, ""},
+		{"trailing slash", "GET", "/v1/items/7/", "", http.StatusOK, &Item{ID: 7}, ""},
+		{"unknown path", "GET", "/v1/others/7", "", http.StatusNotFound, nil, ""},
+		{"path of other length", "GET", "/v1/items/7/name", "", http.StatusNotFound, nil, ""},
+		{"http method not allowed", "PUT", "/v1/items/7", "", http.StatusMethodNotAllowed, nil, "DELETE, GET, POST"},
+		{"path param of wrong type", "GET", "/v1/items/seven", "", http.StatusBadRequest, nil, ""},
+		{"unknown query param", "GET", "/v1/items/7?size=1", "", http.StatusBadRequest, nil, ""},
+		{"malformed body", "POST", "/v1/items/7", `{"id":`, http.StatusBadRequest, nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, header, data := request(t, tt.method, url+tt.path, tt.body, nil)
+			if code != tt.wantStatus {
+				t.Fatalf("http status = %d, body %s, want %d", code, data, tt.wantStatus)
+			}
+			if allow := header.Get("Allow"); allow != tt.wantAllow {
+				t.Fatalf("allow = %q, want %q", allow, tt.wantAllow)
+			}
+			if tt.wantItem == nil {
+				return
+			}
+			var item Item
+			if err := json.Unmarshal(data, &item); err != nil {
+				t.Fatalf("decode item %s: %v", data, err)
+			}
+			if !reflect.DeepEqual(&item, tt.wantItem) {
+				t.Fatalf("item = %+v, want %+v", item, *tt.wantItem)
+			}
+		})
+	}
+}
+
+func TestGatewayErrorStatus(t *testing.T) {
+	url := startTestGateway(t, newTestServer(t))
+
+	tests := []struct {
+		name       string
+		code       status.Code
+		wantStatus int
+		wantCode   string
+	}{
+		{"plain error", 0, http.StatusInternalServerError, "Unknown"},
+		{"invalid argument", status.InvalidArgument, http.StatusBadRequest, "InvalidArgument"},
+		{"not found", status.NotFound, http.StatusNotFound, "NotFound"},
+		{"permission denied", status.PermissionDenied, http.StatusForbidden, "PermissionDenied"},
+		{"unauthenticated", status.Unauthenticated, http.StatusUnauthorized, "Unauthenticated"},
+		{"resource exhausted", status.ResourceExhausted, http.StatusTooManyRequests, "ResourceExhausted"},
+		{"deadline exceeded", status.DeadlineExceeded, http.StatusGatewayTimeout, "DeadlineExceeded"},
+		{"unavailable", status.Unavailable, http.StatusServiceUnavailable, "Unavailable"},
+		{"unimplemented", status.Unimplemented, http.StatusNotImplemented, "Unimplemented"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, _ := json.Marshal(&FailArgs{Code: int(tt.code)})
+			code, _, data := request(t, "POST", url+"/v1/fail", string(body), nil)
+			if code != tt.wantStatus {
+				t.Fatalf("http status = %d, want %d", code, tt.wantStatus)
+			}
+			var errorBody ErrorBody
+			if err := json.Unmarshal(data, &errorBody); err != nil || errorBody.Code != tt.wantCode {
+				t.Fatalf("error body = %s, err: %v, want code %s", data, err, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestGatewayForwarding(t *testing.T) {
+	const traceparent = "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+	parent, _ := trace.ParseTraceparent(traceparent)
+
+	s := newTestServer(t)
+	s.SetAuth(auth.StaticTokens{"secret": "alice"}, auth.NewPolicy(map[string][]string{"alice": {"Items.*"}}))
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = lis.Close()
+	})
+	go s.Accept(lis)
+
+	lb := loadbalance.NewLoadBalanceWithClientDiscovery([]string{"tcp@" + lis.Addr().String()})
+	xc := client.NewXClient(lb, loadbalance.RoundRobin, nil)
+	t.Cleanup(func() {
+		_ = xc.Close()
+	})
+	remote := NewXClientBackend(xc)
+	if err := remote.RegisterService(Items{}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	backends := []struct {
+		name    string
+		backend Backend
+	}{
+		{"in process", s},
+		{"through xclient", remote},
+	}
+	tests := []struct {
+		name          string
+		authorization string
+		wantStatus    int
+	}{
+		{"authorized", "Bearer secret", http.StatusOK},
+		{"unknown token", "Bearer other", http.StatusUnauthorized},
+		{"without authorization", "", http.StatusUnauthorized},
+	}
+
+	for _, b := range backends {
+		url := startTestGateway(t, b.backend)
+		for _, tt := range tests {
+			t.Run(b.name+" "+tt.name, func(t *testing.T) {
+				header := http.Header{"Traceparent": {traceparent}}
+				if tt.authorization != "" {
+					header.Set("Authorization", tt.authorization)
+				}
+				code, _, data := request(t, "GET", url+"/v1/items/7", "", header)
+				if code != tt.wantStatus {
+					t.Fatalf("http status = %d, body %s, want %d", code, data, tt.wantStatus)
+				}
+				if code != http.StatusOK {
+					return
+				}
+
+				var item Item
+				if err := json.Unmarshal(data, &item); err != nil {
+					t.Fatalf("decode item %s: %v", data, err)
+				}
+				if item.Principal != "alice" {
+					t.Fatalf("principal = %q, want alice", item.Principal)
+				}
+				// the handler sees the trace of the http request
+				got, err := trace.ParseTraceparent(item.Traceparent)
+				if err != nil || got.TraceID != parent.TraceID {
+					t.Fatalf("traceparent = %q, err: %v, want the trace of %q", item.Traceparent, err, traceparent)
+				}
+			})
+		}
+	}
+}
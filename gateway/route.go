@@ -0,0 +1,134 @@
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+const routeTag = "gateway"
+
+// | {"Routes": [{"Method": "POST", "Path": "/v1/foo/{id}/sum", "ServiceMethod": "Foo.Sum"}, ...]} |
+
+// Route includes http method, path whose {name} segments are path params and the service.method it maps to
+type Route struct {
+	Method        string
+	Path          string
+	ServiceMethod string
+}
+
+// routeFile is the content of mapping file
+type routeFile struct {
+	Routes []Route
+}
+
+// LoadRoutes is to read routes from the mapping file at path
+func LoadRoutes(path string) ([]Route, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("gateway: failed to read mapping file, err: %v", err)
+	}
+
+	var file routeFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("gateway: failed to parse mapping file, err: %v", err)
+	}
+	return file.Routes, nil
+}
+
+// RoutesFromTags is to read routes from struct tags of routes, each field tagged like `gateway:"POST /v1/foo/sum"`
+// maps to the method of service named the same as the field
+//
+//	type FooRoutes struct {
+//		Sum struct{} `gateway:"POST /v1/foo/sum"`
+//	}
+func RoutesFromTags(service string, routes interface{}) ([]Route, error) {
+	t := reflect.TypeOf(routes)
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("gateway: routes of %s must be a struct, got %T", service, routes)
+	}
+
+	var result []Route
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag, ok := field.Tag.Lookup(routeTag)
+		if !ok {
+			continue
+		}
+
+		parts := strings.Fields(tag)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("gateway: tag %q of %s.%s format not correct, expected \"METHOD /path\"", tag, service, field.Name)
+		}
+		result = append(result, Route{Method: parts[0], Path: parts[1], ServiceMethod: service + "." + field.Name})
+	}
+	return result, nil
+}
+
+// route includes route, segments of its path and names of path params
+type route struct {
+	Route
+
+	segments []string
+	params   []string
+}
+
+// newRoute is to parse the path of r
+func newRoute(r Route) (*route, error) {
+	r.Method = strings.ToUpper(r.Method)
+	switch r.Method {
+	case http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+	default:
+		return nil, fmt.Errorf("gateway: http method %q of %s not supported", r.Method, r.ServiceMethod)
+	}
+	if !strings.HasPrefix(r.Path, "/") {
+		return nil, fmt.Errorf("gateway: path %q of %s must start with /", r.Path, r.ServiceMethod)
+	}
+
+	rt := &route{Route: r, segments: splitPath(r.Path)}
+	seen := make(map[string]bool)
+	for _, segment := range rt.segments {
+		if name, ok := paramName(segment); ok {
+			if name == "" || seen[name] {
+				return nil, fmt.Errorf("gateway: path %q of %s has an empty or duplicated param", r.Path, r.ServiceMethod)
+			}
+			seen[name] = true
+			rt.params = append(rt.params, name)
+		}
+	}
+	return rt, nil
+}
+
+// match is to get the path params if path matches the route
+func (rt *route) match(segments []string) (map[string]string, bool) {
+	if len(segments) != len(rt.segments) {
+		return nil, false
+	}
+
+	params := make(map[string]string, len(rt.params))
+	for i, segment := range rt.segments {
+		if name, ok := paramName(segment); ok {
+			params[name] = segments[i]
+		} else if segment != segments[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+func splitPath(path string) []string {
+	return strings.Split(strings.Trim(path, "/"), "/")
+}
+
+func paramName(segment string) (string, bool) {
+	if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+		return segment[1 : len(segment)-1], true
+	}
+	return "", false
+}
@@ -0,0 +1,558 @@
+package gossip
+
+import (
+	"encoding/json"
+	"fmt"
+	"gingle-rpc/logger"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// | ping     | A -> B: are you alive, with A's membership piggybacked               |
+// | ack      | B -> A: B is alive, with B's membership piggybacked                  |
+// | ping-req | A -> C: please ping B for me, C relays B's ack back to A on success |
+
+// MemberState is the state of a member known by a node
+type MemberState int
+
+const (
+	Alive MemberState = iota
+	Suspect
+	Dead
+)
+
+// String is to get the name of member state
+func (st MemberState) String() string {
+	switch st {
+	case Alive:
+		return "alive"
+	case Suspect:
+		return "suspect"
+	case Dead:
+		return "dead"
+	default:
+		return "unknown"
+	}
+}
+
+// Member includes name, rpc address, state and incarnation
+type Member struct {
+	Name        string // gossip address of the member
+	RpcAddr     string // rpc pattern of the member, empty for nodes which only watch membership
+	State       MemberState
+	Incarnation uint64
+
+	stateAt time.Time
+}
+
+// Config includes bind address, rpc address and failure detection params
+type Config struct {
+	BindAddr string
+	RpcAddr  string
+
+	ProbeInterval    time.Duration
+	ProbeTimeout     time.Duration
+	SuspicionTimeout time.Duration
+	DeadTimeout      time.Duration
+	IndirectChecks   int
+}
+
+var DefaultConfig *Config = &Config{
+	BindAddr:         "127.0.0.1:0",
+	ProbeInterval:    time.Second,
+	ProbeTimeout:     300 * time.Millisecond,
+	SuspicionTimeout: 3 * time.Second,
+	DeadTimeout:      30 * time.Second,
+	IndirectChecks:   3,
+}
+
+type messageType int
+
+const (
+	pingMsg messageType = iota
+	pingReqMsg
+	ackMsg
+)
+
+// message includes type, sequence number, sender, probe target and piggybacked members
+type message struct {
+	Type    messageType
+	Seq     uint64
+	From    string
+	Target  string
+	Members []Member
+}
+
+const maxPacketSize = 65507
+
+// tombstone includes the incarnation a forgotten member died at and when it was forgotten
+type tombstone struct {
+	incarnation uint64
+	at          time.Time
+}
+
+// Node includes config, udp connection, members, tombstones of forgotten members, pending acks and states
+type Node struct {
+	cfg  *Config
+	conn *net.UDPConn
+	name string
+
+	seq        uint64
+	members    map[string]*Member
+	tombstones map[string]tombstone
+	pending    map[uint64]chan struct{}
+
+	r        *rand.Rand
+	probeIdx int
+	probes   []string
+
+	closed    chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+	mu        sync.Mutex
+}
+
+// NewNode is to create gossip node listening on bind address
+func NewNode(cfg *Config) (*Node, error) {
+	cfg = parseConfig(cfg)
+
+	addr, err := net.ResolveUDPAddr("udp", cfg.BindAddr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	n := &Node{
+		cfg:        cfg,
+		conn:       conn,
+		name:       conn.LocalAddr().String(),
+		members:    make(map[string]*Member),
+		tombstones: make(map[string]tombstone),
+		pending:    make(map[uint64]chan struct{}),
+		r:          rand.New(rand.NewSource(time.Now().UnixNano())),
+		closed:     make(chan struct{}),
+	}
+	n.members[n.name] = &Member{
+		Name:    n.name,
+		RpcAddr: cfg.RpcAddr,
+		State:   Alive,
+		stateAt: time.Now(),
+	}
+
+	go n.receive()
+	go n.probePeriodically()
+
+	return n, nil
+}
+
+func parseConfig(cfg *Config) *Config {
+	if cfg == nil {
+		return DefaultConfig
+	}
+
+	parsed := *cfg
+	if parsed.BindAddr == "" {
+		parsed.BindAddr = DefaultConfig.BindAddr
+	}
+	if parsed.ProbeInterval == 0 {
+		parsed.ProbeInterval = DefaultConfig.ProbeInterval
+	}
+	if parsed.ProbeTimeout == 0 {
+		parsed.ProbeTimeout = DefaultConfig.ProbeTimeout
+	}
+	if parsed.SuspicionTimeout == 0 {
+		parsed.SuspicionTimeout = DefaultConfig.SuspicionTimeout
+	}
+	if parsed.DeadTimeout == 0 {
+		parsed.DeadTimeout = DefaultConfig.DeadTimeout
+	}
+	if parsed.IndirectChecks == 0 {
+		parsed.IndirectChecks = DefaultConfig.IndirectChecks
+	}
+	return &parsed
+}
+
+// Name is to get gossip address of this node
+func (n *Node) Name() string {
+	return n.name
+}
+
+// Join is to join the cluster through seed addresses
+func (n *Node) Join(seeds []string) error {
+	var joined bool
+	var err error
+	for _, seed := range seeds {
+		if seed == n.name {
+			continue
+		}
+		if n.ping(seed, n.cfg.ProbeTimeout) {
+			joined = true
+		} else {
+			err = fmt.Errorf("gossip: failed to join, err: seed %s not responding", seed)
+		}
+	}
+
+	if !joined && err != nil {
+		return err
+	}
+	return nil
+}
+
+// Leave is to announce this node is leaving and close it
+func (n *Node) Leave() error {
+	n.mu.Lock()
+	self := n.members[n.name]
+	self.Incarnation++
+	self.State = Dead
+	self.stateAt = time.Now()
+	targets := n.randomMembers(n.cfg.IndirectChecks, "")
+	n.mu.Unlock()
+
+	for _, target := range targets {
+		n.send(target, &message{Type: pingMsg, Seq: n.nextSeq(), From: n.name})
+	}
+	return n.Close()
+}
+
+// Close is to stop this node without announcing, only the first close takes effect
+func (n *Node) Close() error {
+	n.closeOnce.Do(func() {
+		close(n.closed)
+		n.closeErr = n.conn.Close()
+	})
+	return n.closeErr
+}
+
+// Members is to get all members known by this node except the dead ones, sorted by name
+func (n *Node) Members() []Member {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	members := make([]Member, 0, len(n.members))
+	for _, m := range n.members {
+		if m.State != Dead {
+			members = append(members, *m)
+		}
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].Name < members[j].Name
+	})
+	return members
+}
+
+func (n *Node) nextSeq() uint64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.seq++
+	return n.seq
+}
+
+// snapshot is to copy members and tombstones as dead members for piggybacking, caller must hold the mutex
+func (n *Node) snapshot() []Member {
+	members := make([]Member, 0, len(n.members)+len(n.tombstones))
+	for _, m := range n.members {
+		members = append(members, *m)
+	}
+	for name, t := range n.tombstones {
+		members = append(members, Member{Name: name, State: Dead, Incarnation: t.incarnation})
+	}
+	return members
+}
+
+func (n *Node) send(to string, msg *message) {
+	n.mu.Lock()
+	msg.Members = n.snapshot()
+	n.mu.Unlock()
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		logger.Default().Log(logger.Error, "gossip: failed to encode message", logger.Err(err))
+		return
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", to)
+	if err != nil {
+		logger.Default().Log(logger.Warn, "gossip: failed to resolve peer", logger.Peer(to), logger.Err(err))
+		return
+	}
+	_, _ = n.conn.WriteToUDP(data, addr)
+}
+
+func (n *Node) receive() {
+	buf := make([]byte, maxPacketSize)
+	for {
+		size, _, err := n.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-n.closed:
+				return
+			default:
+			}
+			logger.Default().Log(logger.Warn, "gossip: failed to read packet", logger.Err(err))
+			continue
+		}
+
+		var msg message
+		if err := json.Unmarshal(buf[:size], &msg); err != nil {
+			logger.Default().Log(logger.Warn, "gossip: failed to decode message", logger.Err(err))
+			continue
+		}
+		n.handle(&msg)
+	}
+}
+
+func (n *Node) handle(msg *message) {
+	n.merge(msg.Members)
+
+	switch msg.Type {
+	case pingMsg:
+		n.send(msg.From, &message{Type: ackMsg, Seq: msg.Seq, From: n.name, Target: n.name})
+	case pingReqMsg:
+		go func() {
+			if n.ping(msg.Target, n.cfg.ProbeTimeout) {
+				n.send(msg.From, &message{Type: ackMsg, Seq: msg.Seq, From: n.name, Target: msg.Target})
+			}
+		}()
+	case ackMsg:
+		n.mu.Lock()
+		ch, ok := n.pending[msg.Seq]
+		n.mu.Unlock()
+		if ok {
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// merge is to apply piggybacked members with swim precedence rules
+func (n *Node) merge(members []Member) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	now := time.Now()
+	for _, m := range members {
+		local, ok := n.members[m.Name]
+
+		if m.Name == n.name {
+			// refute suspicion or death of this node by a newer incarnation
+			if local.State == Alive && m.State != Alive && m.Incarnation >= local.Incarnation {
+				local.Incarnation = m.Incarnation + 1
+			}
+			continue
+		}
+
+		if !ok {
+			// a forgotten member only comes back alive by a newer incarnation, never by stale entries
+			if t, forgotten := n.tombstones[m.Name]; forgotten {
+				if m.State != Alive || m.Incarnation <= t.incarnation {
+					continue
+				}
+				delete(n.tombstones, m.Name)
+			}
+			if m.State != Dead {
+				member := m
+				member.stateAt = now
+				n.members[m.Name] = &member
+			}
+			continue
+		}
+
+		if overrides(&m, local) {
+			if m.State != local.State {
+				local.stateAt = now
+			}
+			local.State = m.State
+			local.Incarnation = m.Incarnation
+			local.RpcAddr = m.RpcAddr
+		}
+	}
+}
+
+// overrides is to check whether the received member overrides the local one
+func overrides(received, local *Member) bool {
+	switch received.State {
+	case Alive:
+		return received.Incarnation > local.Incarnation
+	case Suspect:
+		if local.State == Alive {
+			return received.Incarnation >= local.Incarnation
+		}
+		return local.State == Suspect && received.Incarnation > local.Incarnation
+	case Dead:
+		return local.State != Dead && received.Incarnation >= local.Incarnation
+	default:
+		return false
+	}
+}
+
+// ping is to ping target directly and wait for its ack within timeout
+func (n *Node) ping(target string, timeout time.Duration) bool {
+	seq := n.nextSeq()
+	ch := n.registerAck(seq)
+	defer n.cancelAck(seq)
+
+	n.send(target, &message{Type: pingMsg, Seq: seq, From: n.name})
+
+	select {
+	case <-ch:
+		return true
+	case <-time.After(timeout):
+		return false
+	case <-n.closed:
+		return false
+	}
+}
+
+func (n *Node) registerAck(seq uint64) chan struct{} {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	ch := make(chan struct{}, 1)
+	n.pending[seq] = ch
+	return ch
+}
+
+func (n *Node) cancelAck(seq uint64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	delete(n.pending, seq)
+}
+
+func (n *Node) probePeriodically() {
+	t := time.NewTicker(n.cfg.ProbeInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-n.closed:
+			return
+		case <-t.C:
+			if target, ok := n.nextProbeTarget(); ok {
+				n.probe(target)
+			}
+			n.expireMembers()
+		}
+	}
+}
+
+// nextProbeTarget is to pick members round robin over a shuffled list, reshuffling after each round
+func (n *Node) nextProbeTarget() (string, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for {
+		if n.probeIdx >= len(n.probes) {
+			n.probes = n.probes[:0]
+			for name, m := range n.members {
+				if name != n.name && m.State != Dead {
+					n.probes = append(n.probes, name)
+				}
+			}
+			if len(n.probes) == 0 {
+				return "", false
+			}
+			n.r.Shuffle(len(n.probes), func(i, j int) {
+				n.probes[i], n.probes[j] = n.probes[j], n.probes[i]
+			})
+			n.probeIdx = 0
+		}
+
+		target := n.probes[n.probeIdx]
+		n.probeIdx++
+		if m, ok := n.members[target]; ok && m.State != Dead {
+			return target, true
+		}
+	}
+}
+
+// probe is to ping target directly, then indirectly through other members, and suspect it on failure
+func (n *Node) probe(target string) {
+	if n.ping(target, n.cfg.ProbeTimeout) {
+		return
+	}
+
+	seq := n.nextSeq()
+	ch := n.registerAck(seq)
+	defer n.cancelAck(seq)
+
+	n.mu.Lock()
+	helpers := n.randomMembers(n.cfg.IndirectChecks, target)
+	n.mu.Unlock()
+	for _, helper := range helpers {
+		n.send(helper, &message{Type: pingReqMsg, Seq: seq, From: n.name, Target: target})
+	}
+
+	// the rest of the probe interval, but never less than a probe timeout so that indirect acks have time to arrive
+	wait := n.cfg.ProbeInterval - n.cfg.ProbeTimeout
+	if wait < n.cfg.ProbeTimeout {
+		wait = n.cfg.ProbeTimeout
+	}
+	select {
+	case <-ch:
+		return
+	case <-time.After(wait):
+	case <-n.closed:
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if m, ok := n.members[target]; ok && m.State == Alive {
+		m.State = Suspect
+		m.stateAt = time.Now()
+	}
+}
+
+// expireMembers is to declare suspects dead after suspicion timeout, forget dead members after dead timeout keeping
+// their tombstones, and drop tombstones once they have been gossiped for another dead timeout
+func (n *Node) expireMembers() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	now := time.Now()
+	for name, t := range n.tombstones {
+		if now.Sub(t.at) > n.cfg.DeadTimeout {
+			delete(n.tombstones, name)
+		}
+	}
+	for name, m := range n.members {
+		if name == n.name {
+			continue
+		}
+		switch {
+		case m.State == Suspect && now.Sub(m.stateAt) > n.cfg.SuspicionTimeout:
+			m.State = Dead
+			m.stateAt = now
+		case m.State == Dead && now.Sub(m.stateAt) > n.cfg.DeadTimeout:
+			delete(n.members, name)
+			n.tombstones[name] = tombstone{incarnation: m.Incarnation, at: now}
+		}
+	}
+}
+
+// randomMembers is to pick at most k random alive members except this node and the excluded one, caller must hold the mutex
+func (n *Node) randomMembers(k int, exclude string) []string {
+	var names []string
+	for name, m := range n.members {
+		if name != n.name && name != exclude && m.State == Alive {
+			names = append(names, name)
+		}
+	}
+	n.r.Shuffle(len(names), func(i, j int) {
+		names[i], names[j] = names[j], names[i]
+	})
+	if len(names) > k {
+		names = names[:k]
+	}
+	return names
+}
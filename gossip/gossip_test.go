@@ -0,0 +1,191 @@
+package gossip
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestNode is to create node which never probes on its own, so that tests drive its state
+func newTestNode(t *testing.T) *Node {
+	n, err := NewNode(&Config{ProbeInterval: time.Hour})
+	if err != nil {
+		t.Fatalf("new node: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = n.Close()
+	})
+	return n
+}
+
+func newFastNode(t *testing.T) *Node {
+	n, err := NewNode(&Config{
+		ProbeInterval:    50 * time.Millisecond,
+		ProbeTimeout:     20 * time.Millisecond,
+		SuspicionTimeout: 200 * time.Millisecond,
+		DeadTimeout:      time.Second,
+	})
+	if err != nil {
+		t.Fatalf("new node: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = n.Close()
+	})
+	return n
+}
+
+func waitMembers(t *testing.T, n *Node, want int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if len(n.Members()) == want {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("%s knows %d members, want %d", n.Name(), len(n.Members()), want)
+}
+
+func TestOverrides(t *testing.T) {
+	tests := []struct {
+		name     string
+		received Member
+		local    Member
+		want     bool
+	}{
+		{"alive of newer incarnation", Member{State: Alive, Incarnation: 2}, Member{State: Suspect, Incarnation: 1}, true},
+		{"alive of same incarnation", Member{State: Alive, Incarnation: 1}, Member{State: Suspect, Incarnation: 1}, false},
+		{"suspect of same incarnation over alive", Member{State: Suspect, Incarnation: 1}, Member{State: Alive, Incarnation: 1}, true},
+		{"suspect of older incarnation over alive", Member{State: Suspect, Incarnation: 0}, Member{State: Alive, Incarnation: 1}, false},
+		{"suspect of same incarnation over suspect", Member{State: Suspect, Incarnation: 1}, Member{State: Suspect, Incarnation: 1}, false},
+		{"suspect over dead", Member{State: Suspect, Incarnation: 5}, Member{State: Dead, Incarnation: 1}, false},
+		{"dead of same incarnation over suspect", Member{State: Dead, Incarnation: 1}, Member{State: Suspect, Incarnation: 1}, true},
+		{"dead of older incarnation over alive", Member{State: Dead, Incarnation: 0}, Member{State: Alive, Incarnation: 1}, false},
+		{"dead over dead", Member{State: Dead, Incarnation: 2}, Member{State: Dead, Incarnation: 1}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := overrides(&tt.received, &tt.local); got != tt.want {
+				t.Fatalf("overrides = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRefutation(t *testing.T) {
+	tests := []struct {
+		name            string
+		received        Member
+		wantIncarnation uint64
+	}{
+		{"suspected at current incarnation", Member{State: Suspect, Incarnation: 0}, 1},
+		{"declared dead at newer incarnation", Member{State: Dead, Incarnation: 3}, 4},
+		{"alive is not refuted", Member{State: Alive, Incarnation: 3}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := newTestNode(t)
+			tt.received.Name = n.Name()
+			n.merge([]Member{tt.received})
+
+			self := n.Members()[0]
+			if self.State != Alive || self.Incarnation != tt.wantIncarnation {
+				t.Fatalf("self = %s at %d, want alive at %d", self.State, self.Incarnation, tt.wantIncarnation)
+			}
+		})
+	}
+}
+
+func TestTombstone(t *testing.T) {
+	const forgotten = "127.0.0.1:1"
+
+	tests := []struct {
+		name     string
+		received Member
+		wantBack bool
+	}{
+		{"stale alive", Member{Name: forgotten, State: Alive, Incarnation: 2}, false},
+		{"stale suspect", Member{Name: forgotten, State: Suspect, Incarnation: 1}, false},
+		{"suspect of newer incarnation", Member{Name: forgotten, State: Suspect, Incarnation: 3}, false},
+		{"alive of newer incarnation", Member{Name: forgotten, State: Alive, Incarnation: 3}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := newTestNode(t)
+			n.mu.Lock()
+			n.members[forgotten] = &Member{Name: forgotten, State: Dead, Incarnation: 2, stateAt: time.Now().Add(-time.Hour)}
+			n.mu.Unlock()
+			n.expireMembers()
+
+			n.mu.Lock()
+			var gossiped bool
+			for _, m := range n.snapshot() {
+				gossiped = gossiped || (m.Name == forgotten && m.State == Dead && m.Incarnation == 2)
+			}
+			n.mu.Unlock()
+			if !gossiped {
+				t.Fatalf("tombstone of %s not piggybacked", forgotten)
+			}
+
+			n.merge([]Member{tt.received})
+			back := len(n.Members()) == 2
+			if back != tt.wantBack {
+				t.Fatalf("back = %v, want %v", back, tt.wantBack)
+			}
+		})
+	}
+}
+
+func TestSuspicion(t *testing.T) {
+	tests := []struct {
+		name  string
+		nodes int
+	}{
+		{"three nodes", 3},
+		{"five nodes", 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nodes := make([]*Node, tt.nodes)
+			for i := range nodes {
+				nodes[i] = newFastNode(t)
+				if i > 0 {
+					if err := nodes[i].Join([]string{nodes[0].Name()}); err != nil {
+						t.Fatalf("join: %v", err)
+					}
+				}
+			}
+			for _, n := range nodes {
+				waitMembers(t, n, tt.nodes)
+			}
+
+			// a crashed node is suspected by probes, then declared dead after the suspicion timeout
+			failed := nodes[tt.nodes-1]
+			_ = failed.Close()
+			for _, n := range nodes[:tt.nodes-1] {
+				waitMembers(t, n, tt.nodes-1)
+			}
+		})
+	}
+}
+
+func TestConcurrentClose(t *testing.T) {
+	n := newTestNode(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_ = n.Close()
+		}()
+		go func() {
+			defer wg.Done()
+			_ = n.Leave()
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,419 @@
+package loadbalance
+
+import (
+	"encoding/json"
+	"fmt"
+	"gingle-rpc/logger"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultFilePeriod = time.Second
+
+// | {"Servers": [{"Addr": "tcp@127.0.0.1:9999", "Weight": 2, "Tags": ["canary"]}, ...]} |
+//
+// or in yaml when the file is named *.yaml or *.yml, see decodeFileServersYAML for the subset supported
+//
+// | servers:                       |
+// |   - addr: tcp@127.0.0.1:9999   |
+// |     weight: 2                  |
+// |     tags: [canary]             |
+
+// FileServer includes address, weight and tags of a server in discovery file, weight 0 means drained
+type FileServer struct {
+	Addr   string
+	Weight *int
+	Tags   []string
+}
+
+// fileServers is the content of discovery file
+type fileServers struct {
+	Servers []FileServer
+}
+
+// weightedServer includes address, weight and current weight for smooth weighted round robin
+type weightedServer struct {
+	addr    string
+	weight  int
+	current int
+}
+
+// LoadBalanceWithFileDiscovery includes file path, tags, weighted servers, file states, rand func and mutex
+type LoadBalanceWithFileDiscovery struct {
+	path string
+	tags []string
+
+	servers []*weightedServer
+	modAt   time.Time
+	size    int64
+	checkAt time.Time
+
+	period time.Duration
+	closed chan struct{}
+
+	r  *rand.Rand
+	mu sync.Mutex
+}
+
+var _ LoadBalance = (*LoadBalanceWithFileDiscovery)(nil)
+var _ io.Closer = (*LoadBalanceWithFileDiscovery)(nil)
+
+// NewLoadBalanceWithFileDiscovery is create load balance with file discovery, only servers with all tags are selected
+func NewLoadBalanceWithFileDiscovery(path string, tags ...string) (*LoadBalanceWithFileDiscovery, error) {
+	lb := &LoadBalanceWithFileDiscovery{
+		path:    path,
+		tags:    tags,
+		checkAt: time.Now(),
+		period:  defaultFilePeriod,
+		r:       rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+
+	if err := lb.Refresh(); err != nil {
+		return nil, err
+	}
+	return lb, nil
+}
+
+// Refresh is to reload servers from file if it has been modified, the file states are kept only once servers are
+// swapped, so that a file failing to be read or decoded is retried until it is fixed
+func (lb *LoadBalanceWithFileDiscovery) Refresh() error {
+	info, err := os.Stat(lb.path)
+	if err != nil {
+		return err
+	}
+
+	lb.mu.Lock()
+	unchanged := info.ModTime().Equal(lb.modAt) && info.Size() == lb.size
+	lb.mu.Unlock()
+	if unchanged {
+		return nil
+	}
+
+	data, err := os.ReadFile(lb.path)
+	if err != nil {
+		return err
+	}
+	var content fileServers
+	switch strings.ToLower(filepath.Ext(lb.path)) {
+	case ".yaml", ".yml":
+		err = decodeFileServersYAML(data, &content)
+	default:
+		err = json.Unmarshal(data, &content)
+	}
+	if err != nil {
+		return fmt.Errorf("discovery: failed to decode file %s, err: %v", lb.path, err)
+	}
+
+	servers := make([]*weightedServer, 0, len(content.Servers))
+	for _, server := range content.Servers {
+		if server.Addr == "" || !hasTags(server.Tags, lb.tags) {
+			continue
+		}
+		weight := 1
+		if server.Weight != nil {
+			weight = *server.Weight
+		}
+		if weight > 0 {
+			servers = append(servers, &weightedServer{addr: server.Addr, weight: weight})
+		}
+	}
+
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	lb.servers = servers
+	lb.modAt = info.ModTime()
+	lb.size = info.Size()
+	return nil
+}
+
+// refreshIfDue is to reload servers on the call path at most once a period, the last good servers are kept on errors
+func (lb *LoadBalanceWithFileDiscovery) refreshIfDue() {
+	lb.mu.Lock()
+	due := time.Since(lb.checkAt) >= lb.period
+	if due {
+		lb.checkAt = time.Now()
+	}
+	lb.mu.Unlock()
+
+	if due {
+		if err := lb.Refresh(); err != nil {
+			logger.Default().Log(logger.Warn, "discovery: failed to reload file", logger.Any("path", lb.path), logger.Err(err))
+		}
+	}
+}
+
+// Update is to update servers from local with equal weights, which will be overwritten by the next file change
+func (lb *LoadBalanceWithFileDiscovery) Update(servers []string) error {
+	weighted := make([]*weightedServer, 0, len(servers))
+	for _, server := range servers {
+		weighted = append(weighted, &weightedServer{addr: server, weight: 1})
+	}
+
+	lb.swap(weighted)
+	return nil
+}
+
+// swap is to replace all servers at once
+func (lb *LoadBalanceWithFileDiscovery) swap(servers []*weightedServer) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	lb.servers = servers
+}
+
+// GetOne is to get one server using the load balance algorithm weighted by file
+func (lb *LoadBalanceWithFileDiscovery) GetOne(mode LbAlgo) (string, error) {
+	lb.refreshIfDue()
+
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	if len(lb.servers) == 0 {
+		return "", fmt.Errorf("discovery: no available servers")
+	}
+
+	switch mode {
+	case Random:
+		return lb.selectWeightedRandom()
+	case RoundRobin:
+		return lb.selectWeightedRoundRobin()
+	default:
+		return "", fmt.Errorf("discovery: no such load balance algorithm mode")
+	}
+}
+
+func (lb *LoadBalanceWithFileDiscovery) selectWeightedRandom() (string, error) {
+	total := 0
+	for _, server := range lb.servers {
+		total += server.weight
+	}
+
+	n := lb.r.Intn(total)
+
+	for _, server := range lb.servers {
+		if n < server.weight {
+			return server.addr, nil
+		}
+		n -= server.weight
+	}
+	return lb.servers[len(lb.servers)-1].addr, nil
+}
+
+// selectWeightedRoundRobin is smooth weighted round robin, which spreads picks of heavy servers over the round
+func (lb *LoadBalanceWithFileDiscovery) selectWeightedRoundRobin() (string, error) {
+	total := 0
+	var best *weightedServer
+	for _, server := range lb.servers {
+		server.current += server.weight
+		total += server.weight
+		if best == nil || server.current > best.current {
+			best = server
+		}
+	}
+	best.current -= total
+	return best.addr, nil
+}
+
+// GetAll is to get all servers
+func (lb *LoadBalanceWithFileDiscovery) GetAll() ([]string, error) {
+	lb.refreshIfDue()
+
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	servers := make([]string, 0, len(lb.servers))
+	for _, server := range lb.servers {
+		servers = append(servers, server.addr)
+	}
+	return servers, nil
+}
+
+// Watch is to start a background watcher which reloads servers as soon as the file changes, without it servers are
+// reloaded on the call path once a period
+func (lb *LoadBalanceWithFileDiscovery) Watch() {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	if lb.closed != nil {
+		return
+	}
+	lb.closed = make(chan struct{})
+	go lb.watch(lb.closed)
+}
+
+// Close is to stop the background watcher
+func (lb *LoadBalanceWithFileDiscovery) Close() error {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	if lb.closed != nil {
+		close(lb.closed)
+		lb.closed = nil
+	}
+	return nil
+}
+
+func (lb *LoadBalanceWithFileDiscovery) watch(closed chan struct{}) {
+	t := time.NewTicker(lb.period)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case <-t.C:
+			// keep serving the last good servers while the file is missing or malformed
+			if err := lb.Refresh(); err != nil {
+				logger.Default().Log(logger.Warn, "discovery: failed to reload file", logger.Any("path", lb.path), logger.Err(err))
+			}
+		}
+	}
+}
+
+func hasTags(serverTags, tags []string) bool {
+	for _, tag := range tags {
+		found := false
+		for _, serverTag := range serverTags {
+			if serverTag == tag {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// decodeFileServersYAML is to decode the yaml subset of discovery files without a dependency: a top level servers key
+// holding a block sequence of mappings with addr, weight and tags, tags as a flow or block sequence of scalars, plain or
+// quoted scalars, and comments. Keys are matched case insensitively as json does, anything else is rejected
+func decodeFileServersYAML(data []byte, content *fileServers) error {
+	var server *FileServer
+	var inServers, inTags bool
+	itemIndent, tagsIndent := -1, -1
+
+	for i, line := range strings.Split(string(data), "\n") {
+		line = stripYAMLComment(strings.TrimRight(line, " \r"))
+		trimmed := strings.TrimLeft(line, " ")
+		if trimmed == "" {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "\t") {
+			return fmt.Errorf("line %d: tabs are not allowed for indentation", i+1)
+		}
+		indent := len(line) - len(trimmed)
+
+		// a block sequence of tags lasts while it is more indented than its key
+		if inTags {
+			if indent > tagsIndent && strings.HasPrefix(trimmed, "- ") {
+				server.Tags = append(server.Tags, unquoteYAML(strings.TrimSpace(trimmed[2:])))
+				continue
+			}
+			inTags = false
+		}
+
+		if indent == 0 {
+			key, value, err := splitYAMLPair(trimmed)
+			if err != nil || !strings.EqualFold(key, "servers") || (value != "" && value != "[]") {
+				return fmt.Errorf("line %d: only the servers key is expected at top level", i+1)
+			}
+			inServers = true
+			continue
+		}
+		if !inServers {
+			return fmt.Errorf("line %d: unexpected indentation", i+1)
+		}
+
+		if trimmed == "-" || strings.HasPrefix(trimmed, "- ") {
+			if itemIndent == -1 {
+				itemIndent = indent
+			}
+			if indent != itemIndent {
+				return fmt.Errorf("line %d: misaligned server", i+1)
+			}
+			content.Servers = append(content.Servers, FileServer{})
+			server = &content.Servers[len(content.Servers)-1]
+			trimmed = strings.TrimSpace(trimmed[1:])
+			indent += 2
+			if trimmed == "" {
+				continue
+			}
+		} else if server == nil || indent <= itemIndent {
+			return fmt.Errorf("line %d: server fields are expected in a sequence item", i+1)
+		}
+
+		key, value, err := splitYAMLPair(trimmed)
+		if err != nil {
+			return fmt.Errorf("line %d: %v", i+1, err)
+		}
+		switch strings.ToLower(key) {
+		case "addr":
+			server.Addr = unquoteYAML(value)
+		case "weight":
+			weight, err := strconv.Atoi(unquoteYAML(value))
+			if err != nil {
+				return fmt.Errorf("line %d: invalid weight %q", i+1, value)
+			}
+			server.Weight = &weight
+		case "tags":
+			switch {
+			case value == "":
+				inTags, tagsIndent = true, indent
+			case strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]"):
+				for _, tag := range strings.Split(value[1:len(value)-1], ",") {
+					if tag = strings.TrimSpace(tag); tag != "" {
+						server.Tags = append(server.Tags, unquoteYAML(tag))
+					}
+				}
+			default:
+				return fmt.Errorf("line %d: tags are expected as a sequence", i+1)
+			}
+		default:
+			return fmt.Errorf("line %d: unknown field %q", i+1, key)
+		}
+	}
+	return nil
+}
+
+// splitYAMLPair is to split key and value of a mapping entry
+func splitYAMLPair(s string) (string, string, error) {
+	idx := strings.Index(s, ":")
+	if idx <= 0 || (idx+1 < len(s) && s[idx+1] != ' ') {
+		return "", "", fmt.Errorf("mapping entry is expected")
+	}
+	return strings.TrimSpace(s[:idx]), strings.TrimSpace(s[idx+1:]), nil
+}
+
+// stripYAMLComment is to remove comment starting by # at the line start or after a space, outside of quotes
+func stripYAMLComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' '):
+			return strings.TrimRight(line[:i], " ")
+		}
+	}
+	return line
+}
+
+func unquoteYAML(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
@@ -0,0 +1,192 @@
+package loadbalance
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// writeFile is to write discovery file with a modification time of its own, so that a rewrite is always noticed
+func writeFile(t *testing.T, path, content string, modAt time.Time) {
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	if err := os.Chtimes(path, modAt, modAt); err != nil {
+		t.Fatalf("chtimes: %v", err)
+	}
+}
+
+func TestFileDiscoveryDecode(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		tags    []string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:    "json",
+			file:    "servers.json",
+			content: `{"Servers": [{"Addr": "tcp@a", "Weight": 2, "Tags": ["canary"]}, {"Addr": "tcp@b"}, {"Addr": "tcp@c", "Weight": 0}]}`,
+			want:    []string{"tcp@a", "tcp@b"},
+		},
+		{
+			name: "yaml",
+			file: "servers.yaml",
+			content: `# servers of the canary release
+servers:
+  - addr: tcp@a   # heavy
+    weight: 2
+    tags: [canary, "blue"]
+  - Addr: 'tcp@b'
+    tags:
+      - blue
+  - addr: tcp@c
+    weight: 0
+`,
+			want: []string{"tcp@a", "tcp@b"},
+		},
+		{
+			name:    "json filtered by tags",
+			file:    "servers.json",
+			content: `{"Servers": [{"Addr": "tcp@a", "Tags": ["canary", "blue"]}, {"Addr": "tcp@b", "Tags": ["blue"]}]}`,
+			tags:    []string{"canary", "blue"},
+			want:    []string{"tcp@a"},
+		},
+		{
+			name:    "yaml filtered by tags",
+			file:    "servers.yml",
+			content: "servers:\n  - addr: tcp@a\n    tags: [canary, blue]\n  - addr: tcp@b\n    tags:\n      - blue\n",
+			tags:    []string{"blue"},
+			want:    []string{"tcp@a", "tcp@b"},
+		},
+		{"malformed json", "servers.json", `{"Servers": [`, nil, nil, true},
+		{"yaml of unknown field", "servers.yaml", "servers:\n  - addr: tcp@a\n    port: 1\n", nil, nil, true},
+		{"yaml of invalid weight", "servers.yaml", "servers:\n  - addr: tcp@a\n    weight: heavy\n", nil, nil, true},
+		{"yaml of unknown top level key", "servers.yaml", "hosts:\n  - addr: tcp@a\n", nil, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			writeFile(t, path, tt.content, time.Now())
+
+			lb, err := NewLoadBalanceWithFileDiscovery(path, tt.tags...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			servers, _ := lb.GetAll()
+			sort.Strings(servers)
+			if !reflect.DeepEqual(servers, tt.want) {
+				t.Fatalf("servers = %v, want %v", servers, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileDiscoveryWeights(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		picks   int
+		want    map[string]int
+	}{
+		{"equal weights", `{"Servers": [{"Addr": "tcp@a"}, {"Addr": "tcp@b"}]}`, 4, map[string]int{"tcp@a": 2, "tcp@b": 2}},
+		{"heavy server", `{"Servers": [{"Addr": "tcp@a", "Weight": 3}, {"Addr": "tcp@b"}]}`, 8, map[string]int{"tcp@a": 6, "tcp@b": 2}},
+		{"drained server", `{"Servers": [{"Addr": "tcp@a", "Weight": 0}, {"Addr": "tcp@b"}]}`, 3, map[string]int{"tcp@b": 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "servers.json")
+			writeFile(t, path, tt.content, time.Now())
+			lb, err := NewLoadBalanceWithFileDiscovery(path)
+			if err != nil {
+				t.Fatalf("new: %v", err)
+			}
+
+			picked := make(map[string]int)
+			for i := 0; i < tt.picks; i++ {
+				server, err := lb.GetOne(RoundRobin)
+				if err != nil {
+					t.Fatalf("get one: %v", err)
+				}
+				picked[server]++
+			}
+			if !reflect.DeepEqual(picked, tt.want) {
+				t.Fatalf("picked = %v, want %v", picked, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileDiscoveryReload(t *testing.T) {
+	tests := []struct {
+		name     string
+		rewrites []string
+		want     []string
+	}{
+		{"reloaded on the call path", []string{`{"Servers": [{"Addr": "tcp@b"}]}`}, []string{"tcp@b"}},
+		{"last good servers kept while malformed", []string{`{"Servers": [`}, []string{"tcp@a"}},
+		{"malformed file retried once fixed", []string{`{"Servers": [`, `{"Servers": [{"Addr": "tcp@c"}]}`}, []string{"tcp@c"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "servers.json")
+			modAt := time.Now().Add(-time.Hour)
+			writeFile(t, path, `{"Servers": [{"Addr": "tcp@a"}]}`, modAt)
+			lb, err := NewLoadBalanceWithFileDiscovery(path)
+			if err != nil {
+				t.Fatalf("new: %v", err)
+			}
+			lb.period = 0
+
+			for _, content := range tt.rewrites {
+				modAt = modAt.Add(time.Second)
+				writeFile(t, path, content, modAt)
+				_, _ = lb.GetOne(Random)
+			}
+			servers, _ := lb.GetAll()
+			if !reflect.DeepEqual(servers, tt.want) {
+				t.Fatalf("servers = %v, want %v", servers, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileDiscoveryWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "servers.yaml")
+	writeFile(t, path, "servers:\n  - addr: tcp@a\n", time.Now().Add(-time.Hour))
+	lb, err := NewLoadBalanceWithFileDiscovery(path)
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	lb.period = 10 * time.Millisecond
+	lb.Watch()
+	defer func() {
+		_ = lb.Close()
+	}()
+
+	writeFile(t, path, "servers:\n  - addr: tcp@b\n", time.Now())
+	deadline := time.Now().Add(time.Second)
+	for {
+		lb.mu.Lock()
+		reloaded := len(lb.servers) == 1 && lb.servers[0].addr == "tcp@b"
+		lb.mu.Unlock()
+		if reloaded {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("file change not watched")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
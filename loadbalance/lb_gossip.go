@@ -0,0 +1,56 @@
+package loadbalance
+
+import "gingle-rpc/gossip"
+
+// LoadBalanceWithGossipDiscovery includes gossip node and load balance with client discovery
+type LoadBalanceWithGossipDiscovery struct {
+	node *gossip.Node
+	*LoadBalanceWithClientDiscovery
+}
+
+var _ LoadBalance = (*LoadBalanceWithGossipDiscovery)(nil)
+
+// NewLoadBalanceWithGossipDiscovery is create load balance with gossip discovery
+func NewLoadBalanceWithGossipDiscovery(node *gossip.Node) *LoadBalanceWithGossipDiscovery {
+	return &LoadBalanceWithGossipDiscovery{
+		node:                           node,
+		LoadBalanceWithClientDiscovery: NewLoadBalanceWithClientDiscovery(make([]string, 0)),
+	}
+}
+
+// Refresh is to refresh servers from the live membership list
+func (lb *LoadBalanceWithGossipDiscovery) Refresh() error {
+	members := lb.node.Members()
+
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	lb.servers = make([]string, 0, len(members))
+	for _, m := range members {
+		if m.State == gossip.Alive && m.RpcAddr != "" {
+			lb.servers = append(lb.servers, m.RpcAddr)
+		}
+	}
+	return nil
+}
+
+// Update is to update servers from local, which will be overwritten by the next refresh
+func (lb *LoadBalanceWithGossipDiscovery) Update(servers []string) error {
+	return lb.LoadBalanceWithClientDiscovery.Update(servers)
+}
+
+// GetOne is to get one server using the load balance algorithm
+func (lb *LoadBalanceWithGossipDiscovery) GetOne(mode LbAlgo) (string, error) {
+	if err := lb.Refresh(); err != nil {
+		return "", err
+	}
+	return lb.LoadBalanceWithClientDiscovery.GetOne(mode)
+}
+
+// GetAll is to get all servers
+func (lb *LoadBalanceWithGossipDiscovery) GetAll() ([]string, error) {
+	if err := lb.Refresh(); err != nil {
+		return nil, err
+	}
+	return lb.LoadBalanceWithClientDiscovery.GetAll()
+}
@@ -1,60 +1,98 @@
 package loadbalance
 
 import (
+	"context"
+	"fmt"
+	"io"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
-const defaultTimeout = 10 * time.Second
+const (
+	defaultTimeout      = 10 * time.Second
+	defaultWatchBackoff = time.Second
 
-// LoadBalanceWithServerDiscovery includes registry address, load balance with client discovery, timeout and update at
+	// a fetch is bounded so that a half open registry connection never pins the watcher, a long poll is held by
+	// the registry for its watch timeout at most
+	defaultFetchTimeout = 10 * time.Second
+	defaultWatchTimeout = 30*time.Second + defaultFetchTimeout
+)
+
+// LoadBalanceWithServerDiscovery includes registry addresses, load balance with client discovery, timeout, update at and watch states
 type LoadBalanceWithServerDiscovery struct {
-	registryAddr string
+	registryAddrs []string
+	registryIdx   uint32
 	*LoadBalanceWithClientDiscovery
 
-	timeout  time.Duration
-	updateAt time.Time
+	timeout    time.Duration
+	updateAt   time.Time
+	refreshing chan struct{} // closed once the fetch in flight is done, nil if none
+
+	revision uint64
+	watching bool
+	cancel   context.CancelFunc
 }
 
 var _ LoadBalance = (*LoadBalanceWithServerDiscovery)(nil)
+var _ io.Closer = (*LoadBalanceWithServerDiscovery)(nil)
 
-// NewLoadBalanceWithServerDiscovery is create load balance with server discovery
+// NewLoadBalanceWithServerDiscovery is create load balance with server discovery, registryAddr may be comma separated registry addresses to fail over between
 func NewLoadBalanceWithServerDiscovery(registryAddr string, timeout time.Duration) *LoadBalanceWithServerDiscovery {
 	if timeout == 0 {
 		timeout = defaultTimeout
 	}
 
+	var registryAddrs []string
+	for _, addr := range strings.Split(registryAddr, ",") {
+		if strings.TrimSpace(addr) != "" {
+			registryAddrs = append(registryAddrs, strings.TrimSpace(addr))
+		}
+	}
+
 	return &LoadBalanceWithServerDiscovery{
-		registryAddr:                   registryAddr,
+		registryAddrs:                  registryAddrs,
 		LoadBalanceWithClientDiscovery: NewLoadBalanceWithClientDiscovery(make([]string, 0)),
 		timeout:                        timeout,
 	}
 }
 
-// Refresh is to refresh servers from remote
+// Refresh is to refresh servers from remote, the fetch runs without holding the lock so that calls and updates
+// are never blocked by registries failing over, and concurrent refreshes wait for the one in flight
 func (lb *LoadBalanceWithServerDiscovery) Refresh() error {
 	lb.mu.Lock()
-	defer lb.mu.Unlock()
-
-	if lb.updateAt.Add(lb.timeout).After(time.Now()) {
+	// servers are kept up to date by the background watcher
+	if lb.watching || lb.updateAt.Add(lb.timeout).After(time.Now()) {
+		lb.mu.Unlock()
 		return nil
 	}
+	if refreshing := lb.refreshing; refreshing != nil {
+		lb.mu.Unlock()
+		<-refreshing
+		return nil
+	}
+	refreshing := make(chan struct{})
+	lb.refreshing = refreshing
+	lb.mu.Unlock()
+
+	servers, revision, err := lb.fetch(context.Background(), 0, false)
+
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
 
-	res, err := http.Get(lb.registryAddr)
+	lb.refreshing = nil
+	close(refreshing)
 	if err != nil {
 		return err
 	}
-
-	servers := strings.Split(res.Header.Get("X-Gingle-Rpc-Servers"), ",")
-	lb.servers = make([]string, 0, len(servers))
-	for _, server := range servers {
-		if strings.TrimSpace(server) != "" {
-			lb.servers = append(lb.servers, strings.TrimSpace(server))
-		}
+	// the watcher started since is more up to date
+	if !lb.watching {
+		lb.servers = servers
+		lb.revision = revision
+		lb.updateAt = time.Now()
 	}
-	lb.updateAt = time.Now()
-
 	return nil
 }
 
@@ -83,3 +121,143 @@ func (lb *LoadBalanceWithServerDiscovery) GetAll() ([]string, error) {
 	}
 	return lb.LoadBalanceWithClientDiscovery.GetAll()
 }
+
+// Watch is to start a background watcher which long polls the registry and updates servers as soon as membership changes
+func (lb *LoadBalanceWithServerDiscovery) Watch() {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	if lb.cancel != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	lb.cancel = cancel
+	go lb.watch(ctx)
+}
+
+// Close is to stop the background watcher
+func (lb *LoadBalanceWithServerDiscovery) Close() error {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	if lb.cancel != nil {
+		lb.cancel()
+		lb.cancel = nil
+	}
+	lb.watching = false
+	return nil
+}
+
+func (lb *LoadBalanceWithServerDiscovery) watch(ctx context.Context) {
+	// the call path polls again once the watcher is gone
+	defer func() {
+		lb.mu.Lock()
+		lb.watching = false
+		lb.mu.Unlock()
+	}()
+
+	for {
+		lb.mu.RLock()
+		revision, watching := lb.revision, lb.watching
+		lb.mu.RUnlock()
+
+		// fetch immediately until the first successful watch, then long poll with the known revision
+		servers, revision, err := lb.fetch(ctx, revision, watching)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			// fall back to polling on the call path while the registry is unreachable
+			lb.mu.Lock()
+			lb.watching = false
+			lb.mu.Unlock()
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(defaultWatchBackoff):
+			}
+			continue
+		}
+
+		lb.mu.Lock()
+		// Close may have run since the fetch returned
+		if ctx.Err() != nil {
+			lb.mu.Unlock()
+			return
+		}
+		lb.servers = servers
+		lb.revision = revision
+		lb.updateAt = time.Now()
+		lb.watching = true
+		lb.mu.Unlock()
+	}
+}
+
+// fetch is to fetch servers from the current registry, failing over to the others in order
+func (lb *LoadBalanceWithServerDiscovery) fetch(ctx context.Context, revision uint64, wait bool) ([]string, uint64, error) {
+	n := uint32(len(lb.registryAddrs))
+	if n == 0 {
+		return nil, 0, fmt.Errorf("discovery: no registry address")
+	}
+
+	// revisions are local to each registry, so a watch never fails over and is restarted with a plain fetch
+	attempts := n
+	if wait {
+		attempts = 1
+	}
+
+	idx := atomic.LoadUint32(&lb.registryIdx)
+	var err error
+	for i := uint32(0); i < attempts; i++ {
+		var servers []string
+		servers, revision, err = fetchServers(ctx, lb.registryAddrs[(idx+i)%n], revision, wait)
+		if err == nil {
+			atomic.StoreUint32(&lb.registryIdx, (idx+i)%n)
+			return servers, revision, nil
+		}
+		if ctx.Err() != nil {
+			return nil, 0, err
+		}
+	}
+
+	atomic.StoreUint32(&lb.registryIdx, (idx+attempts)%n)
+	return nil, 0, err
+}
+
+func fetchServers(ctx context.Context, registryAddr string, revision uint64, wait bool) ([]string, uint64, error) {
+	timeout := defaultFetchTimeout
+	if wait {
+		timeout = defaultWatchTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", registryAddr, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if wait {
+		req.Header.Set("X-Gingle-Rpc-Revision", strconv.FormatUint(revision, 10))
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	_ = res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("discovery: failed to fetch servers, err: unexpected status %s", res.Status)
+	}
+
+	rawServers := strings.Split(res.Header.Get("X-Gingle-Rpc-Servers"), ",")
+	servers := make([]string, 0, len(rawServers))
+	for _, server := range rawServers {
+		if strings.TrimSpace(server) != "" {
+			servers = append(servers, strings.TrimSpace(server))
+		}
+	}
+	revision, _ = strconv.ParseUint(res.Header.Get("X-Gingle-Rpc-Revision"), 10, 64)
+
+	return servers, revision, nil
+}
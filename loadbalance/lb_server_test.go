@@ -0,0 +1,151 @@
+package loadbalance
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testRegistry is the registry of tests replying servers in headers, blocking requests while hung
+type testRegistry struct {
+	servers  atomic.Value
+	revision uint64
+	hung     chan struct{}
+}
+
+func newTestRegistry(t *testing.T, servers string) (*testRegistry, *httptest.Server) {
+	r := &testRegistry{}
+	r.servers.Store(servers)
+	ts := httptest.NewServer(r)
+	t.Cleanup(ts.Close)
+	return r, ts
+}
+
+func (r *testRegistry) set(servers string) {
+	r.servers.Store(servers)
+	atomic.AddUint64(&r.revision, 1)
+}
+
+func (r *testRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if r.hung != nil {
+		select {
+		case <-r.hung:
+		case <-req.Context().Done():
+			return
+		}
+	}
+	// long polls are answered as soon as the revision moves on
+	if known := req.Header.Get("X-Gingle-Rpc-Revision"); known != "" {
+		for i := 0; i < 100 && known == strconv.FormatUint(atomic.LoadUint64(&r.revision), 10); i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	w.Header().Set("X-Gingle-Rpc-Servers", r.servers.Load().(string))
+	w.Header().Set("X-Gingle-Rpc-Revision", strconv.FormatUint(atomic.LoadUint64(&r.revision), 10))
+}
+
+func TestServerDiscoveryFailover(t *testing.T) {
+	_, ts := newTestRegistry(t, "tcp@127.0.0.1:1, tcp@127.0.0.1:2")
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	tests := []struct {
+		name     string
+		registry string
+		wantErr  bool
+	}{
+		{"single registry", ts.URL, false},
+		{"failed over from unreachable registry", down.URL + "," + ts.URL, false},
+		{"all registries unreachable", down.URL, true},
+		{"no registry", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lb := NewLoadBalanceWithServerDiscovery(tt.registry, time.Minute)
+			servers, err := lb.GetAll()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && len(servers) != 2 {
+				t.Fatalf("servers = %v, want 2 servers", servers)
+			}
+		})
+	}
+}
+
+func TestServerDiscoveryRefreshUnlocked(t *testing.T) {
+	r, ts := newTestRegistry(t, "tcp@127.0.0.1:1")
+	r.hung = make(chan struct{})
+	lb := NewLoadBalanceWithServerDiscovery(ts.URL, time.Minute)
+
+	refreshed := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			refreshed <- lb.Refresh()
+		}()
+	}
+
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(5 * time.Millisecond) {
+		lb.mu.RLock()
+		refreshing := lb.refreshing != nil
+		lb.mu.RUnlock()
+		if refreshing {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("refresh not started")
+		}
+	}
+
+	// updates and lookups of local servers are not blocked by the hung fetch
+	updated := make(chan struct{})
+	go func() {
+		_ = lb.Update([]string{"tcp@127.0.0.1:2"})
+		_, _ = lb.LoadBalanceWithClientDiscovery.GetAll()
+		close(updated)
+	}()
+	select {
+	case <-updated:
+	case <-time.After(time.Second):
+		t.Fatalf("update blocked by refresh")
+	}
+
+	close(r.hung)
+	for i := 0; i < 2; i++ {
+		if err := <-refreshed; err != nil {
+			t.Fatalf("refresh: %v", err)
+		}
+	}
+	if servers, _ := lb.GetAll(); len(servers) != 1 || servers[0] != "tcp@127.0.0.1:1" {
+		t.Fatalf("servers = %v, want fetched servers", servers)
+	}
+}
+
+func TestServerDiscoveryWatch(t *testing.T) {
+	r, ts := newTestRegistry(t, "tcp@127.0.0.1:1")
+	lb := NewLoadBalanceWithServerDiscovery(ts.URL, time.Hour)
+	lb.Watch()
+
+	r.set("tcp@127.0.0.1:1,tcp@127.0.0.1:2")
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if servers, _ := lb.GetAll(); len(servers) == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("change not watched")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	_ = lb.Close()
+	r.set("tcp@127.0.0.1:3")
+	time.Sleep(100 * time.Millisecond)
+	if servers, _ := lb.GetAll(); len(servers) != 2 {
+		t.Fatalf("servers = %v, updated after close", servers)
+	}
+}
@@ -0,0 +1,175 @@
+package logger
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// | 2026/01/02 15:04:05 WARN server: failed to read request body method=Foo.Sum seq=3 peer=127.0.0.1:52110 err="unexpected EOF" |
+
+// Level is the severity of a log entry
+type Level int
+
+const (
+	Debug Level = iota
+	Info
+	Warn
+	Error
+)
+
+// String is to get upper case name of level
+func (l Level) String() string {
+	switch l {
+	case Debug:
+		return "DEBUG"
+	case Info:
+		return "INFO"
+	case Warn:
+		return "WARN"
+	case Error:
+		return "ERROR"
+	default:
+		return "LEVEL(" + strconv.Itoa(int(l)) + ")"
+	}
+}
+
+// Field includes key and value of structured context attached to a log entry
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// Any is to create field with any value
+func Any(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+// Err is to create field of error
+func Err(err error) Field {
+	return Field{Key: "err", Value: err}
+}
+
+// Method is to create field of service method
+func Method(serviceMethod string) Field {
+	return Field{Key: "method", Value: serviceMethod}
+}
+
+// Seq is to create field of sequence number
+func Seq(seq uint64) Field {
+	return Field{Key: "seq", Value: seq}
+}
+
+// Peer is to create field of remote address
+func Peer(addr string) Field {
+	return Field{Key: "peer", Value: addr}
+}
+
+// Latency is to create field of latency
+func Latency(d time.Duration) Field {
+	return Field{Key: "latency", Value: d}
+}
+
+// Logger is to log messages with structured fields at levels
+type Logger interface {
+	Log(level Level, msg string, fields ...Field)
+}
+
+// nopLogger drops everything
+type nopLogger struct{}
+
+func (nopLogger) Log(Level, string, ...Field) {}
+
+// Nop is to get logger which drops everything
+func Nop() Logger {
+	return nopLogger{}
+}
+
+// StdLogger includes standard logger and the minimum level to log
+type StdLogger struct {
+	logger *log.Logger
+	level  Level
+}
+
+var _ Logger = (*StdLogger)(nil)
+
+// NewStdLogger is to create logger writing entries at or above level to w as text lines,
+// a nil w writes through the standard log package
+func NewStdLogger(w io.Writer, level Level) *StdLogger {
+	l := &StdLogger{level: level}
+	if w != nil {
+		l.logger = log.New(w, "", log.LstdFlags)
+	}
+	return l
+}
+
+// Log is to write one text line with level, message and fields as key=value pairs, fields of nil value are skipped
+func (l *StdLogger) Log(level Level, msg string, fields ...Field) {
+	if level < l.level {
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString(level.String())
+	b.WriteByte(' ')
+	b.WriteString(msg)
+	for _, field := range fields {
+		if field.Value == nil {
+			continue
+		}
+		b.WriteByte(' ')
+		b.WriteString(field.Key)
+		b.WriteByte('=')
+		b.WriteString(formatValue(field.Value))
+	}
+
+	if l.logger == nil {
+		log.Println(b.String())
+		return
+	}
+	l.logger.Println(b.String())
+}
+
+func formatValue(value interface{}) string {
+	s := fmt.Sprint(value)
+	if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+var defaultLogger atomic.Value
+
+func init() {
+	SetDefault(NewStdLogger(nil, Info))
+}
+
+// Default is to get logger used when none is given, which writes info and above through the standard log package
+func Default() Logger {
+	return defaultLogger.Load().(*holder).Logger
+}
+
+// SetDefault is to replace logger used when none is given, e.g. by Nop() to silence everything
+func SetDefault(l Logger) {
+	if l == nil {
+		l = Nop()
+	}
+	defaultLogger.Store(&holder{l})
+}
+
+// OrDefault is to get l, or the default logger if l is nil
+func OrDefault(l Logger) Logger {
+	if l == nil {
+		return Default()
+	}
+	return l
+}
+
+// holder keeps atomic value of the same concrete type for any logger
+type holder struct {
+	Logger
+}
@@ -0,0 +1,125 @@
+package logger
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// RotatingFile includes path, size limit, backups to keep and the file being written
+type RotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	file *os.File
+	size int64
+	mu   sync.Mutex
+}
+
+var _ io.WriteCloser = (*RotatingFile)(nil)
+
+// NewRotatingFile is to create file appended until it exceeds maxSize bytes, then renamed to path.1 with older
+// backups shifted up to path.maxBackups, maxSize 0 means rotating only by Rotate
+func NewRotatingFile(path string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+	f := &RotatingFile{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+// Write is to append p, rotating first if p would exceed size limit of a non empty file
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return 0, fmt.Errorf("logger: failed to write %s, err: file closed", f.path)
+	}
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Rotate is to rotate now, e.g. on a signal from an external log rotator
+func (f *RotatingFile) Rotate() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return fmt.Errorf("logger: failed to rotate %s, err: file closed", f.path)
+	}
+	return f.rotate()
+}
+
+// rotate is to shift backups and reopen path, which is reopened even if shifting fails so that writes go on
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+
+	err := f.shift()
+	if openErr := f.open(); openErr != nil {
+		return openErr
+	}
+	return err
+}
+
+func (f *RotatingFile) shift() error {
+	if f.maxBackups <= 0 {
+		return os.Remove(f.path)
+	}
+
+	for i := f.maxBackups - 1; i > 0; i-- {
+		from := fmt.Sprintf("%s.%d", f.path, i)
+		if _, err := os.Stat(from); err != nil {
+			continue
+		}
+		if err := os.Rename(from, fmt.Sprintf("%s.%d", f.path, i+1)); err != nil {
+			return err
+		}
+	}
+	return os.Rename(f.path, f.path+".1")
+}
+
+// Close is to close the file being written
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
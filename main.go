@@ -69,7 +69,8 @@ func StartClient(addr chan string) {
 }
 
 func ClientCall(i int, conn *client.Client) {
-	ctx, _ := context.WithTimeout(context.Background(), time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
 	serviceMethod := "Foo.Sum"
 	args := &Args{Num1: i, Num2: i * i}
 	reply := 0
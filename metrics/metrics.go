@@ -0,0 +1,156 @@
+package metrics
+
+import (
+	"fmt"
+	"gingle-rpc/service"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ContentType is the content type of prometheus text exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// CounterVec includes counters by label value
+type CounterVec struct {
+	counters sync.Map // label value -> *uint64
+}
+
+// Add is to add delta to the counter of label value
+func (v *CounterVec) Add(label string, delta uint64) {
+	counterInterface, ok := v.counters.Load(label)
+	if !ok {
+		counterInterface, _ = v.counters.LoadOrStore(label, new(uint64))
+	}
+	atomic.AddUint64(counterInterface.(*uint64), delta)
+}
+
+// Snapshot is to copy all counters
+func (v *CounterVec) Snapshot() map[string]uint64 {
+	snapshot := make(map[string]uint64)
+	v.counters.Range(func(labelInterface, counterInterface interface{}) bool {
+		snapshot[labelInterface.(string)] = atomic.LoadUint64(counterInterface.(*uint64))
+		return true
+	})
+	return snapshot
+}
+
+// HistogramVec includes latency histograms by label value
+type HistogramVec struct {
+	histograms sync.Map // label value -> *service.Histogram
+}
+
+// Get is to get the histogram of label value, creating it if not exists
+func (v *HistogramVec) Get(label string) *service.Histogram {
+	histogramInterface, ok := v.histograms.Load(label)
+	if !ok {
+		histogramInterface, _ = v.histograms.LoadOrStore(label, service.NewHistogram())
+	}
+	return histogramInterface.(*service.Histogram)
+}
+
+// Snapshot is to copy all histograms
+func (v *HistogramVec) Snapshot() map[string]*service.HistogramSnapshot {
+	snapshot := make(map[string]*service.HistogramSnapshot)
+	v.histograms.Range(func(labelInterface, histogramInterface interface{}) bool {
+		snapshot[labelInterface.(string)] = histogramInterface.(*service.Histogram).Snapshot()
+		return true
+	})
+	return snapshot
+}
+
+// Writer includes io writer and the first error while writing
+type Writer struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+// NewWriter is to create writer of prometheus text exposition format
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// Result is to get bytes written and the first error
+func (w *Writer) Result() (int64, error) {
+	return w.n, w.err
+}
+
+func (w *Writer) printf(format string, args ...interface{}) {
+	if w.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(w.w, format, args...)
+	w.n += int64(n)
+	w.err = err
+}
+
+// Header is to write help and type of metric family
+func (w *Writer) Header(name, help, typ string) {
+	w.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// Sample is to write one sample with labels given as name and value pairs
+func (w *Writer) Sample(name string, value float64, labels ...string) {
+	w.printf("%s%s %s\n", name, formatLabels(labels), strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+// Histogram is to write buckets, sum and count of histogram in seconds with labels given as name and value pairs
+func (w *Writer) Histogram(name string, snapshot *service.HistogramSnapshot, labels ...string) {
+	var cumulative uint64
+	for i, bound := range snapshot.Bounds {
+		cumulative += snapshot.Counts[i]
+		w.Sample(name+"_bucket", float64(cumulative), append(labels, "le", strconv.FormatFloat(bound.Seconds(), 'g', -1, 64))...)
+	}
+	w.Sample(name+"_bucket", float64(snapshot.Count), append(labels, "le", "+Inf")...)
+	w.Sample(name+"_sum", snapshot.Sum.Seconds(), labels...)
+	w.Sample(name+"_count", float64(snapshot.Count), labels...)
+}
+
+// CounterVec is to write all counters of counter vec sorted by label value
+func (w *Writer) CounterVec(name, label string, v *CounterVec) {
+	snapshot := v.Snapshot()
+	for _, value := range sortedKeys(snapshot) {
+		w.Sample(name, float64(snapshot[value]), label, value)
+	}
+}
+
+func formatLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", labels[i], escapeLabelValue(labels[i+1])))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelValueReplacer = strings.NewReplacer("\\", `\\`, "\"", `\"`, "\n", `\n`)
+
+func escapeLabelValue(value string) string {
+	return labelValueReplacer.Replace(value)
+}
+
+// SortedLabels is to get sorted label values of histogram snapshots
+func SortedLabels(m map[string]*service.HistogramSnapshot) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedKeys(m map[string]uint64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
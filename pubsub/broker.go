@@ -0,0 +1,325 @@
+package pubsub
+
+import (
+	"gingle-rpc/logger"
+	"gingle-rpc/service"
+	"gingle-rpc/status"
+	"sync"
+	"time"
+)
+
+// BrokerOption includes ack timeout before redelivery, max unacked messages kept per subscription, whether the oldest
+// message is dropped rather than publishing rejected beyond it, how long a subscription without subscriber is retained
+// and the logger which is never sent
+type BrokerOption struct {
+	AckTimeout time.Duration
+	MaxPending int
+	DropOldest bool
+	Retention  time.Duration
+
+	Logger logger.Logger `json:"-"`
+}
+
+var DefaultBrokerOption *BrokerOption = &BrokerOption{
+	AckTimeout: 30 * time.Second,
+	MaxPending: 1024,
+	Retention:  5 * time.Minute,
+}
+
+// PublishArgs includes topic and data of a message
+type PublishArgs struct {
+	Topic string
+	Data  []byte
+}
+
+// PublishReply includes id of the message and the number of subscriptions it is queued for
+type PublishReply struct {
+	ID          uint64
+	Subscribers int
+}
+
+// SubscribeArgs includes subscription id and topic pattern, subscribing by an existing id resumes it with unacked messages
+type SubscribeArgs struct {
+	Subscription string
+	Pattern      string
+}
+
+// AckArgs includes subscription id and ids of messages processed
+type AckArgs struct {
+	Subscription string
+	IDs          []uint64
+}
+
+// UnsubscribeArgs includes subscription id
+type UnsubscribeArgs struct {
+	Subscription string
+}
+
+// Message includes id, topic, data and delivery attempt starting from 1, redelivered until acked
+type Message struct {
+	ID      uint64
+	Topic   string
+	Data    []byte
+	Attempt int
+}
+
+// delivery includes a message queued for a subscription, when it was last sent and delivery attempts
+type delivery struct {
+	message  Message
+	sentAt   time.Time
+	attempts int
+}
+
+// subscription includes id, pattern, unacked deliveries in publish order, subscriber generation, when it was detached,
+// and channels broadcasting changes and closing
+type subscription struct {
+	id      string
+	pattern string
+
+	deliveries []*delivery
+	generation uint64
+	attached   bool
+	detachedAt time.Time
+
+	changed chan struct{}
+	closed  chan struct{}
+}
+
+func (s *subscription) notifyChanged() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// Broker is the service of topics with wildcard subscriptions and at least once delivery, registered to server.Server,
+// messages are redelivered until acked and kept while a subscription is retained, beyond MaxPending publishing is rejected
+// with ResourceExhausted unless DropOldest opts in dropping the oldest
+type Broker struct {
+	opt    *BrokerOption
+	logger logger.Logger
+
+	seq           uint64
+	subscriptions map[string]*subscription
+	dropped       uint64
+	mu            sync.Mutex
+}
+
+// NewBroker is to create broker by opt, nil means the default option
+func NewBroker(opt *BrokerOption) *Broker {
+	if opt == nil {
+		opt = DefaultBrokerOption
+	}
+	return &Broker{
+		opt:           opt,
+		logger:        logger.OrDefault(opt.Logger),
+		subscriptions: make(map[string]*subscription),
+	}
+}
+
+// Publish is to queue message for all subscriptions matching its topic, or for none of them if one is full
+// without DropOldest, so that publishers back off with ResourceExhausted instead of losing messages
+func (b *Broker) Publish(args PublishArgs, reply *PublishReply) error {
+	if !ValidTopic(args.Topic) {
+		return status.Errorf(status.InvalidArgument, "pubsub: topic %q format not correct", args.Topic)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var matched []*subscription
+	now := time.Now()
+	for id, sub := range b.subscriptions {
+		// subscriptions without subscriber are purged lazily once retention passes
+		if !sub.attached && now.Sub(sub.detachedAt) > b.opt.Retention {
+			delete(b.subscriptions, id)
+			close(sub.closed)
+			continue
+		}
+		if !Match(sub.pattern, args.Topic) {
+			continue
+		}
+		if len(sub.deliveries) >= b.opt.MaxPending && !b.opt.DropOldest {
+			return status.Errorf(status.ResourceExhausted, "pubsub: failed to publish, err: subscription %s has %d unacked messages",
+				id, len(sub.deliveries))
+		}
+		matched = append(matched, sub)
+	}
+
+	b.seq++
+	reply.ID = b.seq
+	for _, sub := range matched {
+		if len(sub.deliveries) >= b.opt.MaxPending {
+			b.dropped++
+			b.logger.Log(logger.Warn, "pubsub: failed to keep message, drop the oldest", logger.Any("subscription", sub.id),
+				logger.Any("id", sub.deliveries[0].message.ID))
+			sub.deliveries = sub.deliveries[1:]
+		}
+		sub.deliveries = append(sub.deliveries, &delivery{message: Message{ID: b.seq, Topic: args.Topic, Data: args.Data}})
+		sub.notifyChanged()
+		reply.Subscribers++
+	}
+	return nil
+}
+
+// Subscribe is to stream messages of subscription until the stream is canceled or the subscription is unsubscribed,
+// a later subscribe by the same id takes the subscription over
+func (b *Broker) Subscribe(args SubscribeArgs, stream service.ServerStream) error {
+	if args.Subscription == "" {
+		return status.Errorf(status.InvalidArgument, "pubsub: subscription id is empty")
+	}
+	if !ValidPattern(args.Pattern) {
+		return status.Errorf(status.InvalidArgument, "pubsub: pattern %q format not correct", args.Pattern)
+	}
+
+	sub, generation, err := b.attach(args)
+	if err != nil {
+		return err
+	}
+	defer b.detach(sub, generation)
+
+	ctx := stream.Context()
+	for {
+		message, wait, err := b.next(sub, generation)
+		if err != nil {
+			return err
+		}
+		if message != nil {
+			if err := stream.Send(message); err != nil {
+				return err
+			}
+			continue
+		}
+
+		b.mu.Lock()
+		changed := sub.changed
+		b.mu.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-sub.closed:
+			timer.Stop()
+			return nil
+		case <-changed:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// Ack is to remove processed messages from subscription, which is called one way
+func (b *Broker) Ack(args AckArgs, _ service.OneWay) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sub, ok := b.subscriptions[args.Subscription]
+	if !ok {
+		// nothing is sent back to a one way call, so the error would be lost without logging
+		err := status.Errorf(status.NotFound, "pubsub: subscription %s not found", args.Subscription)
+		b.logger.Log(logger.Warn, "pubsub: failed to ack", logger.Any("subscription", args.Subscription), logger.Any("ids", args.IDs), logger.Err(err))
+		return err
+	}
+
+	acked := make(map[uint64]bool, len(args.IDs))
+	for _, id := range args.IDs {
+		acked[id] = true
+	}
+	deliveries := sub.deliveries[:0]
+	for _, d := range sub.deliveries {
+		if !acked[d.message.ID] {
+			deliveries = append(deliveries, d)
+		}
+	}
+	sub.deliveries = deliveries
+	return nil
+}
+
+// Unsubscribe is to remove subscription with its unacked messages and end its stream
+func (b *Broker) Unsubscribe(args UnsubscribeArgs, reply *bool) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sub, ok := b.subscriptions[args.Subscription]
+	if !ok {
+		return status.Errorf(status.NotFound, "pubsub: subscription %s not found", args.Subscription)
+	}
+	delete(b.subscriptions, args.Subscription)
+	close(sub.closed)
+	*reply = true
+	return nil
+}
+
+// Dropped is to get the number of messages dropped beyond MaxPending with DropOldest
+func (b *Broker) Dropped() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.dropped
+}
+
+// attach is to create or resume subscription and return the generation of its subscriber
+func (b *Broker) attach(args SubscribeArgs) (*subscription, uint64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sub, ok := b.subscriptions[args.Subscription]
+	if !ok {
+		sub = &subscription{
+			id:      args.Subscription,
+			pattern: args.Pattern,
+			changed: make(chan struct{}),
+			closed:  make(chan struct{}),
+		}
+		b.subscriptions[args.Subscription] = sub
+	} else if sub.pattern != args.Pattern {
+		return nil, 0, status.Errorf(status.AlreadyExists, "pubsub: subscription %s already subscribes %s", args.Subscription, sub.pattern)
+	}
+
+	// messages sent to the former subscriber are sent again at once
+	for _, d := range sub.deliveries {
+		d.sentAt = time.Time{}
+	}
+	sub.generation++
+	sub.attached = true
+	sub.notifyChanged()
+	return sub, sub.generation, nil
+}
+
+// detach is to keep subscription for retention once its subscriber of generation is gone
+func (b *Broker) detach(sub *subscription, generation uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if sub.generation == generation {
+		sub.attached = false
+		sub.detachedAt = time.Now()
+	}
+}
+
+// next is to get the next message to send, which is the first never sent or not acked within ack timeout,
+// otherwise the time to wait for the earliest redelivery
+func (b *Broker) next(sub *subscription, generation uint64) (*Message, time.Duration, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if sub.generation != generation {
+		return nil, 0, status.Errorf(status.Aborted, "pubsub: subscription %s taken over by another subscriber", sub.id)
+	}
+
+	now := time.Now()
+	wait := b.opt.AckTimeout
+	for _, d := range sub.deliveries {
+		if d.sentAt.IsZero() || now.Sub(d.sentAt) >= b.opt.AckTimeout {
+			d.sentAt = now
+			d.attempts++
+			message := d.message
+			message.Attempt = d.attempts
+			return &message, 0, nil
+		}
+		if due := b.opt.AckTimeout - now.Sub(d.sentAt); due < wait {
+			wait = due
+		}
+	}
+	return nil, wait, nil
+}
@@ -0,0 +1,191 @@
+package pubsub
+
+import (
+	"context"
+	"gingle-rpc/service"
+	"gingle-rpc/status"
+	"testing"
+	"time"
+)
+
+// testStream is the server stream of a subscriber in tests, whose messages are received from the channel
+type testStream struct {
+	ctx      context.Context
+	messages chan *Message
+}
+
+func (s *testStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *testStream) Send(reply interface{}) error {
+	s.messages <- reply.(*Message)
+	return nil
+}
+
+// subscribe is to subscribe in background, and return the stream, its cancel function and the error of Subscribe
+func subscribe(t *testing.T, b *Broker, id, pattern string) (*testStream, context.CancelFunc, chan error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	stream := &testStream{ctx: ctx, messages: make(chan *Message, 16)}
+	done := make(chan error, 1)
+	go func() {
+		done <- b.Subscribe(SubscribeArgs{Subscription: id, Pattern: pattern}, stream)
+	}()
+	return stream, cancel, done
+}
+
+func receive(t *testing.T, stream *testStream) *Message {
+	select {
+	case m := <-stream.messages:
+		return m
+	case <-time.After(time.Second):
+		t.Fatalf("no message received")
+		return nil
+	}
+}
+
+func publish(t *testing.T, b *Broker, topic string) uint64 {
+	var reply PublishReply
+	if err := b.Publish(PublishArgs{Topic: topic, Data: []byte(topic)}, &reply); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	return reply.ID
+}
+
+func waitSubscribers(t *testing.T, b *Broker, n int) {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		b.mu.Lock()
+		attached := 0
+		for _, sub := range b.subscriptions {
+			if sub.attached {
+				attached++
+			}
+		}
+		b.mu.Unlock()
+		if attached == n {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("%d subscribers not attached", n)
+}
+
+func TestBrokerRedelivery(t *testing.T) {
+	tests := []struct {
+		name        string
+		ack         bool
+		wantAttempt int
+	}{
+		{"redelivered until acked", false, 2},
+		{"acked before timeout", true, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBroker(&BrokerOption{AckTimeout: 50 * time.Millisecond, MaxPending: 8, Retention: time.Minute})
+			stream, _, _ := subscribe(t, b, "sub", "orders.>")
+			waitSubscribers(t, b, 1)
+
+			id := publish(t, b, "orders.created")
+			if m := receive(t, stream); m.ID != id || m.Attempt != 1 {
+				t.Fatalf("message %d at attempt %d, want %d at attempt 1", m.ID, m.Attempt, id)
+			}
+			if tt.ack {
+				_ = b.Ack(AckArgs{Subscription: "sub", IDs: []uint64{id}}, service.OneWay{})
+			}
+
+			select {
+			case m := <-stream.messages:
+				if m.ID != id || m.Attempt != tt.wantAttempt {
+					t.Fatalf("message %d at attempt %d, want %d at attempt %d", m.ID, m.Attempt, id, tt.wantAttempt)
+				}
+			case <-time.After(200 * time.Millisecond):
+				if tt.wantAttempt != 0 {
+					t.Fatalf("message %d not redelivered", id)
+				}
+			}
+		})
+	}
+}
+
+func TestBrokerTakeover(t *testing.T) {
+	b := NewBroker(&BrokerOption{AckTimeout: time.Minute, MaxPending: 8, Retention: time.Minute})
+	former, _, formerDone := subscribe(t, b, "sub", "orders.*")
+	waitSubscribers(t, b, 1)
+
+	id := publish(t, b, "orders.created")
+	receive(t, former)
+
+	// the unacked message is sent again at once to the subscriber taking over, and the former one is aborted
+	latter, _, _ := subscribe(t, b, "sub", "orders.*")
+	if m := receive(t, latter); m.ID != id || m.Attempt != 2 {
+		t.Fatalf("message %d at attempt %d, want %d at attempt 2", m.ID, m.Attempt, id)
+	}
+	_ = publish(t, b, "orders.deleted")
+	select {
+	case err := <-formerDone:
+		if status.CodeOf(err) != status.Aborted {
+			t.Fatalf("former err = %v, want aborted", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("former subscriber not aborted")
+	}
+	receive(t, latter)
+
+	// taking over by another pattern is rejected
+	_, _, otherDone := subscribe(t, b, "sub", "payments.*")
+	if err := <-otherDone; status.CodeOf(err) != status.AlreadyExists {
+		t.Fatalf("err = %v, want already exists", err)
+	}
+}
+
+func TestBrokerMaxPending(t *testing.T) {
+	tests := []struct {
+		name        string
+		dropOldest  bool
+		wantCode    status.Code
+		wantDropped uint64
+		wantFirst   uint64
+	}{
+		{"rejected by default", false, status.ResourceExhausted, 0, 1},
+		{"oldest dropped when opted in", true, status.OK, 1, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBroker(&BrokerOption{AckTimeout: time.Minute, MaxPending: 2, DropOldest: tt.dropOldest, Retention: time.Minute})
+			_, cancel, done := subscribe(t, b, "sub", "orders.*")
+			waitSubscribers(t, b, 1)
+			cancel()
+			<-done
+
+			publish(t, b, "orders.created")
+			publish(t, b, "orders.updated")
+			var reply PublishReply
+			err := b.Publish(PublishArgs{Topic: "orders.deleted"}, &reply)
+			if status.CodeOf(err) != tt.wantCode {
+				t.Fatalf("err = %v, want %s", err, tt.wantCode)
+			}
+			if b.Dropped() != tt.wantDropped {
+				t.Fatalf("dropped = %d, want %d", b.Dropped(), tt.wantDropped)
+			}
+
+			// the retained subscription is resumed with the messages kept
+			stream, _, _ := subscribe(t, b, "sub", "orders.*")
+			if m := receive(t, stream); m.ID != tt.wantFirst {
+				t.Fatalf("first message = %d, want %d", m.ID, tt.wantFirst)
+			}
+		})
+	}
+}
+
+func TestBrokerAckUnknownSubscription(t *testing.T) {
+	b := NewBroker(nil)
+	err := b.Ack(AckArgs{Subscription: "unknown", IDs: []uint64{1}}, service.OneWay{})
+	if status.CodeOf(err) != status.NotFound {
+		t.Fatalf("err = %v, want not found", err)
+	}
+}
@@ -0,0 +1,110 @@
+package pubsub
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"gingle-rpc/client"
+	"gingle-rpc/codec"
+	"gingle-rpc/server"
+	"net"
+)
+
+const brokerName = "Broker"
+
+// Client includes the rpc client connected to the server running broker
+type Client struct {
+	client *client.Client
+}
+
+// NewClient is to publish and subscribe through c
+func NewClient(c *client.Client) *Client {
+	return &Client{client: c}
+}
+
+// NewInProcessClient is to serve s over an in memory pipe and publish and subscribe through it, nil opt means the default option
+func NewInProcessClient(s *server.Server, opt *codec.Option) (*Client, error) {
+	if opt == nil {
+		opt = codec.DefaultOption
+	}
+
+	serverConn, clientConn := net.Pipe()
+	go s.ServeConn(serverConn)
+	c, err := client.NewRPCClient(clientConn, opt)
+	if err != nil {
+		_ = clientConn.Close()
+		return nil, err
+	}
+	return NewClient(c), nil
+}
+
+// Close is to close the rpc client
+func (c *Client) Close() error {
+	return c.client.Close()
+}
+
+// Publish is to publish data to topic, and return the id of message, ResourceExhausted if a subscription is full so that the caller backs off
+func (c *Client) Publish(ctx context.Context, topic string, data []byte) (uint64, error) {
+	var reply PublishReply
+	if err := c.client.Call(ctx, brokerName+".Publish", PublishArgs{Topic: topic, Data: data}, &reply); err != nil {
+		return 0, err
+	}
+	return reply.ID, nil
+}
+
+// Subscription includes id, pattern and the stream of messages
+type Subscription struct {
+	ID      string
+	Pattern string
+
+	client *Client
+	stream *client.Stream
+}
+
+// Subscribe is to receive messages of topics matching pattern until ctx is done, empty id means a new subscription
+// and an existing id resumes it with messages not acked yet
+func (c *Client) Subscribe(ctx context.Context, id, pattern string) (*Subscription, error) {
+	if id == "" {
+		b := make([]byte, 8)
+		if _, err := rand.Read(b); err != nil {
+			return nil, fmt.Errorf("pubsub: failed to generate subscription id, err: %v", err)
+		}
+		id = hex.EncodeToString(b)
+	}
+
+	stream, err := c.client.NewServerStream(ctx, brokerName+".Subscribe", SubscribeArgs{Subscription: id, Pattern: pattern})
+	if err != nil {
+		return nil, err
+	}
+	return &Subscription{ID: id, Pattern: pattern, client: c, stream: stream}, nil
+}
+
+// Recv is to wait for the next message, which is redelivered after ack timeout until acked
+func (s *Subscription) Recv() (*Message, error) {
+	message := &Message{}
+	if err := s.stream.Recv(message); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// Ack is to acknowledge messages processed, one way so that a lost ack only causes redelivery
+func (s *Subscription) Ack(ctx context.Context, messages ...*Message) error {
+	args := AckArgs{Subscription: s.ID}
+	for _, message := range messages {
+		args.IDs = append(args.IDs, message.ID)
+	}
+	return s.client.client.Notify(ctx, brokerName+".Ack", args)
+}
+
+// Close is to stop receiving, the subscription is retained by broker to be resumed by its id
+func (s *Subscription) Close() error {
+	return s.stream.Close()
+}
+
+// Unsubscribe is to remove the subscription with messages not acked yet, which ends the stream
+func (s *Subscription) Unsubscribe(ctx context.Context) error {
+	var reply bool
+	return s.client.client.Call(ctx, brokerName+".Unsubscribe", UnsubscribeArgs{Subscription: s.ID}, &reply)
+}
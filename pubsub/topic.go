@@ -0,0 +1,46 @@
+package pubsub
+
+import (
+	"strings"
+)
+
+// Wildcards of topic patterns, topics are tokens separated by dots
+const (
+	WildcardToken = "*" // matches exactly one token
+	WildcardTail  = ">" // matches one or more tokens at the end
+)
+
+// ValidTopic is to check topic has no empty token or wildcard
+func ValidTopic(topic string) bool {
+	for _, token := range strings.Split(topic, ".") {
+		if token == "" || token == WildcardToken || token == WildcardTail {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidPattern is to check pattern has no empty token and the tail wildcard only at the end
+func ValidPattern(pattern string) bool {
+	tokens := strings.Split(pattern, ".")
+	for i, token := range tokens {
+		if token == "" || (token == WildcardTail && i != len(tokens)-1) {
+			return false
+		}
+	}
+	return true
+}
+
+// Match is to check whether topic matches pattern, e.g. orders.* matches orders.created and orders.> matches orders.eu.created
+func Match(pattern, topic string) bool {
+	patternTokens, topicTokens := strings.Split(pattern, "."), strings.Split(topic, ".")
+	for i, token := range patternTokens {
+		if token == WildcardTail {
+			return len(topicTokens) > i
+		}
+		if i >= len(topicTokens) || (token != WildcardToken && token != topicTokens[i]) {
+			return false
+		}
+	}
+	return len(patternTokens) == len(topicTokens)
+}
@@ -0,0 +1,62 @@
+package pubsub
+
+import (
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		topic   string
+		want    bool
+	}{
+		{"orders.created", "orders.created", true},
+		{"orders.created", "orders.deleted", false},
+		{"orders.*", "orders.created", true},
+		{"orders.*", "orders", false},
+		{"orders.*", "orders.eu.created", false},
+		{"*.created", "orders.created", true},
+		{"orders.>", "orders.created", true},
+		{"orders.>", "orders.eu.created", true},
+		{"orders.>", "orders", false},
+		{">", "orders", true},
+		{"orders.*.created", "orders.eu.created", true},
+		{"orders.*.>", "orders.eu", false},
+		{"orders", "orders.created", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.topic, func(t *testing.T) {
+			if got := Match(tt.pattern, tt.topic); got != tt.want {
+				t.Fatalf("Match(%q, %q) = %v, want %v", tt.pattern, tt.topic, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValid(t *testing.T) {
+	tests := []struct {
+		name        string
+		wantTopic   bool
+		wantPattern bool
+	}{
+		{"orders.created", true, true},
+		{"orders..created", false, false},
+		{"", false, false},
+		{"orders.*", false, true},
+		{"orders.>", false, true},
+		{"orders.>.created", false, false},
+		{"orders.", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ValidTopic(tt.name); got != tt.wantTopic {
+				t.Fatalf("ValidTopic(%q) = %v, want %v", tt.name, got, tt.wantTopic)
+			}
+			if got := ValidPattern(tt.name); got != tt.wantPattern {
+				t.Fatalf("ValidPattern(%q) = %v, want %v", tt.name, got, tt.wantPattern)
+			}
+		})
+	}
+}
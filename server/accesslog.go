@@ -0,0 +1,144 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"gingle-rpc/codec"
+	"gingle-rpc/logger"
+	"io"
+	"sync"
+	"time"
+)
+
+// | {"Time":"...","Peer":"127.0.0.1:52110","Codec":"application/gob","ServiceMethod":"Foo.Sum","SequenceNumber":3,"RequestBytes":41,"ResponseBytes":28,"Latency":"85µs","LatencyNanos":85000,"Code":"OK","Error":""} |
+
+// AccessLogEntry includes time, peer, codec, service method, sequence number, sizes, latency, status code and error of one call
+type AccessLogEntry struct {
+	Time           time.Time
+	Peer           string
+	Codec          string
+	ServiceMethod  string
+	SequenceNumber uint64
+	RequestBytes   uint64
+	ResponseBytes  uint64
+	Latency        string
+	LatencyNanos   int64
+	Code           string
+	Error          string
+}
+
+// accessLog includes json encoder of the writer and mutex keeping lines whole
+type accessLog struct {
+	enc *json.Encoder
+	mu  sync.Mutex
+}
+
+func (l *accessLog) write(entry *AccessLogEntry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.enc.Encode(entry)
+}
+
+// Redactor is to replace sensitive values in args of service method before they are logged
+type Redactor func(serviceMethod string, args interface{}) interface{}
+
+// RedactFields is to create redactor which masks json fields with any of names at any depth
+func RedactFields(names ...string) Redactor {
+	redacted := make(map[string]bool, len(names))
+	for _, name := range names {
+		redacted[name] = true
+	}
+
+	return func(serviceMethod string, args interface{}) interface{} {
+		data, err := json.Marshal(args)
+		if err != nil {
+			return fmt.Sprintf("<unencodable %T>", args)
+		}
+		var value interface{}
+		if err := json.Unmarshal(data, &value); err != nil {
+			return fmt.Sprintf("<unencodable %T>", args)
+		}
+		return redactValue(value, redacted)
+	}
+}
+
+func redactValue(value interface{}, redacted map[string]bool) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			if redacted[key] {
+				v[key] = "[REDACTED]"
+				continue
+			}
+			v[key] = redactValue(field, redacted)
+		}
+	case []interface{}:
+		for i, elem := range v {
+			v[i] = redactValue(elem, redacted)
+		}
+	}
+	return value
+}
+
+// slowCallLog includes latency threshold and redactor of args
+type slowCallLog struct {
+	threshold time.Duration
+	redact    Redactor
+}
+
+// SetAccessLog is to write one json line per call to w, e.g. a logger.RotatingFile, nil means disabled
+func (s *Server) SetAccessLog(w io.Writer) {
+	if w == nil {
+		s.accessLog.Store((*accessLog)(nil))
+		return
+	}
+	s.accessLog.Store(&accessLog{enc: json.NewEncoder(w)})
+}
+
+// SetSlowCallLog is to log args of calls slower than threshold by the server logger, after redacted by redact if not nil,
+// 0 threshold means disabled
+func (s *Server) SetSlowCallLog(threshold time.Duration, redact Redactor) {
+	if threshold <= 0 {
+		s.slowCallLog.Store((*slowCallLog)(nil))
+		return
+	}
+	s.slowCallLog.Store(&slowCallLog{threshold: threshold, redact: redact})
+}
+
+// logCall is to write access log and slow call log of a call whose response has been sent
+func (s *Server) logCall(call *Call, opt *codec.Option, counter *countingConn, responseBytes uint64) {
+	latency := time.Since(call.startAt)
+
+	if al, _ := s.accessLog.Load().(*accessLog); al != nil {
+		entry := &AccessLogEntry{
+			Time:           call.startAt,
+			Peer:           counter.peer.Addr,
+			Codec:          opt.CodecType,
+			ServiceMethod:  call.Header.ServiceMethod,
+			SequenceNumber: call.Header.SequenceNumber,
+			RequestBytes:   call.requestBytes,
+			ResponseBytes:  responseBytes,
+			Latency:        latency.String(),
+			LatencyNanos:   int64(latency),
+			Code:           call.Header.Code.String(),
+			Error:          call.Header.Error,
+		}
+		if err := al.write(entry); err != nil {
+			s.getLogger().Log(logger.Error, "server: failed to write access log", logger.Err(err))
+		}
+	}
+
+	if sl, _ := s.slowCallLog.Load().(*slowCallLog); sl != nil && latency >= sl.threshold && call.Args.IsValid() {
+		var args interface{} = call.Args.Interface()
+		if sl.redact != nil {
+			args = sl.redact(call.Header.ServiceMethod, args)
+		}
+		data, err := json.Marshal(args)
+		if err != nil {
+			data = []byte(fmt.Sprintf("%+v", args))
+		}
+		s.getLogger().Log(logger.Warn, "server: slow call", logger.Method(call.Header.ServiceMethod), logger.Seq(call.Header.SequenceNumber),
+			logger.Peer(counter.peer.Addr), logger.Latency(latency), logger.Any("args", string(data)))
+	}
+}
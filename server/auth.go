@@ -0,0 +1,55 @@
+package server
+
+import (
+	"context"
+	"gingle-rpc/auth"
+	"gingle-rpc/logger"
+	"gingle-rpc/status"
+)
+
+// authConfig includes authenticator and policy checked before every call
+type authConfig struct {
+	authenticator auth.Authenticator
+	policy        *auth.Policy
+}
+
+// SetAuth is to authenticate every call by authenticator and authorize its principal by policy before handling it,
+// nil policy allows any authenticated principal and nil authenticator disables both
+func (s *Server) SetAuth(authenticator auth.Authenticator, policy *auth.Policy) {
+	if authenticator == nil {
+		s.auth.Store((*authConfig)(nil))
+		return
+	}
+	s.auth.Store(&authConfig{authenticator: authenticator, policy: policy})
+}
+
+// authorize is to return ctx carrying the principal of call, or unauthenticated or permission denied status error
+func (s *Server) authorize(ctx context.Context, call *Call) (context.Context, error) {
+	config, _ := s.auth.Load().(*authConfig)
+	if config == nil {
+		return ctx, nil
+	}
+
+	req := &auth.Request{
+		ServiceMethod: call.Header.ServiceMethod,
+		Authorization: call.Header.Authorization,
+	}
+	peer, ok := PeerFromContext(ctx)
+	if ok {
+		req.Identity = peer.Identity()
+	}
+
+	principal, err := config.authenticator.Authenticate(req)
+	if err != nil {
+		if ok {
+			s.getLogger().Log(logger.Warn, "server: unauthenticated call", logger.Method(call.Header.ServiceMethod), logger.Seq(call.Header.SequenceNumber),
+				logger.Peer(peer.Addr), logger.Err(err))
+		}
+		return ctx, status.Errorf(status.Unauthenticated, "server: unauthenticated, err: %v", err)
+	}
+
+	if config.policy != nil && !config.policy.Allow(principal, call.Header.ServiceMethod) {
+		return ctx, status.Errorf(status.PermissionDenied, "server: permission denied, err: %s is not allowed to call %s", principal, call.Header.ServiceMethod)
+	}
+	return auth.ContextWithPrincipal(ctx, principal), nil
+}
@@ -0,0 +1,199 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"gingle-rpc/codec"
+	"gingle-rpc/logger"
+	"gingle-rpc/status"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultMaxBatchSize  = 128
+	defaultMaxBatchBytes = 4 << 20
+)
+
+// SetMaxBatchSize is to limit the number of calls in a batch, larger batches fail as a whole, 0 means the default limit.
+// It is a count limit checked after the batch is decoded, bytes read for a batch are bounded by SetMaxBatchBytes
+func (s *Server) SetMaxBatchSize(n int) {
+	if n <= 0 {
+		n = defaultMaxBatchSize
+	}
+	atomic.StoreInt64(&s.maxBatchSize, int64(n))
+}
+
+func (s *Server) getMaxBatchSize() int {
+	if n := atomic.LoadInt64(&s.maxBatchSize); n > 0 {
+		return int(n)
+	}
+	return defaultMaxBatchSize
+}
+
+// SetMaxBatchBytes is to limit the bytes of a batch body, which are checked while it is read so that a larger batch is
+// rejected before it is decoded whole, and the connection is closed after the error response since the rest of the
+// batch is left unread, 0 means the default limit
+func (s *Server) SetMaxBatchBytes(n int) {
+	if n <= 0 {
+		n = defaultMaxBatchBytes
+	}
+	atomic.StoreInt64(&s.maxBatchBytes, int64(n))
+}
+
+func (s *Server) getMaxBatchBytes() int {
+	if n := atomic.LoadInt64(&s.maxBatchBytes); n > 0 {
+		return int(n)
+	}
+	return defaultMaxBatchBytes
+}
+
+// readBatch is to read the batch request of header within the byte limit, readBefore is bytes read before header,
+// the number of calls is checked once the whole batch is decoded
+func (s *Server) readBatch(cc codec.Codec, header *codec.Header, counter *countingConn, readBefore uint64) (*Call, *codec.BatchRequest, error) {
+	call := &Call{Header: header, startAt: time.Now()}
+	defer func() {
+		call.requestBytes = atomic.LoadUint64(&counter.read) - readBefore
+	}()
+
+	maxBytes := s.getMaxBatchBytes()
+	counter.limitRead(uint64(maxBytes))
+	request := &codec.BatchRequest{}
+	err := s.readRequestBody(cc, header, request, counter)
+	counter.limitRead(0)
+	if counter.exceeded {
+		return call, nil, status.Errorf(status.ResourceExhausted, "server: failed to call batch, err: body exceeds the limit of %d bytes", maxBytes)
+	}
+	if err != nil {
+		return call, nil, err
+	}
+	if n := s.getMaxBatchSize(); len(request.Items) > n {
+		return call, nil, status.Errorf(status.ResourceExhausted, "server: failed to call batch, err: %d calls exceed the limit of %d", len(request.Items), n)
+	}
+	return call, request, nil
+}
+
+// serveBatch is to call items of batch in order or in parallel, and send all results in one response, or a timeout
+// response for the whole batch once the handle timeout expires, ctx carries the peer and connection of batch
+func (s *Server) serveBatch(ctx context.Context, cc codec.Codec, opt *codec.Option, call *Call, request *codec.BatchRequest, mu *sync.Mutex,
+	wg *sync.WaitGroup, counter *countingConn) {
+	defer wg.Done()
+
+	if opt.HandleTimeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opt.HandleTimeout)
+		defer cancel()
+	}
+
+	// buffered so that items never block after a timeout, their results are dropped then
+	replyChan := make(chan *codec.BatchReply, 1)
+	go func() {
+		replyChan <- s.serveBatchItems(ctx, opt, call, request, counter)
+	}()
+
+	var reply *codec.BatchReply
+	if opt.HandleTimeout == 0 {
+		reply = <-replyChan
+	} else {
+		select {
+		case reply = <-replyChan:
+		case <-time.After(opt.HandleTimeout):
+			err := status.Errorf(status.DeadlineExceeded, "server: failed to handle batch, err: handle timeout expected within %s", opt.HandleTimeout)
+			call.Header.SetError(err)
+			s.getLogger().Log(logger.Warn, "server: failed to handle batch in time", logger.Seq(call.Header.SequenceNumber), logger.Peer(counter.peer.Addr),
+				logger.Latency(opt.HandleTimeout), logger.Any("calls", len(request.Items)))
+			s.logCall(call, opt, counter, s.sendResponse(cc, call.Header, struct{}{}, mu, counter))
+			return
+		}
+	}
+
+	s.getLogger().Log(logger.Debug, "server: handled batch", logger.Seq(call.Header.SequenceNumber), logger.Peer(counter.peer.Addr),
+		logger.Latency(time.Since(call.startAt)), logger.Any("calls", len(request.Items)))
+	s.logCall(call, opt, counter, s.sendResponse(cc, call.Header, reply, mu, counter))
+}
+
+// serveBatchItems is to call items of batch in order or in parallel, and return their results in the order of items
+func (s *Server) serveBatchItems(ctx context.Context, opt *codec.Option, call *Call, request *codec.BatchRequest, counter *countingConn) *codec.BatchReply {
+	reply := &codec.BatchReply{Results: make([]codec.BatchResult, len(request.Items))}
+	if request.Ordered {
+		for i := range request.Items {
+			reply.Results[i] = s.serveBatchItem(ctx, opt, call.Header, &request.Items[i], counter)
+		}
+		return reply
+	}
+
+	itemsWg := new(sync.WaitGroup)
+	for i := range request.Items {
+		itemsWg.Add(1)
+		go func(i int) {
+			defer itemsWg.Done()
+			reply.Results[i] = s.serveBatchItem(ctx, opt, call.Header, &request.Items[i], counter)
+		}(i)
+	}
+	itemsWg.Wait()
+	return reply
+}
+
+// serveBatchItem is to call one item of batch like a call of its own, streaming methods are not supported in batches
+func (s *Server) serveBatchItem(ctx context.Context, opt *codec.Option, header *codec.Header, item *codec.BatchItem, counter *countingConn) (result codec.BatchResult) {
+	call := &Call{
+		Header: &codec.Header{
+			ServiceMethod:  item.ServiceMethod,
+			SequenceNumber: header.SequenceNumber,
+			Traceparent:    header.Traceparent,
+			Authorization:  item.Authorization,
+		},
+		startAt:      time.Now(),
+		requestBytes: uint64(len(item.Args)),
+	}
+	defer func() {
+		if result.Error != nil {
+			call.Header.SetError(result.Error)
+		}
+		s.logCall(call, opt, counter, uint64(len(result.Reply)))
+	}()
+
+	var err error
+	call.Service, call.RpcMethod, err = s.RetrieveService(item.ServiceMethod)
+	if err != nil {
+		return codec.BatchResult{Error: status.FromError(err)}
+	}
+	if call.RpcMethod.ServerStreaming {
+		return codec.BatchResult{Error: status.New(status.Unimplemented, fmt.Sprintf("server: service.method %s streams cannot be batched", item.ServiceMethod))}
+	}
+
+	marshal, unmarshal := codec.MarshalFuncMap[opt.CodecType], codec.UnmarshalFuncMap[opt.CodecType]
+	if marshal == nil || unmarshal == nil {
+		return codec.BatchResult{Error: status.New(status.Unimplemented, fmt.Sprintf("server: failed to call batch, err: codec %s does not support batches", opt.CodecType))}
+	}
+
+	call.Args = call.RpcMethod.NewArgsValue()
+	call.Reply = call.RpcMethod.NewReplyValue()
+	argsInterface := call.Args.Interface()
+	if call.Args.Type().Kind() != reflect.Ptr {
+		argsInterface = call.Args.Addr().Interface()
+	}
+	if err := unmarshal(item.Args, argsInterface); err != nil {
+		return codec.BatchResult{Error: status.New(status.InvalidArgument, fmt.Sprintf("server: failed to unmarshal batch args, err: %v", err))}
+	}
+	call.RpcMethod.RecordRequest(call.requestBytes)
+
+	ctx, span := s.startSpan(ctx, call, counter.peer.Addr)
+	err = s.handle(ctx, call)
+	finishCall(call, span, err)
+	if err != nil {
+		return codec.BatchResult{Error: status.FromError(err)}
+	}
+	if !call.Reply.IsValid() {
+		return codec.BatchResult{}
+	}
+
+	data, err := marshal(call.Reply.Interface())
+	if err != nil {
+		return codec.BatchResult{Error: status.New(status.Internal, fmt.Sprintf("server: failed to marshal batch reply, err: %v", err))}
+	}
+	call.RpcMethod.RecordResponse(uint64(len(data)))
+	return codec.BatchResult{Reply: data}
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"context"
+	"gingle-rpc/client"
+	"gingle-rpc/codec"
+	"gingle-rpc/status"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// RecordArgs includes id recorded after delay
+type RecordArgs struct {
+	ID    int
+	Delay time.Duration
+}
+
+// Recorder is the service of tests recording ids in the order calls complete
+type Recorder struct {
+	ids []int
+	mu  sync.Mutex
+}
+
+func (r *Recorder) Record(args RecordArgs, reply *int) error {
+	time.Sleep(args.Delay)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.ids = append(r.ids, args.ID)
+	*reply = args.ID
+	return nil
+}
+
+func (r *Recorder) Echo(args string, reply *string) error {
+	*reply = args
+	return nil
+}
+
+func TestBatchOrder(t *testing.T) {
+	tests := []struct {
+		name    string
+		ordered bool
+		wantIDs []int
+	}{
+		{"ordered", true, []int{1, 2, 3}},
+		{"parallel", false, []int{3, 2, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := &Recorder{}
+			_, addr := startTestServer(t, recorder)
+			c := dialTestServer(t, addr)
+
+			// later items complete first unless called in order
+			calls := make([]*client.BatchCall, 3)
+			replies := make([]int, 3)
+			for i := range calls {
+				calls[i] = &client.BatchCall{
+					ServiceMethod: "Recorder.Record",
+					Args:          RecordArgs{ID: i + 1, Delay: time.Duration(3-i) * 40 * time.Millisecond},
+					Reply:         &replies[i],
+				}
+			}
+			if err := c.Batch(context.Background(), calls, tt.ordered); err != nil {
+				t.Fatalf("batch: %v", err)
+			}
+			if !reflect.DeepEqual(recorder.ids, tt.wantIDs) {
+				t.Fatalf("ids = %v, want %v", recorder.ids, tt.wantIDs)
+			}
+			// results are in the order of items either way
+			if !reflect.DeepEqual(replies, []int{1, 2, 3}) {
+				t.Fatalf("replies = %v, want [1 2 3]", replies)
+			}
+		})
+	}
+}
+
+func TestBatchItemErrors(t *testing.T) {
+	_, addr := startTestServer(t, &Recorder{}, Slow{}, &Streamer{})
+	c := dialTestServer(t, addr)
+
+	var reply string
+	calls := []*client.BatchCall{
+		{ServiceMethod: "Recorder.Echo", Args: "ok", Reply: &reply},
+		{ServiceMethod: "Slow.Fail", Args: 1, Reply: new(int)},
+		{ServiceMethod: "Recorder.Unknown", Args: 1, Reply: new(int)},
+		{ServiceMethod: "Streamer.Count", Args: 1, Reply: new(int)},
+	}
+	if err := c.Batch(context.Background(), calls, false); err != nil {
+		t.Fatalf("batch: %v", err)
+	}
+
+	wantCodes := []status.Code{status.OK, status.Unknown, status.NotFound, status.Unimplemented}
+	for i, call := range calls {
+		if status.CodeOf(call.Error) != wantCodes[i] {
+			t.Fatalf("call %s err = %v, want %s", call.ServiceMethod, call.Error, wantCodes[i])
+		}
+	}
+	if reply != "ok" {
+		t.Fatalf("reply = %q, want ok", reply)
+	}
+}
+
+func TestBatchLimits(t *testing.T) {
+	tests := []struct {
+		name          string
+		maxSize       int
+		maxBytes      int
+		calls         int
+		argsSize      int
+		handleTimeout time.Duration
+		delay         time.Duration
+		wantCode      status.Code
+		wantClosed    bool
+	}{
+		{"within limits", 4, 4096, 4, 16, 0, 0, status.OK, false},
+		{"oversize by count", 2, 4096, 3, 16, 0, 0, status.ResourceExhausted, false},
+		{"oversize by bytes", 4, 1024, 2, 2048, 0, 0, status.ResourceExhausted, true},
+		{"handle timeout", 4, 4096, 2, 16, 50 * time.Millisecond, 200 * time.Millisecond, status.DeadlineExceeded, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, addr := startTestServer(t, &Recorder{})
+			s.SetMaxBatchSize(tt.maxSize)
+			s.SetMaxBatchBytes(tt.maxBytes)
+			opt := *codec.DefaultOption
+			opt.HandleTimeout = tt.handleTimeout
+			c := dialTestServer(t, addr, &opt)
+
+			calls := make([]*client.BatchCall, tt.calls)
+			for i := range calls {
+				method, args := "Recorder.Echo", interface{}(strings.Repeat("x", tt.argsSize))
+				if tt.delay != 0 {
+					method, args = "Recorder.Record", RecordArgs{ID: i, Delay: tt.delay}
+				}
+				calls[i] = &client.BatchCall{ServiceMethod: method, Args: args}
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			if err := c.Batch(ctx, calls, false); status.CodeOf(err) != tt.wantCode {
+				t.Fatalf("err = %v, want %s", err, tt.wantCode)
+			}
+
+			// a batch rejected by bytes is not read further, so the connection is closed after its response
+			var reply string
+			err := c.Call(ctx, "Recorder.Echo", "next", &reply)
+			if closed := err != nil; closed != tt.wantClosed {
+				t.Fatalf("closed = %v, err: %v, want %v", closed, err, tt.wantClosed)
+			}
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package server
+
+import (
+	"context"
+	"gingle-rpc/codec"
+	"gingle-rpc/logger"
+	"gingle-rpc/status"
+	"sync"
+)
+
+// Conn includes the codec, option, write mutex and connection of a client, and callbacks waiting for responses,
+// which lets handlers call services registered by the client or push messages to it over the same connection
+type Conn struct {
+	server  *Server
+	cc      codec.Codec
+	opt     *codec.Option
+	mu      *sync.Mutex
+	counter *countingConn
+
+	seq     uint64
+	pending map[uint64]*callback
+	closed  chan struct{}
+
+	muForPending sync.Mutex
+}
+
+// callback includes reply, error and done of a call from server to client
+type callback struct {
+	reply interface{}
+	err   error
+	done  chan struct{}
+}
+
+func newConn(s *Server, cc codec.Codec, opt *codec.Option, mu *sync.Mutex, counter *countingConn) *Conn {
+	return &Conn{
+		server:  s,
+		cc:      cc,
+		opt:     opt,
+		mu:      mu,
+		counter: counter,
+		seq:     1,
+		pending: make(map[uint64]*callback),
+		closed:  make(chan struct{}),
+	}
+}
+
+type connKey struct{}
+
+// ContextWithConn is to carry connection of a call
+func ContextWithConn(ctx context.Context, conn *Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, conn)
+}
+
+// ConnFromContext is to get connection of the call carried by ctx, which is given to handlers taking context and interceptors,
+// and can be kept to push messages after the call returns
+func ConnFromContext(ctx context.Context) (*Conn, bool) {
+	conn, ok := ctx.Value(connKey{}).(*Conn)
+	return conn, ok
+}
+
+// Peer is to get peer of the connection
+func (c *Conn) Peer() *Peer {
+	return c.counter.peer
+}
+
+// Done is to get channel closed once the connection is closed
+func (c *Conn) Done() <-chan struct{} {
+	return c.closed
+}
+
+// Call is to invoke the named function registered by the client and wait for it to complete
+func (c *Conn) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	cb := &callback{reply: reply, done: make(chan struct{})}
+
+	c.muForPending.Lock()
+	select {
+	case <-c.closed:
+		c.muForPending.Unlock()
+		return status.Errorf(status.Unavailable, "server: failed to call back, err: connection closed")
+	default:
+	}
+	seq := c.seq
+	c.seq++
+	c.pending[seq] = cb
+	c.muForPending.Unlock()
+
+	if err := c.write(serviceMethod, seq, 0, args); err != nil {
+		c.cancel(seq)
+		return err
+	}
+
+	select {
+	case <-ctx.Done():
+		c.cancel(seq)
+		return status.Errorf(status.CodeOf(ctx.Err()), "server: failed to call back, err: %v", ctx.Err())
+	case <-cb.done:
+		return cb.err
+	}
+}
+
+// Notify is to invoke the named function registered by the client one way, which returns once the message is written
+func (c *Conn) Notify(ctx context.Context, serviceMethod string, args interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return status.Errorf(status.CodeOf(err), "server: failed to notify, err: %v", err)
+	}
+
+	c.muForPending.Lock()
+	select {
+	case <-c.closed:
+		c.muForPending.Unlock()
+		return status.Errorf(status.Unavailable, "server: failed to notify, err: connection closed")
+	default:
+	}
+	seq := c.seq
+	c.seq++
+	c.muForPending.Unlock()
+
+	return c.write(serviceMethod, seq, codec.FlagOneWay, args)
+}
+
+func (c *Conn) write(serviceMethod string, seq uint64, flags uint8, args interface{}) error {
+	header := &codec.Header{
+		ServiceMethod:  serviceMethod,
+		SequenceNumber: seq,
+		Flags:          codec.FlagCallback | flags,
+	}
+	if _, err := c.server.writeResponse(c.cc, header, args, c.mu, c.counter); err != nil {
+		return status.Errorf(status.Unavailable, "server: failed to call back, err: %v", err)
+	}
+	return nil
+}
+
+func (c *Conn) cancel(seq uint64) *callback {
+	c.muForPending.Lock()
+	defer c.muForPending.Unlock()
+
+	cb := c.pending[seq]
+	delete(c.pending, seq)
+	return cb
+}
+
+// receive is to read the response of a callback from the client, responses of canceled callbacks are dropped
+func (c *Conn) receive(header *codec.Header) error {
+	cb := c.cancel(header.SequenceNumber)
+
+	var err error
+	switch {
+	case cb == nil:
+		err = c.cc.ReadBody(nil)
+	case header.Err() != nil:
+		cb.err = header.Err()
+		err = c.cc.ReadBody(nil)
+	default:
+		err = c.cc.ReadBody(cb.reply)
+		if err != nil {
+			cb.err = status.Errorf(status.Internal, "server: failed to read callback body, err: %v", err)
+		}
+	}
+	if cb != nil {
+		close(cb.done)
+	}
+	if err != nil {
+		c.server.getLogger().Log(logger.Error, "server: failed to read callback response", logger.Method(header.ServiceMethod), logger.Seq(header.SequenceNumber),
+			logger.Peer(c.counter.peer.Addr), logger.Err(err))
+		c.server.codecErrors.Add("read_body", 1)
+	}
+	return err
+}
+
+// close is to fail callbacks waiting for responses once the connection is gone
+func (c *Conn) close() {
+	c.muForPending.Lock()
+	defer c.muForPending.Unlock()
+
+	close(c.closed)
+	for seq, cb := range c.pending {
+		delete(c.pending, seq)
+		cb.err = status.Errorf(status.Unavailable, "server: failed to call back, err: connection closed")
+		close(cb.done)
+	}
+}
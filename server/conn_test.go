@@ -0,0 +1,111 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"gingle-rpc/service"
+	"gingle-rpc/status"
+	"testing"
+	"time"
+)
+
+// Caller is the service of tests calling back the client over the connection of the call
+type Caller struct{}
+
+func (Caller) Ask(ctx context.Context, name string, reply *string) error {
+	conn, ok := ConnFromContext(ctx)
+	if !ok {
+		return errors.New("no connection")
+	}
+	return conn.Call(ctx, "Listener.Greet", name, reply)
+}
+
+func (Caller) Push(ctx context.Context, n int, reply *int) error {
+	conn, _ := ConnFromContext(ctx)
+	for i := 0; i < n; i++ {
+		if err := conn.Notify(ctx, "Listener.Notice", i); err != nil {
+			return err
+		}
+	}
+	*reply = n
+	return nil
+}
+
+// Listener is the service registered by clients of tests, called back by the server
+type Listener struct {
+	notices chan int
+}
+
+func (l *Listener) Greet(name string, reply *string) error {
+	if name == "" {
+		return errors.New("no name")
+	}
+	*reply = "hello " + name
+	return nil
+}
+
+func (l *Listener) Notice(i int, _ service.OneWay) error {
+	l.notices <- i
+	return nil
+}
+
+func TestCallback(t *testing.T) {
+	tests := []struct {
+		name      string
+		register  bool
+		args      string
+		wantReply string
+		wantCode  status.Code
+	}{
+		{"callback replied", true, "alice", "hello alice", status.OK},
+		{"callback failed", true, "", "", status.Unknown},
+		{"no service registered by client", false, "alice", "", status.NotFound},
+	}
+
+	_, addr := startTestServer(t, Caller{})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := dialTestServer(t, addr)
+			if tt.register {
+				if err := c.RegisterService(&Listener{}); err != nil {
+					t.Fatalf("register: %v", err)
+				}
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			var reply string
+			err := c.Call(ctx, "Caller.Ask", tt.args, &reply)
+			if status.CodeOf(err) != tt.wantCode || reply != tt.wantReply {
+				t.Fatalf("reply = %q, err: %v, want %q of %s", reply, err, tt.wantReply, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestCallbackNotifyInOrder(t *testing.T) {
+	_, addr := startTestServer(t, Caller{})
+	c := dialTestServer(t, addr)
+	listener := &Listener{notices: make(chan int, 16)}
+	if err := c.RegisterService(listener); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	var reply int
+	if err := c.Call(context.Background(), "Caller.Push", 10, &reply); err != nil {
+		t.Fatalf("call: %v", err)
+	}
+	for i := 0; i < reply; i++ {
+		select {
+		case notice := <-listener.notices:
+			if notice != i {
+				t.Fatalf("notice = %d, want %d", notice, i)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("notice %d not received", i)
+		}
+	}
+	if n := len(listener.notices); n != 0 {
+		t.Fatalf("%d notices left", n)
+	}
+}
@@ -1,27 +1,38 @@
 package server
 
 import (
+	"encoding/json"
 	"fmt"
 	"gingle-rpc/service"
 	"html/template"
 	"io"
 	"net/http"
+	"sort"
+	"time"
 )
 
 const debugHtml = `
 <html>
 	<body>
 	<title>GingleRPC Services</title>
-	{{range .}}
+	Active Connections {{.ActiveConns}}
+	{{range .Services}}
 	<hr>
 	Service {{.Name}}
 	<hr>
 		<table>
-		<th align=center>Method</th><th align=center>Calls</th>
-		{{range $name, $mtype := .Method}}
+		<th align=center>Method</th><th align=center>Calls</th><th align=center>Errors</th><th align=center>In Flight</th><th align=center>P50</th><th align=center>P90</th><th align=center>P99</th><th align=center>Request Bytes</th><th align=center>Response Bytes</th>
+		{{range .Methods}}
 			<tr>
-			<td align=left font=fixed>{{$name}}({{$mtype.ArgType}}, {{$mtype.ReplyType}}) error</td>
-			<td align=center>{{$mtype.NumCalls}}</td>
+			<td align=left font=fixed>{{.Name}}({{.ArgsType}}{{if and .ArgsType .ReplyType}}, {{end}}{{.ReplyType}}) error</td>
+			<td align=center>{{.CallTimes}}</td>
+			<td align=center>{{.ErrorTimes}}{{range $kind, $times := .ErrorKinds}}<br>{{$kind}}: {{$times}}{{end}}</td>
+			<td align=center>{{.InFlight}}</td>
+			<td align=center>{{.Latency.P50}}</td>
+			<td align=center>{{.Latency.P90}}</td>
+			<td align=center>{{.Latency.P99}}</td>
+			<td align=center>{{.RequestBytes}}</td>
+			<td align=center>{{.ResponseBytes}}</td>
 			</tr>
 		{{end}}
 		</table>
@@ -37,27 +48,102 @@ type DebugServer struct {
 	*Server
 }
 
-// DebugDto includes name and rpc methods
+// DebugDto includes active connections and services
 type DebugDto struct {
-	Name       string
-	RpcMethods map[string]*service.RpcMethod
+	ActiveConns int64
+	Services    []DebugServiceDto
 }
 
-// ServeHTTP is to parse and execute the debug template
+// DebugServiceDto includes name and methods
+type DebugServiceDto struct {
+	Name    string
+	Methods []DebugMethodDto
+}
+
+// DebugMethodDto includes name, args type, reply type, call times, error times by kind, in flight calls, latency and bytes
+type DebugMethodDto struct {
+	Name          string
+	ArgsType      string
+	ReplyType     string
+	CallTimes     uint64
+	ErrorTimes    uint64
+	ErrorKinds    map[string]uint64
+	InFlight      int64
+	Latency       DebugLatencyDto
+	RequestBytes  uint64
+	ResponseBytes uint64
+}
+
+// DebugLatencyDto includes mean and percentiles of latency
+type DebugLatencyDto struct {
+	Mean time.Duration
+	P50  time.Duration
+	P90  time.Duration
+	P99  time.Duration
+}
+
+// ServeHTTP is to render the debug page, or the debug json with query format=json
 func (s *DebugServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	var dtos []DebugDto
+	dto := s.debugDto()
+
+	if r.URL.Query().Get("format") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(dto); err != nil {
+			io.WriteString(w, fmt.Sprintf("debug: failed to serve http, err: %v\n", err))
+		}
+		return
+	}
+
+	err := debugTmpl.Execute(w, dto)
+	if err != nil {
+		io.WriteString(w, fmt.Sprintf("debug: failed to serve http, err: %v\n", err))
+	}
+}
+
+func (s *DebugServer) debugDto() *DebugDto {
+	dto := &DebugDto{ActiveConns: s.ActiveConns()}
 	s.Services.Range(func(nameInterface, svcInterface interface{}) bool {
 		name := nameInterface.(string)
 		svc := svcInterface.(*service.Service)
-		dtos = append(dtos, DebugDto{
-			Name:       name,
-			RpcMethods: svc.RpcMethods,
+
+		svcDto := DebugServiceDto{Name: name}
+		for methodName, rpcMethod := range svc.RpcMethods {
+			stats := rpcMethod.Stats()
+			var argsType, replyType string
+			if rpcMethod.ArgsType != nil {
+				argsType = rpcMethod.ArgsType.String()
+			}
+			if rpcMethod.ReplyType != nil {
+				replyType = rpcMethod.ReplyType.String()
+			}
+			svcDto.Methods = append(svcDto.Methods, DebugMethodDto{
+				Name:       methodName,
+				ArgsType:   argsType,
+				ReplyType:  replyType,
+				CallTimes:  stats.CallTimes,
+				ErrorTimes: stats.ErrorTimes,
+				ErrorKinds: stats.ErrorKinds,
+				InFlight:   stats.InFlight,
+				Latency: DebugLatencyDto{
+					Mean: stats.Latency.Mean(),
+					P50:  stats.Latency.Percentile(0.5),
+					P90:  stats.Latency.Percentile(0.9),
+					P99:  stats.Latency.Percentile(0.99),
+				},
+				RequestBytes:  stats.RequestBytes,
+				ResponseBytes: stats.ResponseBytes,
+			})
+		}
+		sort.Slice(svcDto.Methods, func(i, j int) bool {
+			return svcDto.Methods[i].Name < svcDto.Methods[j].Name
 		})
+
+		dto.Services = append(dto.Services, svcDto)
 		return true
 	})
+	sort.Slice(dto.Services, func(i, j int) bool {
+		return dto.Services[i].Name < dto.Services[j].Name
+	})
 
-	err := debugTmpl.Execute(w, dtos)
-	if err != nil {
-		io.WriteString(w, fmt.Sprintf("debug: failed to serve http, err: %v\n", err))
-	}
+	return dto
 }
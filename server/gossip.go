@@ -0,0 +1,24 @@
+package server
+
+import (
+	"fmt"
+	"gingle-rpc/gossip"
+)
+
+// JoinGossip is to start a gossip node announcing this server's rpc address in cfg and join the cluster through seeds
+func (s *Server) JoinGossip(cfg *gossip.Config, seeds []string) (*gossip.Node, error) {
+	if cfg == nil || cfg.RpcAddr == "" {
+		return nil, fmt.Errorf("server: failed to join gossip, err: rpc address not specified")
+	}
+
+	node, err := gossip.NewNode(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := node.Join(seeds); err != nil {
+		_ = node.Close()
+		return nil, err
+	}
+	return node, nil
+}
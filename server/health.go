@@ -0,0 +1,89 @@
+package server
+
+import (
+	"gingle-rpc/service"
+	"sync"
+	"time"
+)
+
+// Health is the built-in service reporting serving status of server and its services
+type Health struct {
+	server *Server
+
+	statuses map[string]service.HealthStatus
+	changed  chan struct{}
+
+	watchTimeout time.Duration
+	mu           sync.Mutex
+}
+
+func newHealth(server *Server) *Health {
+	return &Health{
+		server:       server,
+		statuses:     make(map[string]service.HealthStatus),
+		changed:      make(chan struct{}),
+		watchTimeout: defaultWatchTimeout,
+	}
+}
+
+// Check is to get the status of the service, or of the whole server if service is empty
+func (h *Health) Check(args service.HealthArgs, reply *service.HealthReply) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	reply.Status = h.status(args.Service)
+	return nil
+}
+
+// Watch is to block until the status differs from the last known one in args or watch timeout, then get the status
+func (h *Health) Watch(args service.HealthArgs, reply *service.HealthReply) error {
+	deadline := time.After(h.watchTimeout)
+	for {
+		h.mu.Lock()
+		reply.Status = h.status(args.Service)
+		changed := h.changed
+		h.mu.Unlock()
+
+		if reply.Status != args.Status {
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-deadline:
+			return nil
+		}
+	}
+}
+
+// status is to get the status set by handlers, services without one are serving if registered, caller must hold the mutex
+func (h *Health) status(name string) service.HealthStatus {
+	if status, ok := h.statuses[name]; ok {
+		return status
+	}
+	if name == "" {
+		return service.Serving
+	}
+	if _, ok := h.server.Services.Load(name); ok {
+		return service.Serving
+	}
+	return service.ServiceUnknown
+}
+
+func (h *Health) setStatus(name string, status service.HealthStatus) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if current, ok := h.statuses[name]; ok && current == status {
+		return
+	}
+	h.statuses[name] = status
+
+	close(h.changed)
+	h.changed = make(chan struct{})
+}
+
+// SetServingStatus is to set the status of the service, or of the whole server if service is empty
+func (s *Server) SetServingStatus(service string, status service.HealthStatus) {
+	s.health.setStatus(service, status)
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"context"
+)
+
+// Handler is to handle a call whose args have been read, filling its reply
+type Handler func(ctx context.Context, call *Call) error
+
+// Interceptor is to run around the handler of every call, e.g. to check peer identity, and calls handler to go on
+type Interceptor func(ctx context.Context, call *Call, handler Handler) error
+
+// Use is to append interceptors, the first used runs outermost
+func (s *Server) Use(interceptors ...Interceptor) {
+	s.muForInterceptors.Lock()
+	defer s.muForInterceptors.Unlock()
+
+	old, _ := s.interceptors.Load().([]Interceptor)
+	chain := make([]Interceptor, 0, len(old)+len(interceptors))
+	chain = append(chain, old...)
+	chain = append(chain, interceptors...)
+	s.interceptors.Store(chain)
+}
+
+// handle is to authorize the call and call the method through interceptors
+func (s *Server) handle(ctx context.Context, call *Call) error {
+	ctx, err := s.authorize(ctx, call)
+	if err != nil {
+		return err
+	}
+
+	handler := func(ctx context.Context, call *Call) error {
+		return call.Service.CallMethod(ctx, call.RpcMethod, call.Args, call.Reply)
+	}
+
+	interceptors, _ := s.interceptors.Load().([]Interceptor)
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], handler
+		handler = func(ctx context.Context, call *Call) error {
+			return interceptor(ctx, call, next)
+		}
+	}
+	return handler(ctx, call)
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+	"gingle-rpc/codec"
+	"gingle-rpc/status"
+	"reflect"
+	"time"
+)
+
+const (
+	invokeCodecType = "in-process"
+	invokePeerAddr  = "in-process"
+)
+
+// Invoke is to call service method in process like a call received from the peer carried by ctx, header carries
+// service method, authorization and traceparent, args and reply must be of the method's types, reply is nil for one way methods
+func (s *Server) Invoke(ctx context.Context, header *codec.Header, args, reply interface{}) (err error) {
+	peer, ok := PeerFromContext(ctx)
+	if !ok {
+		peer = &Peer{Addr: invokePeerAddr}
+		ctx = ContextWithPeer(ctx, peer)
+	}
+
+	h := *header
+	call := &Call{Header: &h, startAt: time.Now()}
+	defer func() {
+		call.Header.SetError(err)
+		s.logCall(call, &codec.Option{CodecType: invokeCodecType}, &countingConn{peer: peer}, 0)
+	}()
+
+	call.Service, call.RpcMethod, err = s.RetrieveService(h.ServiceMethod)
+	if err != nil {
+		return err
+	}
+	if call.RpcMethod.ServerStreaming {
+		return status.Errorf(status.Unimplemented, "server: service.method %s streams cannot be invoked", h.ServiceMethod)
+	}
+
+	call.Args = reflect.ValueOf(args)
+	if !call.Args.IsValid() || call.Args.Type() != call.RpcMethod.ArgsType {
+		return status.Errorf(status.InvalidArgument, "server: service.method %s args type %T not correct, expected %s",
+			h.ServiceMethod, args, call.RpcMethod.ArgsType)
+	}
+	if !call.RpcMethod.OneWay {
+		call.Reply = reflect.ValueOf(reply)
+		if !call.Reply.IsValid() || call.Reply.Type() != call.RpcMethod.ReplyType || call.Reply.IsNil() {
+			return status.Errorf(status.InvalidArgument, "server: service.method %s reply type %T not correct, expected %s",
+				h.ServiceMethod, reply, call.RpcMethod.ReplyType)
+		}
+	}
+	call.RpcMethod.RecordRequest(0)
+
+	ctx, span := s.startSpan(ctx, call, peer.Addr)
+	err = s.handle(ctx, call)
+	finishCall(call, span, err)
+	call.RpcMethod.RecordResponse(0)
+	return err
+}
@@ -0,0 +1,265 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"gingle-rpc/codec"
+	"gingle-rpc/logger"
+	"gingle-rpc/status"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+)
+
+const (
+	jsonrpcVersion   = "2.0"
+	jsonrpcCodecType = "application/json-rpc"
+
+	defaultMaxJSONRPCBytes = 4 << 20
+)
+
+// Error codes of json-rpc 2.0, errors returned by handlers are server errors carrying status code and details in data
+const (
+	JSONRPCParseError     = -32700
+	JSONRPCInvalidRequest = -32600
+	JSONRPCMethodNotFound = -32601
+	JSONRPCInvalidParams  = -32602
+	JSONRPCInternalError  = -32603
+	JSONRPCServerError    = -32000
+)
+
+// JSONRPCRequest includes version, method, params and id of a json-rpc 2.0 request, which is a notification without id
+type JSONRPCRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"`
+}
+
+// JSONRPCResponse includes version, result or error and id of a json-rpc 2.0 response
+type JSONRPCResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *JSONRPCError   `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// JSONRPCError includes code, message and data of a json-rpc 2.0 error
+type JSONRPCError struct {
+	Code    int               `json:"code"`
+	Message string            `json:"message"`
+	Data    *JSONRPCErrorData `json:"data,omitempty"`
+}
+
+// JSONRPCErrorData includes status code name and details of an error returned by handler
+type JSONRPCErrorData struct {
+	Code    string            `json:"code"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// JSONRPCServer includes server, whose services are called by json-rpc 2.0 requests of plain http post
+type JSONRPCServer struct {
+	*Server
+}
+
+// ServeHTTP is to serve a single or batch json-rpc 2.0 request, the http authorization and traceparent headers
+// apply to all calls, and nothing is written back if all calls are notifications
+func (s *JSONRPCServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "405 Must Post", http.StatusMethodNotAllowed)
+		return
+	}
+
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, defaultMaxJSONRPCBytes))
+	if err != nil {
+		http.Error(w, "413 Request Too Large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	peer := &Peer{Addr: r.RemoteAddr, TLS: r.TLS}
+	ctx := ContextWithPeer(r.Context(), peer)
+
+	var response interface{}
+	data = bytes.TrimSpace(data)
+	switch {
+	case len(data) > 0 && data[0] == '[':
+		var raws []json.RawMessage
+		if err := json.Unmarshal(data, &raws); err != nil {
+			response = newJSONRPCErrorResponse(nil, JSONRPCParseError, "Parse error: "+err.Error())
+			break
+		}
+		if len(raws) == 0 {
+			response = newJSONRPCErrorResponse(nil, JSONRPCInvalidRequest, "Invalid Request: empty batch")
+			break
+		}
+		if n := s.getMaxBatchSize(); len(raws) > n {
+			response = newJSONRPCErrorResponse(nil, JSONRPCInvalidRequest, "Invalid Request: batch exceeds the limit of calls")
+			break
+		}
+		if responses := s.callBatch(ctx, r, peer, raws); len(responses) > 0 {
+			response = responses
+		}
+	case json.Valid(data):
+		if res := s.call(ctx, r, peer, data); res != nil {
+			response = res
+		}
+	default:
+		response = newJSONRPCErrorResponse(nil, JSONRPCParseError, "Parse error")
+	}
+
+	if response == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		s.getLogger().Log(logger.Error, "jsonrpc: failed to write response", logger.Peer(peer.Addr), logger.Err(err))
+	}
+}
+
+// callBatch is to call requests of batch in parallel, and return responses of those not notifications
+func (s *JSONRPCServer) callBatch(ctx context.Context, r *http.Request, peer *Peer, raws []json.RawMessage) []*JSONRPCResponse {
+	results := make([]*JSONRPCResponse, len(raws))
+	wg := new(sync.WaitGroup)
+	for i := range raws {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = s.call(ctx, r, peer, raws[i])
+		}(i)
+	}
+	wg.Wait()
+
+	responses := make([]*JSONRPCResponse, 0, len(results))
+	for _, response := range results {
+		if response != nil {
+			responses = append(responses, response)
+		}
+	}
+	return responses
+}
+
+// call is to call the method of request like a call of its own, and return its response, nil for notification
+func (s *JSONRPCServer) call(ctx context.Context, r *http.Request, peer *Peer, raw json.RawMessage) *JSONRPCResponse {
+	var request JSONRPCRequest
+	if err := json.Unmarshal(raw, &request); err != nil || request.JSONRPC != jsonrpcVersion || request.Method == "" {
+		return newJSONRPCErrorResponse(request.ID, JSONRPCInvalidRequest, "Invalid Request")
+	}
+
+	call := &Call{
+		Header: &codec.Header{
+			ServiceMethod: request.Method,
+			Traceparent:   r.Header.Get("Traceparent"),
+			Authorization: r.Header.Get("Authorization"),
+		},
+		startAt:      time.Now(),
+		requestBytes: uint64(len(raw)),
+	}
+	response, err := s.dispatch(ctx, peer, call, &request)
+	call.Header.SetError(err)
+
+	var responseBytes uint64
+	if request.ID == nil {
+		response = nil
+	} else {
+		response.ID = request.ID
+		responseBytes = uint64(len(response.Result))
+	}
+	if call.RpcMethod != nil {
+		call.RpcMethod.RecordRequest(call.requestBytes)
+		call.RpcMethod.RecordResponse(responseBytes)
+	}
+	s.logCall(call, &codec.Option{CodecType: jsonrpcCodecType}, &countingConn{peer: peer}, responseBytes)
+	return response
+}
+
+// dispatch is to decode params into args of the method and call it, and return its response and error
+func (s *JSONRPCServer) dispatch(ctx context.Context, peer *Peer, call *Call, request *JSONRPCRequest) (*JSONRPCResponse, error) {
+	var err error
+	call.Service, call.RpcMethod, err = s.RetrieveService(request.Method)
+	if err != nil {
+		return newJSONRPCErrorResponse(nil, JSONRPCMethodNotFound, "Method not found: "+err.Error()), err
+	}
+	if call.RpcMethod.ServerStreaming {
+		err = status.Errorf(status.Unimplemented, "server: service.method %s streams are not supported over json-rpc", request.Method)
+		return newJSONRPCErrorResponse(nil, JSONRPCMethodNotFound, "Method not found: "+err.Error()), err
+	}
+
+	call.Args = call.RpcMethod.NewArgsValue()
+	call.Reply = call.RpcMethod.NewReplyValue()
+	argsInterface := call.Args.Interface()
+	if call.Args.Type().Kind() != reflect.Ptr {
+		argsInterface = call.Args.Addr().Interface()
+	}
+	if err := decodeJSONRPCParams(request.Params, call.RpcMethod.ArgsType, argsInterface); err != nil {
+		return newJSONRPCErrorResponse(nil, JSONRPCInvalidParams, "Invalid params: "+err.Error()),
+			status.Errorf(status.InvalidArgument, "server: failed to decode json-rpc params, err: %v", err)
+	}
+
+	ctx, span := s.startSpan(ctx, call, peer.Addr)
+	err = s.handle(ctx, call)
+	finishCall(call, span, err)
+	if err != nil {
+		st := status.FromError(err)
+		return &JSONRPCResponse{
+			JSONRPC: jsonrpcVersion,
+			Error: &JSONRPCError{
+				Code:    JSONRPCServerError,
+				Message: st.Message,
+				Data:    &JSONRPCErrorData{Code: st.Code.String(), Details: st.Details},
+			},
+		}, err
+	}
+
+	var reply interface{}
+	if call.Reply.IsValid() {
+		reply = call.Reply.Interface()
+	}
+	result, err := json.Marshal(reply)
+	if err != nil {
+		return newJSONRPCErrorResponse(nil, JSONRPCInternalError, "Internal error: "+err.Error()),
+			status.Errorf(status.Internal, "server: failed to encode json-rpc result, err: %v", err)
+	}
+	return &JSONRPCResponse{JSONRPC: jsonrpcVersion, Result: result}, nil
+}
+
+// decodeJSONRPCParams is to decode by-name params into args, and by-position params of one element into args
+// unless args is a slice or an array, missing params leave args zero
+func decodeJSONRPCParams(params json.RawMessage, argsType reflect.Type, args interface{}) error {
+	params = bytes.TrimSpace(params)
+	if len(params) == 0 || bytes.Equal(params, []byte("null")) {
+		return nil
+	}
+
+	kind := argsType.Kind()
+	if argsType.Kind() == reflect.Ptr {
+		kind = argsType.Elem().Kind()
+	}
+	if params[0] == '[' && kind != reflect.Slice && kind != reflect.Array {
+		var positional []json.RawMessage
+		if err := json.Unmarshal(params, &positional); err != nil {
+			return err
+		}
+		if len(positional) != 1 {
+			return fmt.Errorf("%d params by position, expected 1", len(positional))
+		}
+		params = positional[0]
+	}
+	return json.Unmarshal(params, args)
+}
+
+func newJSONRPCErrorResponse(id json.RawMessage, code int, message string) *JSONRPCResponse {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	return &JSONRPCResponse{
+		JSONRPC: jsonrpcVersion,
+		Error:   &JSONRPCError{Code: code, Message: message},
+		ID:      id,
+	}
+}
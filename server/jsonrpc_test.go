@@ -0,0 +1,173 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// postJSONRPC is to post body to json-rpc server, and return http status and the body of response
+func postJSONRPC(t *testing.T, url, body string) (int, []byte) {
+	res, err := http.Post(url, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+
+	var data json.RawMessage
+	if res.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(res.Body).Decode(&data); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+	}
+	return res.StatusCode, data
+}
+
+func startTestJSONRPCServer(t *testing.T, services ...interface{}) string {
+	s, _ := startTestServer(t, services...)
+	ts := httptest.NewServer(&JSONRPCServer{Server: s})
+	t.Cleanup(ts.Close)
+	return ts.URL
+}
+
+func TestJSONRPCErrors(t *testing.T) {
+	url := startTestJSONRPCServer(t, &Recorder{}, Slow{}, &Streamer{})
+
+	tests := []struct {
+		name     string
+		body     string
+		wantCode int
+		wantData string
+		wantID   string
+	}{
+		{"parse error", `{"jsonrpc": "2.0", "method"`, JSONRPCParseError, "", "null"},
+		{"parse error of batch", `[{"jsonrpc": "2.0"},`, JSONRPCParseError, "", "null"},
+		{"empty batch", `[]`, JSONRPCInvalidRequest, "", "null"},
+		{"wrong version", `{"jsonrpc": "1.0", "method": "Recorder.Echo", "params": ["a"], "id": 1}`, JSONRPCInvalidRequest, "", "1"},
+		{"without method", `{"jsonrpc": "2.0", "id": 1}`, JSONRPCInvalidRequest, "", "1"},
+		{"unknown method", `{"jsonrpc": "2.0", "method": "Recorder.Unknown", "id": 1}`, JSONRPCMethodNotFound, "", "1"},
+		{"unknown service", `{"jsonrpc": "2.0", "method": "Unknown.Echo", "id": 1}`, JSONRPCMethodNotFound, "", "1"},
+		{"stream method", `{"jsonrpc": "2.0", "method": "Streamer.Count", "params": [1], "id": 1}`, JSONRPCMethodNotFound, "", "1"},
+		{"params of wrong type", `{"jsonrpc": "2.0", "method": "Recorder.Echo", "params": {"a": 1}, "id": 1}`, JSONRPCInvalidParams, "", "1"},
+		{"too many params by position", `{"jsonrpc": "2.0", "method": "Recorder.Echo", "params": ["a", "b"], "id": "x"}`, JSONRPCInvalidParams, "", `"x"`},
+		{"handler error", `{"jsonrpc": "2.0", "method": "Slow.Fail", "params": [1], "id": 1}`, JSONRPCServerError, "Unknown", "1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, data := postJSONRPC(t, url, tt.body)
+			if code != http.StatusOK {
+				t.Fatalf("http status = %d, want 200", code)
+			}
+			var response JSONRPCResponse
+			if err := json.Unmarshal(data, &response); err != nil {
+				t.Fatalf("decode response %s: %v", data, err)
+			}
+			if response.Error == nil || response.Error.Code != tt.wantCode || response.Result != nil {
+				t.Fatalf("response = %s, want error %d", data, tt.wantCode)
+			}
+			if tt.wantData != "" && (response.Error.Data == nil || response.Error.Data.Code != tt.wantData) {
+				t.Fatalf("error data = %+v, want code %s", response.Error.Data, tt.wantData)
+			}
+			if string(response.ID) != tt.wantID {
+				t.Fatalf("id = %s, want %s", response.ID, tt.wantID)
+			}
+		})
+	}
+}
+
+func TestJSONRPCNotification(t *testing.T) {
+	recorder := &Recorder{}
+	url := startTestJSONRPCServer(t, recorder, Slow{})
+
+	tests := []struct {
+		name    string
+		body    string
+		wantIDs []int
+	}{
+		{"single", `{"jsonrpc": "2.0", "method": "Recorder.Record", "params": {"ID": 1}}`, []int{1}},
+		{"batch", `[{"jsonrpc": "2.0", "method": "Recorder.Record", "params": {"ID": 2}}, {"jsonrpc": "2.0", "method": "Slow.Fail", "params": [1]}]`, []int{1, 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// notifications are called before the response, but nothing is written back, errors included
+			code, data := postJSONRPC(t, url, tt.body)
+			if code != http.StatusNoContent || data != nil {
+				t.Fatalf("http status = %d, body %s, want 204 without body", code, data)
+			}
+			recorder.mu.Lock()
+			ids := append([]int(nil), recorder.ids...)
+			recorder.mu.Unlock()
+			if !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Fatalf("ids = %v, want %v", ids, tt.wantIDs)
+			}
+		})
+	}
+}
+
+func TestJSONRPCBatch(t *testing.T) {
+	url := startTestJSONRPCServer(t, &Recorder{}, Slow{})
+
+	body := `[
+		{"jsonrpc": "2.0", "method": "Recorder.Echo", "params": ["a"], "id": 1},
+		{"jsonrpc": "2.0", "method": "Recorder.Record", "params": {"ID": 7}},
+		{"jsonrpc": "2.0", "method": "Recorder.Echo", "params": "b", "id": "two"},
+		{"jsonrpc": "2.0", "method": "Recorder.Unknown", "id": 3},
+		1
+	]`
+	code, data := postJSONRPC(t, url, body)
+	if code != http.StatusOK {
+		t.Fatalf("http status = %d, want 200", code)
+	}
+	var responses []JSONRPCResponse
+	if err := json.Unmarshal(data, &responses); err != nil {
+		t.Fatalf("decode responses %s: %v", data, err)
+	}
+
+	// the notification is left out, and the others are answered in the order of requests
+	want := []struct {
+		id     string
+		result string
+		code   int
+	}{
+		{"1", `"a"`, 0},
+		{`"two"`, `"b"`, 0},
+		{"3", "", JSONRPCMethodNotFound},
+		{"null", "", JSONRPCInvalidRequest},
+	}
+	if len(responses) != len(want) {
+		t.Fatalf("responses = %s, want %d", data, len(want))
+	}
+	for i, response := range responses {
+		var code int
+		if response.Error != nil {
+			code = response.Error.Code
+		}
+		if string(response.ID) != want[i].id || string(response.Result) != want[i].result || code != want[i].code {
+			t.Fatalf("response %d = id %s, result %s, code %d, want id %s, result %s, code %d",
+				i, response.ID, response.Result, code, want[i].id, want[i].result, want[i].code)
+		}
+	}
+}
+
+func TestJSONRPCBatchTooLarge(t *testing.T) {
+	s, _ := startTestServer(t, &Recorder{})
+	s.SetMaxBatchSize(1)
+	ts := httptest.NewServer(&JSONRPCServer{Server: s})
+	defer ts.Close()
+
+	code, data := postJSONRPC(t, ts.URL, `[{"jsonrpc": "2.0", "method": "Recorder.Echo", "params": ["a"], "id": 1}, {"jsonrpc": "2.0", "method": "Recorder.Echo", "params": ["b"], "id": 2}]`)
+	var response JSONRPCResponse
+	if err := json.Unmarshal(data, &response); code != http.StatusOK || err != nil {
+		t.Fatalf("http status = %d, response %s", code, data)
+	}
+	if response.Error == nil || response.Error.Code != JSONRPCInvalidRequest {
+		t.Fatalf("response = %s, want error %d", data, JSONRPCInvalidRequest)
+	}
+}
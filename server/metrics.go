@@ -0,0 +1,93 @@
+package server
+
+import (
+	"gingle-rpc/logger"
+	"gingle-rpc/metrics"
+	"gingle-rpc/service"
+	"io"
+	"net/http"
+	"sort"
+	"sync/atomic"
+)
+
+// MetricsServer includes server
+type MetricsServer struct {
+	*Server
+}
+
+// ServeHTTP is to write server metrics in prometheus text exposition format
+func (s *MetricsServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", metrics.ContentType)
+	if _, err := s.WriteMetrics(w); err != nil {
+		logger.Default().Log(logger.Error, "metrics: failed to serve http", logger.Err(err))
+	}
+}
+
+// WriteMetrics is to write server metrics in prometheus text exposition format
+func (s *Server) WriteMetrics(w io.Writer) (int64, error) {
+	mw := metrics.NewWriter(w)
+
+	mw.Header("gingle_server_active_connections", "Number of connections being served.", "gauge")
+	mw.Sample("gingle_server_active_connections", float64(s.ActiveConns()))
+	mw.Header("gingle_server_connections_total", "Number of connections accepted.", "counter")
+	mw.Sample("gingle_server_connections_total", float64(atomic.LoadUint64(&s.acceptedConns)))
+	mw.Header("gingle_server_codec_errors_total", "Number of codec errors by operation.", "counter")
+	mw.CounterVec("gingle_server_codec_errors_total", "op", &s.codecErrors)
+
+	type methodStats struct {
+		service string
+		method  string
+		stats   *service.MethodStats
+	}
+	var all []methodStats
+	s.Services.Range(func(nameInterface, svcInterface interface{}) bool {
+		for methodName, rpcMethod := range svcInterface.(*service.Service).RpcMethods {
+			all = append(all, methodStats{
+				service: nameInterface.(string),
+				method:  methodName,
+				stats:   rpcMethod.Stats(),
+			})
+		}
+		return true
+	})
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].service != all[j].service {
+			return all[i].service < all[j].service
+		}
+		return all[i].method < all[j].method
+	})
+
+	mw.Header("gingle_server_calls_total", "Number of calls handled by method.", "counter")
+	for _, m := range all {
+		mw.Sample("gingle_server_calls_total", float64(m.stats.CallTimes), "service", m.service, "method", m.method)
+	}
+	mw.Header("gingle_server_errors_total", "Number of failed calls by method and error kind.", "counter")
+	for _, m := range all {
+		kinds := make([]string, 0, len(m.stats.ErrorKinds))
+		for kind := range m.stats.ErrorKinds {
+			kinds = append(kinds, kind)
+		}
+		sort.Strings(kinds)
+		for _, kind := range kinds {
+			mw.Sample("gingle_server_errors_total", float64(m.stats.ErrorKinds[kind]), "service", m.service, "method", m.method, "kind", kind)
+		}
+	}
+	mw.Header("gingle_server_in_flight_calls", "Number of calls being handled by method.", "gauge")
+	for _, m := range all {
+		mw.Sample("gingle_server_in_flight_calls", float64(m.stats.InFlight), "service", m.service, "method", m.method)
+	}
+	mw.Header("gingle_server_request_bytes_total", "Size of requests read by method.", "counter")
+	for _, m := range all {
+		mw.Sample("gingle_server_request_bytes_total", float64(m.stats.RequestBytes), "service", m.service, "method", m.method)
+	}
+	mw.Header("gingle_server_response_bytes_total", "Size of responses written by method.", "counter")
+	for _, m := range all {
+		mw.Sample("gingle_server_response_bytes_total", float64(m.stats.ResponseBytes), "service", m.service, "method", m.method)
+	}
+	mw.Header("gingle_server_latency_seconds", "Latency of handling calls by method.", "histogram")
+	for _, m := range all {
+		mw.Histogram("gingle_server_latency_seconds", m.stats.Latency, "service", m.service, "method", m.method)
+	}
+
+	return mw.Result()
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+)
+
+// Peer includes remote address and tls connection state of the connection a call comes from
+type Peer struct {
+	Addr string
+	TLS  *tls.ConnectionState // nil for plaintext connection
+}
+
+// Certificate is to get the verified leaf client certificate of mutual tls, nil if not verified
+func (p *Peer) Certificate() *x509.Certificate {
+	if p.TLS == nil || len(p.TLS.VerifiedChains) == 0 || len(p.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return p.TLS.VerifiedChains[0][0]
+}
+
+// Identity is to get common name of the verified client certificate, or its first dns name, uri or email if no common name,
+// empty if not verified
+func (p *Peer) Identity() string {
+	cert := p.Certificate()
+	switch {
+	case cert == nil:
+		return ""
+	case cert.Subject.CommonName != "":
+		return cert.Subject.CommonName
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0]
+	case len(cert.URIs) > 0:
+		return cert.URIs[0].String()
+	case len(cert.EmailAddresses) > 0:
+		return cert.EmailAddresses[0]
+	default:
+		return ""
+	}
+}
+
+type peerKey struct{}
+
+// ContextWithPeer is to carry peer of a call
+func ContextWithPeer(ctx context.Context, peer *Peer) context.Context {
+	return context.WithValue(ctx, peerKey{}, peer)
+}
+
+// PeerFromContext is to get peer of the call carried by ctx, which is given to handlers taking context and interceptors
+func PeerFromContext(ctx context.Context) (*Peer, bool) {
+	peer, ok := ctx.Value(peerKey{}).(*Peer)
+	return peer, ok
+}
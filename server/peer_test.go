@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"gingle-rpc/auth"
+	"gingle-rpc/client"
+	"gingle-rpc/status"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// PeerEcho is the service of tests replying the identity of the peer calling it
+type PeerEcho struct{}
+
+func (PeerEcho) Identity(ctx context.Context, args string, reply *string) error {
+	if peer, ok := PeerFromContext(ctx); ok {
+		*reply = peer.Identity()
+	}
+	return nil
+}
+
+// testCA includes self-signed ca certificate and its key issuing certificates of tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "gingle test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create ca certificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+// issue is to issue certificate of common name for server or client usage, servers are valid for 127.0.0.1
+func (ca *testCA) issue(t *testing.T, commonName string, usage x509.ExtKeyUsage) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestMutualTLSIdentity(t *testing.T) {
+	ca, other := newTestCA(t), newTestCA(t)
+
+	s := NewServer()
+	if err := s.RegisterService(PeerEcho{}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	s.SetAuth(auth.MTLSAuthenticator{}, auth.NewPolicy(map[string][]string{"alice": {"PeerEcho.*"}}))
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer func() {
+		_ = lis.Close()
+	}()
+	go s.AcceptTLS(lis, &tls.Config{
+		Certificates: []tls.Certificate{ca.issue(t, "server", x509.ExtKeyUsageServerAuth)},
+		ClientCAs:    ca.pool,
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+	})
+
+	tests := []struct {
+		name         string
+		certificates []tls.Certificate
+		wantIdentity string
+		wantCode     status.Code
+	}{
+		{"allowed client", []tls.Certificate{ca.issue(t, "alice", x509.ExtKeyUsageClientAuth)}, "alice", status.OK},
+		{"denied client", []tls.Certificate{ca.issue(t, "bob", x509.ExtKeyUsageClientAuth)}, "", status.PermissionDenied},
+		{"without certificate", nil, "", status.Unauthenticated},
+		{"certificate of unknown ca", []tls.Certificate{other.issue(t, "alice", x509.ExtKeyUsageClientAuth)}, "", status.Unavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := client.DialTLS("tcp", lis.Addr().String(), &tls.Config{RootCAs: ca.pool, Certificates: tt.certificates})
+			if err != nil {
+				if tt.wantCode != status.Unavailable {
+					t.Fatalf("dial: %v", err)
+				}
+				return
+			}
+			defer func() {
+				_ = c.Close()
+			}()
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			var identity string
+			err = c.Call(ctx, "PeerEcho.Identity", "", &identity)
+			if status.CodeOf(err) != tt.wantCode || identity != tt.wantIdentity {
+				t.Fatalf("identity = %q, err: %v, want %q of %s", identity, err, tt.wantIdentity, tt.wantCode)
+			}
+		})
+	}
+}
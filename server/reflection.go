@@ -0,0 +1,53 @@
+package server
+
+import (
+	"gingle-rpc/service"
+	"gingle-rpc/status"
+	"sort"
+)
+
+// ReflectionServiceName is the name the built-in reflection service is registered under, namespaced to leave "Reflection" to users
+const ReflectionServiceName = "gingle.Reflection"
+
+// Reflection is the built-in service describing services registered on server
+type Reflection struct {
+	server *Server
+}
+
+// ReflectionArgs includes service name
+type ReflectionArgs struct {
+	Service string
+}
+
+// ListServices is to list names of all services
+func (r *Reflection) ListServices(args ReflectionArgs, reply *[]string) error {
+	r.server.Services.Range(func(nameInterface, _ interface{}) bool {
+		*reply = append(*reply, nameInterface.(string))
+		return true
+	})
+	sort.Strings(*reply)
+	return nil
+}
+
+// DescribeService is to describe the named service, its methods and types
+func (r *Reflection) DescribeService(args ReflectionArgs, reply *service.ServiceDescriptor) error {
+	svcInterface, ok := r.server.Services.Load(args.Service)
+	if !ok {
+		return status.Errorf(status.NotFound, "server: service %s not found", args.Service)
+	}
+
+	*reply = *svcInterface.(*service.Service).Describe()
+	return nil
+}
+
+// DescribeServices is to describe all services, their methods and types
+func (r *Reflection) DescribeServices(args ReflectionArgs, reply *[]service.ServiceDescriptor) error {
+	r.server.Services.Range(func(_, svcInterface interface{}) bool {
+		*reply = append(*reply, *svcInterface.(*service.Service).Describe())
+		return true
+	})
+	sort.Slice(*reply, func(i, j int) bool {
+		return (*reply)[i].Name < (*reply)[j].Name
+	})
+	return nil
+}
@@ -1,13 +1,18 @@
 package server
 
 import (
+	"fmt"
+	"gingle-rpc/logger"
 	"net/http"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 )
 
+var healthCheckClient = &http.Client{Timeout: defaultHealthCheckTimeout}
+
 // RegistryServerItem includes address, start at and end at
 type RegistryServerItem struct {
 	Addr    string
@@ -15,99 +20,253 @@ type RegistryServerItem struct {
 	EndAt   time.Time
 }
 
-// RegistryServer includes servers, timeout and mutex
+// RegistryServer includes servers, revision, change notifier, store, cluster, timeouts and mutex
 type RegistryServer struct {
 	servers map[string]*RegistryServerItem
 
-	timeout time.Duration
-	mu      sync.Mutex
+	revision uint64
+	changed  chan struct{}
+
+	store        *registryStore
+	stopSnapshot chan struct{}
+
+	cluster *registryCluster
+
+	timeout      time.Duration
+	watchTimeout time.Duration
+	mu           sync.Mutex
 }
 
 // NewRegistryServer is to create registry server
 func NewRegistryServer(timeout time.Duration) *RegistryServer {
 	return &RegistryServer{
-		servers: make(map[string]*RegistryServerItem),
-		timeout: timeout,
+		servers:      make(map[string]*RegistryServerItem),
+		revision:     1,
+		changed:      make(chan struct{}),
+		timeout:      timeout,
+		watchTimeout: defaultWatchTimeout,
 	}
 }
 
-func (s *RegistryServer) registerServer(addr string) {
+// notifyChanged is to bump revision and wake up all watchers, caller must hold the mutex
+func (s *RegistryServer) notifyChanged() {
+	s.revision++
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func (s *RegistryServer) registerServer(addr string) uint64 {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	return s.registerServerLocked(addr)
+}
+
+// registerServerLocked is to register or refresh server, and return the sequence of the entry replicated for a new
+// server, 0 if there is none, caller must hold the mutex
+func (s *RegistryServer) registerServerLocked(addr string) uint64 {
 	server, ok := s.servers[addr]
 	if ok {
 		server.StartAt = time.Now()
-	} else {
-		s.servers[addr] = &RegistryServerItem{
-			Addr:    addr,
-			StartAt: time.Now(),
-		}
+		return 0
+	}
+
+	s.servers[addr] = &RegistryServerItem{
+		Addr:    addr,
+		StartAt: time.Now(),
+	}
+	if s.store != nil {
+		s.store.append(registryOpRegister, addr)
 	}
+	s.notifyChanged()
+	return s.replicateEntry(registryOpRegister, addr)
 }
 
-func (s *RegistryServer) exploreServers() []string {
+func (s *RegistryServer) exploreServers() ([]string, uint64) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	var addrs []string
+	var expired bool
 	for addr, item := range s.servers {
-		if s.timeout == 0 || item.StartAt.Add(s.timeout).After(time.Now()) { // not limit or not timeout, server is alive
+		if s.timeout == 0 || !s.expiring() || item.StartAt.Add(s.timeout).After(time.Now()) { // not limit or not timeout, server is alive
 			addrs = append(addrs, addr)
 		} else { // with limit and already timeout, server is not alive
 			delete(s.servers, addr)
+			if s.store != nil {
+				s.store.append(registryOpExpire, addr)
+			}
+			s.replicateEntry(registryOpExpire, addr)
+			expired = true
 		}
 	}
+	if expired {
+		s.notifyChanged()
+	}
 
 	sort.Strings(addrs)
-	return addrs
+	return addrs, s.revision
+}
+
+// watchServers is to block until revision differs from the given one or watch timeout, then explore servers
+func (s *RegistryServer) watchServers(r *http.Request, revision uint64) ([]string, uint64) {
+	deadline := time.After(s.watchTimeout)
+	for {
+		addrs, current := s.exploreServers()
+		if current != revision {
+			return addrs, current
+		}
+
+		s.mu.Lock()
+		changed := s.changed
+		expireAfter := s.nextExpiration()
+		s.mu.Unlock()
+
+		var expire <-chan time.Time
+		var timer *time.Timer
+		if expireAfter > 0 {
+			timer = time.NewTimer(expireAfter)
+			expire = timer.C
+		}
+
+		var done bool
+		select {
+		case <-changed:
+		case <-expire:
+		case <-deadline:
+			done = true
+		case <-r.Context().Done():
+			done = true
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if done {
+			return addrs, current
+		}
+	}
+}
+
+// nextExpiration is to get the duration until the earliest server expiration, caller must hold the mutex
+func (s *RegistryServer) nextExpiration() time.Duration {
+	if s.timeout == 0 || len(s.servers) == 0 || !s.expiring() {
+		return 0
+	}
+
+	var earliest time.Time
+	for _, item := range s.servers {
+		if earliest.IsZero() || item.StartAt.Before(earliest) {
+			earliest = item.StartAt
+		}
+	}
+
+	d := time.Until(earliest.Add(s.timeout))
+	if d <= 0 {
+		d = time.Millisecond
+	}
+	return d
 }
 
-// ServeHTTP is to explore servers or register server
+// ServeHTTP is to explore servers, watch servers or register server
 func (s *RegistryServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case "GET":
-		// explore servers
-		servers := strings.Join(s.exploreServers(), ",")
-		w.Header().Set("X-Gingle-Rpc-Servers", servers)
+		if r.Header.Get(registryReplicaHeader) != "" {
+			// probed by peer electing primary
+			if !s.authenticatePeer(r) {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			s.serveProbe(w)
+			return
+		}
+
+		var addrs []string
+		var revision uint64
+		if rev := r.Header.Get("X-Gingle-Rpc-Revision"); rev != "" {
+			// watch servers
+			known, err := strconv.ParseUint(rev, 10, 64)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			addrs, revision = s.watchServers(r, known)
+		} else {
+			// explore servers
+			addrs, revision = s.exploreServers()
+		}
+		w.Header().Set("X-Gingle-Rpc-Servers", strings.Join(addrs, ","))
+		w.Header().Set("X-Gingle-Rpc-Revision", strconv.FormatUint(revision, 10))
 	case "POST":
+		if r.Header.Get(registryReplicaHeader) != "" {
+			// shipped by primary
+			if !s.authenticatePeer(r) {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			s.serveAppend(w, r)
+			return
+		}
+
 		// register server
 		addr := r.Header.Get("X-Gingle-Rpc-Server")
 		if addr == "" {
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
-		s.registerServer(addr)
+		s.serveRegister(w, r, addr)
 	default:
 		w.WriteHeader(http.StatusMethodNotAllowed)
 	}
 }
 
-// HealthCheckOnce is to do registry center health check once
+// HealthCheckOnce is to do registry center health check once, serverAddr may be comma separated registry addresses which are tried in order
 func HealthCheckOnce(serverAddr, clientAddr string) error {
-	httpClient := &http.Client{}
-	req, _ := http.NewRequest("POST", serverAddr, nil)
-	req.Header.Set("X-Gingle-Rpc-Server", clientAddr)
+	err := fmt.Errorf("registry: failed to health check, err: no registry address")
+	for _, addr := range splitRegistryAddrs(serverAddr) {
+		if err = healthCheck(addr, clientAddr); err == nil {
+			return nil
+		}
+	}
+	return err
+}
 
-	if _, err := httpClient.Do(req); err != nil {
+func healthCheck(registryAddr, clientAddr string) error {
+	req, err := http.NewRequest("POST", registryAddr, nil)
+	if err != nil {
 		return err
 	}
+	req.Header.Set("X-Gingle-Rpc-Server", clientAddr)
+
+	res, err := healthCheckClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("registry: failed to health check, err: %v", err)
+	}
+	_ = res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("registry: failed to health check, err: unexpected status %s", res.Status)
+	}
 	return nil
 }
 
-// HealthCheckPeriodically is to do registry center health check periodically
+// HealthCheckPeriodically is to do registry center health check periodically, a failed health check is logged and
+// retried across all registry addresses at the next period
 func HealthCheckPeriodically(serverAddr, clientAddr string, period time.Duration) {
 	if period == 0 {
 		period = defaultPeriod
 	}
 
-	var err error
-	err = HealthCheckOnce(serverAddr, clientAddr)
+	healthCheckLogged(serverAddr, clientAddr)
 	go func() {
 		t := time.NewTicker(period)
-		for err == nil {
-			<-t.C
-			err = HealthCheckOnce(serverAddr, clientAddr)
+		for range t.C {
+			healthCheckLogged(serverAddr, clientAddr)
 		}
 	}()
 }
+
+func healthCheckLogged(serverAddr, clientAddr string) {
+	if err := HealthCheckOnce(serverAddr, clientAddr); err != nil {
+		logger.Default().Log(logger.Warn, "registry: failed to health check", logger.Peer(serverAddr), logger.Err(err))
+	}
+}
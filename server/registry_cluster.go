@@ -0,0 +1,585 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"gingle-rpc/auth"
+	"gingle-rpc/logger"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	defaultReplicateTimeout = 5 * time.Second
+	defaultHeartbeatPeriod  = time.Second
+	defaultFailoverTimeout  = 5 * time.Second
+
+	// entries beyond are dropped from the log kept by primary, a backup lagging behind them installs a snapshot instead
+	maxReplicaLog = 1024
+
+	registryReplicateMethod = "Registry.Replicate"
+	registryReplicaHeader   = "X-Gingle-Rpc-Replica"
+	registryForwardedHeader = "X-Gingle-Rpc-Forwarded"
+)
+
+var replicateClient = &http.Client{Timeout: defaultReplicateTimeout}
+
+// ReplicationOption includes the ordered registry members including this one, the address of this one among them,
+// credentials signing requests to peers, authenticator of requests from peers for the pseudo method Registry.Replicate,
+// how often primary heartbeats backups and how long backups wait for it before failing over, 0 means the defaults
+type ReplicationOption struct {
+	Members         []string
+	Self            string
+	Credentials     auth.Credentials
+	Authenticator   auth.Authenticator
+	HeartbeatPeriod time.Duration
+	FailoverTimeout time.Duration
+}
+
+// registryReplicaEntry includes sequence, operation and address of one change shipped from primary to backups
+type registryReplicaEntry struct {
+	Seq  uint64
+	Op   string
+	Addr string
+}
+
+// registryAppend includes term and address of primary, the sequence preceding entries and the entries, or the snapshot
+// at the sequence installed instead when the backup is behind the kept log or on an earlier term
+type registryAppend struct {
+	Term     uint64
+	Primary  string
+	Seq      uint64
+	Entries  []registryReplicaEntry
+	Snapshot *RegistrySnapshot
+}
+
+// registryReplicaState includes the highest term seen, term and sequence of the state applied, primary known, and the
+// snapshot of the state when probed by a peer, which replies appends and probes
+type registryReplicaState struct {
+	Term      uint64
+	StateTerm uint64
+	Seq       uint64
+	Primary   string
+	Snapshot  *RegistrySnapshot `json:",omitempty"`
+}
+
+// registryCluster includes replication option, term, primary and when it was heard from, term and sequence of the state
+// applied, and on primary the kept log, sequences acknowledged by backups and notifiers of appends and acknowledgements
+type registryCluster struct {
+	opt *ReplicationOption
+
+	term      uint64
+	primary   string
+	heardAt   time.Time
+	stateTerm uint64
+	seq       uint64
+
+	log        []registryReplicaEntry
+	logStart   uint64
+	acked      map[string]uint64
+	appended   chan struct{}
+	ackChanged chan struct{}
+	stopShip   chan struct{}
+
+	stop chan struct{}
+}
+
+func (c *registryCluster) isPrimary() bool {
+	return c.primary == c.opt.Self
+}
+
+// Replicate is to run registry as one member of a primary-backup cluster.
+//
+// The first member in order reachable with a majority of members becomes primary, taking over the most up to date state
+// among them. Registrations and expirations are applied by primary only, shipped to backups in order and retried until
+// acknowledged, and a registration is replied once a majority of members applied it, so that it survives the failure of
+// primary. Backups forward direct registrations to primary, and fail over when primary is silent for FailoverTimeout.
+// Heartbeats of known servers are not shipped, a new primary gives every server its timeout to heartbeat again instead.
+// Requests from peers are authenticated by opt.Authenticator, without which requests claiming to be replicas are rejected.
+func (s *RegistryServer) Replicate(opt *ReplicationOption) error {
+	if opt.HeartbeatPeriod == 0 {
+		opt.HeartbeatPeriod = defaultHeartbeatPeriod
+	}
+	if opt.FailoverTimeout == 0 {
+		opt.FailoverTimeout = defaultFailoverTimeout
+	}
+	var member bool
+	for _, m := range opt.Members {
+		member = member || m == opt.Self
+	}
+	if !member {
+		return fmt.Errorf("registry: failed to replicate, err: %s is not a member", opt.Self)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cluster != nil {
+		return fmt.Errorf("registry: failed to replicate, err: already replicating")
+	}
+	s.cluster = &registryCluster{
+		opt:        opt,
+		appended:   make(chan struct{}),
+		ackChanged: make(chan struct{}),
+		stop:       make(chan struct{}),
+	}
+	go s.runCluster(s.cluster)
+	return nil
+}
+
+// stopReplicating is to stop the member and its shipping, caller must hold the mutex
+func (s *RegistryServer) stopReplicating() {
+	if s.cluster == nil {
+		return
+	}
+	s.stepDown(s.cluster, s.cluster.term, "")
+	close(s.cluster.stop)
+	s.cluster = nil
+}
+
+// runCluster is to expire servers on primary, so that backups learn expirations without being explored, and to elect
+// primary on backups which never heard from it or stopped hearing from it
+func (s *RegistryServer) runCluster(c *registryCluster) {
+	t := time.NewTicker(c.opt.HeartbeatPeriod)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-t.C:
+		}
+
+		s.mu.Lock()
+		primary := c.isPrimary()
+		silent := c.primary == "" || time.Since(c.heardAt) > c.opt.FailoverTimeout
+		s.mu.Unlock()
+
+		if primary {
+			s.exploreServers()
+		} else if silent {
+			s.elect(c)
+		}
+	}
+}
+
+// elect is to probe members, then follow the primary found, or become primary when this is the first member in order
+// reachable with a majority of members
+func (s *RegistryServer) elect(c *registryCluster) {
+	states := make(map[string]*registryReplicaState)
+	for _, m := range c.opt.Members {
+		if m == c.opt.Self {
+			continue
+		}
+		state, err := probeReplica(m, c.opt.Credentials)
+		if err != nil {
+			continue
+		}
+		states[m] = state
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cluster != c || c.isPrimary() {
+		return
+	}
+	if (len(states)+1)*2 <= len(c.opt.Members) {
+		logger.Default().Log(logger.Warn, "registry: failed to reach a majority of members", logger.Any("reached", len(states)+1))
+		return
+	}
+	for m, state := range states {
+		if state.Primary == m && state.Term >= c.term {
+			s.stepDown(c, state.Term, m)
+			return
+		}
+	}
+	for _, m := range c.opt.Members {
+		if m == c.opt.Self {
+			break
+		}
+		if _, ok := states[m]; ok {
+			// left to the reachable member ahead in order
+			return
+		}
+	}
+	s.promote(c, states)
+}
+
+// promote is to take over the most up to date state among members probed and start shipping to backups in a new term,
+// caller must hold the mutex
+func (s *RegistryServer) promote(c *registryCluster, states map[string]*registryReplicaState) {
+	term, stateTerm, seq := c.term, c.stateTerm, c.seq
+	var latest *RegistrySnapshot
+	for _, state := range states {
+		if state.Term > term {
+			term = state.Term
+		}
+		if state.Snapshot != nil && (state.StateTerm > stateTerm || state.StateTerm == stateTerm && state.Seq > seq) {
+			stateTerm, seq, latest = state.StateTerm, state.Seq, state.Snapshot
+		}
+	}
+	if latest == nil {
+		latest = s.dump()
+	}
+	// every server is given its timeout to heartbeat the new primary
+	s.restore(latest, s.timeout)
+	if s.store != nil {
+		if err := s.store.save(s.dump()); err != nil {
+			logger.Default().Log(logger.Error, "registry: failed to save snapshot", logger.Err(err))
+		}
+	}
+
+	c.term = term + 1
+	c.stateTerm = c.term
+	c.primary = c.opt.Self
+	c.heardAt = time.Now()
+	c.acked = make(map[string]uint64)
+	c.stopShip = make(chan struct{})
+	c.resetLog()
+	for _, m := range c.opt.Members {
+		if m != c.opt.Self {
+			go s.ship(c, m, c.term, c.stopShip)
+		}
+	}
+	logger.Default().Log(logger.Info, "registry: promoted to primary", logger.Any("term", c.term))
+}
+
+// stepDown is to follow primary of term, stopping shipping and waking registrations waiting for acknowledgements if
+// this was primary, caller must hold the mutex
+func (s *RegistryServer) stepDown(c *registryCluster, term uint64, primary string) {
+	if c.isPrimary() && c.stopShip != nil {
+		close(c.stopShip)
+		c.stopShip = nil
+		close(c.ackChanged)
+		c.ackChanged = make(chan struct{})
+	}
+	c.term = term
+	c.primary = primary
+	c.heardAt = time.Now()
+}
+
+// resetLog is to start the log after a state which is not made of entries, so that every backup installs a snapshot
+func (c *registryCluster) resetLog() {
+	c.seq++
+	c.log = nil
+	c.logStart = c.seq + 1
+	close(c.appended)
+	c.appended = make(chan struct{})
+}
+
+// replicateEntry is to append one entry to the log of primary and wake shipping, and return its sequence, 0 if this is
+// not primary, caller must hold the mutex
+func (s *RegistryServer) replicateEntry(op, addr string) uint64 {
+	c := s.cluster
+	if c == nil || !c.isPrimary() {
+		return 0
+	}
+
+	c.seq++
+	c.log = append(c.log, registryReplicaEntry{Seq: c.seq, Op: op, Addr: addr})
+	if drop := len(c.log) - maxReplicaLog; drop > 0 {
+		c.log = append([]registryReplicaEntry(nil), c.log[drop:]...)
+		c.logStart += uint64(drop)
+	}
+	close(c.appended)
+	c.appended = make(chan struct{})
+	return c.seq
+}
+
+// expiring is to check whether servers are expired here, which is up to primary in a cluster, caller must hold the mutex
+func (s *RegistryServer) expiring() bool {
+	return s.cluster == nil || s.cluster.isPrimary()
+}
+
+// ship is to send the log to one backup in order, retrying every heartbeat period until acknowledged, and heartbeat it
+// while there is nothing to send
+func (s *RegistryServer) ship(c *registryCluster, peer string, term uint64, stop chan struct{}) {
+	var next uint64
+	needSnapshot := true
+	for {
+		s.mu.Lock()
+		if c.term != term || !c.isPrimary() {
+			s.mu.Unlock()
+			return
+		}
+		req := &registryAppend{Term: term, Primary: c.opt.Self}
+		if needSnapshot || next < c.logStart {
+			req.Seq = c.seq
+			req.Snapshot = s.dump()
+		} else {
+			req.Seq = next - 1
+			req.Entries = append([]registryReplicaEntry(nil), c.log[next-c.logStart:]...)
+		}
+		appended := c.appended
+		s.mu.Unlock()
+
+		state, err := sendAppend(peer, c.opt.Credentials, req)
+		if err != nil {
+			logger.Default().Log(logger.Warn, "registry: failed to replicate to peer", logger.Peer(peer), logger.Err(err))
+		} else {
+			s.mu.Lock()
+			if state.Term > c.term {
+				s.stepDown(c, state.Term, "")
+				s.mu.Unlock()
+				return
+			}
+			needSnapshot = state.StateTerm != term || state.Seq+1 < c.logStart || state.Seq > c.seq
+			if !needSnapshot {
+				next = state.Seq + 1
+				if state.Seq > c.acked[peer] {
+					c.acked[peer] = state.Seq
+					close(c.ackChanged)
+					c.ackChanged = make(chan struct{})
+				}
+			}
+			pending := !needSnapshot && next <= c.seq
+			s.mu.Unlock()
+
+			// sent again at once unless the backup rejected a snapshot
+			if pending || needSnapshot && req.Snapshot == nil {
+				continue
+			}
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-appended:
+		case <-time.After(c.opt.HeartbeatPeriod):
+		}
+	}
+}
+
+// waitReplicated is to wait until a majority of members applied the entry of seq
+func (s *RegistryServer) waitReplicated(seq uint64) error {
+	deadline := time.After(defaultReplicateTimeout)
+	for {
+		s.mu.Lock()
+		c := s.cluster
+		if c == nil || !c.isPrimary() {
+			s.mu.Unlock()
+			return fmt.Errorf("registry: failed to replicate, err: no longer primary")
+		}
+		applied := 1
+		for _, acked := range c.acked {
+			if acked >= seq {
+				applied++
+			}
+		}
+		if applied*2 > len(c.opt.Members) {
+			s.mu.Unlock()
+			return nil
+		}
+		ackChanged := c.ackChanged
+		s.mu.Unlock()
+
+		select {
+		case <-ackChanged:
+		case <-deadline:
+			return fmt.Errorf("registry: failed to replicate, err: not applied by a majority of members")
+		}
+	}
+}
+
+// serveRegister is to register server on primary or out of a cluster, or forward the registration from a backup
+func (s *RegistryServer) serveRegister(w http.ResponseWriter, r *http.Request, addr string) {
+	s.mu.Lock()
+	c := s.cluster
+	if c == nil || c.isPrimary() {
+		seq := s.registerServerLocked(addr)
+		s.mu.Unlock()
+
+		if seq != 0 {
+			if err := s.waitReplicated(seq); err != nil {
+				logger.Default().Log(logger.Warn, "registry: failed to register server", logger.Peer(addr), logger.Err(err))
+				http.Error(w, "503 Registration Not Replicated", http.StatusServiceUnavailable)
+			}
+		}
+		return
+	}
+	primary := c.primary
+	s.mu.Unlock()
+
+	// forwarded once, a backup never forwards to another backup
+	if primary == "" || r.Header.Get(registryForwardedHeader) != "" {
+		http.Error(w, "503 Registry Primary Unknown", http.StatusServiceUnavailable)
+		return
+	}
+	req, err := http.NewRequest("POST", primary, nil)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	req.Header.Set("X-Gingle-Rpc-Server", addr)
+	req.Header.Set(registryForwardedHeader, "true")
+	res, err := replicateClient.Do(req)
+	if err != nil {
+		logger.Default().Log(logger.Warn, "registry: failed to forward registration to primary", logger.Peer(primary), logger.Err(err))
+		http.Error(w, "503 Registry Primary Unreachable", http.StatusServiceUnavailable)
+		return
+	}
+	_ = res.Body.Close()
+	w.WriteHeader(res.StatusCode)
+}
+
+// serveAppend is to apply entries or install snapshot shipped by primary of the current or a later term, and reply state
+func (s *RegistryServer) serveAppend(w http.ResponseWriter, r *http.Request) {
+	var req registryAppend
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	c := s.cluster
+	if req.Term >= c.term {
+		if req.Term > c.term || c.primary != req.Primary {
+			s.stepDown(c, req.Term, req.Primary)
+		}
+		c.heardAt = time.Now()
+
+		if req.Snapshot != nil {
+			s.restore(req.Snapshot, s.timeout)
+			if s.store != nil {
+				if err := s.store.save(s.dump()); err != nil {
+					logger.Default().Log(logger.Error, "registry: failed to save snapshot", logger.Err(err))
+				}
+			}
+			c.stateTerm, c.seq = req.Term, req.Seq
+		} else if c.stateTerm == req.Term && c.seq == req.Seq {
+			for _, entry := range req.Entries {
+				s.applyEntry(entry)
+				c.seq = entry.Seq
+			}
+		}
+	}
+	state := &registryReplicaState{Term: c.term, StateTerm: c.stateTerm, Seq: c.seq, Primary: c.primary}
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(state)
+}
+
+// serveProbe is to reply state along with its snapshot to a peer electing primary
+func (s *RegistryServer) serveProbe(w http.ResponseWriter) {
+	s.mu.Lock()
+	c := s.cluster
+	state := &registryReplicaState{Term: c.term, StateTerm: c.stateTerm, Seq: c.seq, Primary: c.primary, Snapshot: s.dump()}
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(state)
+}
+
+// applyEntry is to apply one entry shipped by primary, caller must hold the mutex
+func (s *RegistryServer) applyEntry(entry registryReplicaEntry) {
+	_, ok := s.servers[entry.Addr]
+	switch {
+	case entry.Op == registryOpRegister && !ok:
+		s.servers[entry.Addr] = &RegistryServerItem{Addr: entry.Addr, StartAt: time.Now()}
+	case entry.Op == registryOpExpire && ok:
+		delete(s.servers, entry.Addr)
+	default:
+		return
+	}
+	if s.store != nil {
+		s.store.append(entry.Op, entry.Addr)
+	}
+	s.notifyChanged()
+}
+
+// authenticatePeer is to check whether r is from an authenticated peer, false if replication is not configured
+func (s *RegistryServer) authenticatePeer(r *http.Request) bool {
+	s.mu.Lock()
+	var authenticator auth.Authenticator
+	if s.cluster != nil {
+		authenticator = s.cluster.opt.Authenticator
+	}
+	s.mu.Unlock()
+	if authenticator == nil {
+		return false
+	}
+
+	req := &auth.Request{
+		ServiceMethod: registryReplicateMethod,
+		Authorization: r.Header.Get("Authorization"),
+		Identity:      (&Peer{Addr: r.RemoteAddr, TLS: r.TLS}).Identity(),
+	}
+	if _, err := authenticator.Authenticate(req); err != nil {
+		logger.Default().Log(logger.Warn, "registry: unauthenticated peer request", logger.Peer(r.RemoteAddr), logger.Err(err))
+		return false
+	}
+	return true
+}
+
+// newPeerRequest is to create request to peer marked as replica and signed by credentials
+func newPeerRequest(method, peer string, credentials auth.Credentials, body []byte) (*http.Request, error) {
+	req, err := http.NewRequest(method, peer, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(registryReplicaHeader, "true")
+	if credentials != nil {
+		authorization, err := credentials.Authorization(registryReplicateMethod)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", authorization)
+	}
+	return req, nil
+}
+
+func sendAppend(peer string, credentials auth.Credentials, entries *registryAppend) (*registryReplicaState, error) {
+	body, err := json.Marshal(entries)
+	if err != nil {
+		return nil, err
+	}
+	req, err := newPeerRequest("POST", peer, credentials, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return doPeerRequest(req)
+}
+
+func probeReplica(peer string, credentials auth.Credentials) (*registryReplicaState, error) {
+	req, err := newPeerRequest("GET", peer, credentials, nil)
+	if err != nil {
+		return nil, err
+	}
+	return doPeerRequest(req)
+}
+
+func doPeerRequest(req *http.Request) (*registryReplicaState, error) {
+	res, err := replicateClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", res.Status)
+	}
+
+	state := &registryReplicaState{}
+	if err := json.NewDecoder(res.Body).Decode(state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// splitRegistryAddrs is to split comma separated registry addresses
+func splitRegistryAddrs(registryAddr string) []string {
+	var addrs []string
+	for _, addr := range strings.Split(registryAddr, ",") {
+		if strings.TrimSpace(addr) != "" {
+			addrs = append(addrs, strings.TrimSpace(addr))
+		}
+	}
+	return addrs
+}
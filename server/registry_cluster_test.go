@@ -0,0 +1,258 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"gingle-rpc/auth"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testPeerSecrets = map[string][]byte{"registry": []byte("peer secret")}
+
+// testRegistry includes registry and the http server serving it
+type testRegistry struct {
+	*RegistryServer
+	ts *httptest.Server
+}
+
+// kill is to stop registry and its http server, as if the process was killed
+func (r *testRegistry) kill() {
+	_ = r.Close()
+	r.ts.Close()
+}
+
+func newTestReplicationOption(members []string, self string) *ReplicationOption {
+	return &ReplicationOption{
+		Members:         members,
+		Self:            self,
+		Credentials:     auth.HMACCredentials{ID: "registry", Secret: testPeerSecrets["registry"]},
+		Authenticator:   auth.NewHMACAuthenticator(testPeerSecrets, 0),
+		HeartbeatPeriod: 20 * time.Millisecond,
+		FailoverTimeout: 200 * time.Millisecond,
+	}
+}
+
+// startTestCluster is to start n registries replicating among each other
+func startTestCluster(t *testing.T, n int, timeout time.Duration) []*testRegistry {
+	registries := make([]*testRegistry, n)
+	members := make([]string, n)
+	for i := range registries {
+		registry := NewRegistryServer(timeout)
+		registries[i] = &testRegistry{RegistryServer: registry, ts: httptest.NewServer(registry)}
+		members[i] = registries[i].ts.URL
+	}
+	t.Cleanup(func() {
+		for _, registry := range registries {
+			registry.kill()
+		}
+	})
+
+	for i, registry := range registries {
+		if err := registry.Replicate(newTestReplicationOption(members, members[i])); err != nil {
+			t.Fatalf("replicate: %v", err)
+		}
+	}
+	return registries
+}
+
+// waitPrimary is to wait until one of registries is primary followed by all the others, and return it
+func waitPrimary(t *testing.T, registries []*testRegistry) *testRegistry {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		primaries := make(map[string]bool)
+		for _, registry := range registries {
+			registry.mu.Lock()
+			if registry.cluster != nil {
+				primaries[registry.cluster.primary] = true
+			}
+			registry.mu.Unlock()
+		}
+		if len(primaries) == 1 {
+			for _, registry := range registries {
+				if primaries[registry.ts.URL] {
+					return registry
+				}
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("no primary elected")
+	return nil
+}
+
+func registered(registry *testRegistry, addr string) bool {
+	addrs, _ := registry.exploreServers()
+	for _, a := range addrs {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
+
+func waitRegistered(t *testing.T, registry *testRegistry, addr string, want bool) {
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		if registered(registry, addr) == want {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("%s registered at %s = %v, want %v", addr, registry.ts.URL, !want, want)
+}
+
+func TestRegistryReplicate(t *testing.T) {
+	tests := []struct {
+		name  string
+		nodes int
+	}{
+		{"three nodes", 3},
+		{"five nodes", 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registries := startTestCluster(t, tt.nodes, time.Minute)
+			waitPrimary(t, registries)
+
+			// registered directly at every node, forwarded to primary by backups
+			for i, registry := range registries {
+				addr := fmt.Sprintf("tcp@127.0.0.1:%d", 9000+i)
+				if err := HealthCheckOnce(registry.ts.URL, addr); err != nil {
+					t.Fatalf("register: %v", err)
+				}
+				for _, peer := range registries {
+					waitRegistered(t, peer, addr, true)
+				}
+			}
+		})
+	}
+}
+
+func TestRegistryFailover(t *testing.T) {
+	tests := []struct {
+		name  string
+		nodes int
+	}{
+		{"three nodes", 3},
+		{"five nodes", 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registries := startTestCluster(t, tt.nodes, time.Minute)
+			primary := waitPrimary(t, registries)
+
+			var urls []string
+			for _, registry := range registries {
+				urls = append(urls, registry.ts.URL)
+			}
+			registryAddr := strings.Join(urls, ",")
+
+			// registrations replied are applied by a majority, so that none is lost with primary
+			var addrs []string
+			for i := 0; i < 20; i++ {
+				addr := fmt.Sprintf("tcp@127.0.0.1:%d", 9000+i)
+				if err := HealthCheckOnce(registryAddr, addr); err != nil {
+					t.Fatalf("register: %v", err)
+				}
+				addrs = append(addrs, addr)
+			}
+			primary.kill()
+
+			var survivors []*testRegistry
+			for _, registry := range registries {
+				if registry != primary {
+					survivors = append(survivors, registry)
+				}
+			}
+			promoted := waitPrimary(t, survivors)
+			for _, addr := range addrs {
+				if !registered(promoted, addr) {
+					t.Fatalf("%s lost with primary", addr)
+				}
+			}
+
+			// later registrations fail over from the killed primary and are shipped by the promoted one
+			if err := HealthCheckOnce(registryAddr, "tcp@after"); err != nil {
+				t.Fatalf("register: %v", err)
+			}
+			for _, registry := range survivors {
+				for _, addr := range append(addrs, "tcp@after") {
+					waitRegistered(t, registry, addr, true)
+				}
+			}
+		})
+	}
+}
+
+func TestRegistryReplicateExpiration(t *testing.T) {
+	registries := startTestCluster(t, 3, 300*time.Millisecond)
+	waitPrimary(t, registries)
+
+	if err := HealthCheckOnce(registries[0].ts.URL, "tcp@expired"); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	for _, registry := range registries {
+		waitRegistered(t, registry, "tcp@expired", true)
+	}
+	// expired by primary without heartbeats, and removed from backups by the entry shipped
+	for _, registry := range registries {
+		waitRegistered(t, registry, "tcp@expired", false)
+	}
+}
+
+func TestRegistryPeerAuthentication(t *testing.T) {
+	body, _ := json.Marshal(&registryAppend{Term: 1, Primary: "http://127.0.0.1:1"})
+
+	tests := []struct {
+		name          string
+		replica       bool
+		authorization string
+		wantStatus    int
+	}{
+		{"registration without primary", false, "", http.StatusServiceUnavailable},
+		{"replica without credentials", true, "", http.StatusUnauthorized},
+		{"replica of wrong secret", true, auth.SignHMAC("registry", []byte("wrong"), registryReplicateMethod, time.Now()), http.StatusUnauthorized},
+		{"replica of other method", true, auth.SignHMAC("registry", testPeerSecrets["registry"], "Foo.Sum", time.Now()), http.StatusUnauthorized},
+		{"authenticated replica", true, auth.SignHMAC("registry", testPeerSecrets["registry"], registryReplicateMethod, time.Now()), http.StatusOK},
+	}
+
+	// a backup of two members never reaches a majority without its unreachable peer
+	registry := NewRegistryServer(time.Minute)
+	ts := httptest.NewServer(registry)
+	defer ts.Close()
+	if err := registry.Replicate(newTestReplicationOption([]string{"http://127.0.0.1:1", ts.URL}, ts.URL)); err != nil {
+		t.Fatalf("replicate: %v", err)
+	}
+	defer func() {
+		_ = registry.Close()
+	}()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("POST", ts.URL, bytes.NewReader(body))
+			req.Header.Set("X-Gingle-Rpc-Server", "tcp@127.0.0.1:9100")
+			if tt.replica {
+				req.Header.Set(registryReplicaHeader, "true")
+			}
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("post: %v", err)
+			}
+			_ = res.Body.Close()
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", res.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,363 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"gingle-rpc/auth"
+	"gingle-rpc/logger"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	registrySnapshotFile = "registry.snapshot"
+	registryWalFile      = "registry.wal"
+
+	registryOpRegister = "register"
+	registryOpExpire   = "expire"
+)
+
+// RegistrySnapshot includes revision and servers of registry
+type RegistrySnapshot struct {
+	Revision uint64
+	Servers  []*RegistryServerItem
+}
+
+// registryWalEntry includes operation and address
+type registryWalEntry struct {
+	Op   string
+	Addr string
+}
+
+// registryStore includes directory, write-ahead log file and its encoder
+type registryStore struct {
+	dir string
+	wal *os.File
+	enc *json.Encoder
+}
+
+func openRegistryStore(dir string) (*registryStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	wal, err := os.OpenFile(filepath.Join(dir, registryWalFile), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &registryStore{
+		dir: dir,
+		wal: wal,
+		enc: json.NewEncoder(wal),
+	}, nil
+}
+
+// append is to append one entry to write-ahead log, synced so that it survives a crash
+func (st *registryStore) append(op, addr string) {
+	if err := st.enc.Encode(&registryWalEntry{Op: op, Addr: addr}); err != nil {
+		logger.Default().Log(logger.Error, "registry: failed to append write-ahead log", logger.Err(err))
+		return
+	}
+	if err := st.wal.Sync(); err != nil {
+		logger.Default().Log(logger.Error, "registry: failed to sync write-ahead log", logger.Err(err))
+	}
+}
+
+// load is to read snapshot and replay write-ahead log
+func (st *registryStore) load() (*RegistrySnapshot, error) {
+	snapshot := &RegistrySnapshot{}
+
+	data, err := os.ReadFile(filepath.Join(st.dir, registrySnapshotFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, snapshot); err != nil {
+			return nil, fmt.Errorf("registry: failed to decode snapshot, err: %v", err)
+		}
+	}
+
+	servers := make(map[string]*RegistryServerItem)
+	for _, item := range snapshot.Servers {
+		servers[item.Addr] = item
+	}
+
+	wal, err := os.Open(filepath.Join(st.dir, registryWalFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		defer func() {
+			_ = wal.Close()
+		}()
+
+		scanner := bufio.NewScanner(wal)
+		for scanner.Scan() {
+			var entry registryWalEntry
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+				// a torn tail is left by a crash in the middle of appending, ignore the rest
+				logger.Default().Log(logger.Warn, "registry: failed to decode write-ahead log", logger.Err(err))
+				break
+			}
+
+			switch entry.Op {
+			case registryOpRegister:
+				servers[entry.Addr] = &RegistryServerItem{Addr: entry.Addr}
+			case registryOpExpire:
+				delete(servers, entry.Addr)
+			}
+			snapshot.Revision++
+		}
+	}
+
+	snapshot.Servers = make([]*RegistryServerItem, 0, len(servers))
+	for _, item := range servers {
+		snapshot.Servers = append(snapshot.Servers, item)
+	}
+	return snapshot, nil
+}
+
+// save is to write snapshot atomically and truncate write-ahead log, the snapshot and its rename are synced first so
+// that a crash at any point leaves either the former snapshot with the whole log or the new one
+func (st *registryStore) save(snapshot *RegistrySnapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(st.dir, registrySnapshotFile+".tmp")
+	if err := writeFileSync(tmp, data); err != nil {
+		return fmt.Errorf("registry: failed to write snapshot, err: %v", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(st.dir, registrySnapshotFile)); err != nil {
+		return fmt.Errorf("registry: failed to rename snapshot, err: %v", err)
+	}
+	if err := syncDir(st.dir); err != nil {
+		return fmt.Errorf("registry: failed to sync directory, err: %v", err)
+	}
+
+	if err := st.wal.Truncate(0); err != nil {
+		return err
+	}
+	return st.wal.Sync()
+}
+
+// writeFileSync is to write data to file and sync it before closing
+func writeFileSync(name string, data []byte) error {
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// syncDir is to sync directory so that the entries renamed in it survive a crash
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = d.Close()
+	}()
+	return d.Sync()
+}
+
+func (st *registryStore) close() error {
+	return st.wal.Close()
+}
+
+// Persist is to restore registry from dir and persist later changes to it, restored servers are alive within grace
+func (s *RegistryServer) Persist(dir string, grace time.Duration) error {
+	st, err := openRegistryStore(dir)
+	if err != nil {
+		return err
+	}
+
+	snapshot, err := st.load()
+	if err != nil {
+		_ = st.close()
+		return err
+	}
+
+	s.mu.Lock()
+	s.store = st
+	s.restore(snapshot, grace)
+	if err := st.save(s.dump()); err != nil {
+		logger.Default().Log(logger.Error, "registry: failed to save snapshot", logger.Err(err))
+	}
+	s.stopSnapshot = make(chan struct{})
+	s.mu.Unlock()
+
+	go s.snapshotPeriodically(s.stopSnapshot, defaultSnapshotPeriod)
+
+	return nil
+}
+
+// Close is to stop replicating, take a last snapshot and stop persisting
+func (s *RegistryServer) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stopReplicating()
+	if s.store == nil {
+		return nil
+	}
+	close(s.stopSnapshot)
+
+	err := s.store.save(s.dump())
+	if closeErr := s.store.close(); err == nil {
+		err = closeErr
+	}
+	s.store = nil
+	return err
+}
+
+// Dump is to get a snapshot of registry
+func (s *RegistryServer) Dump() *RegistrySnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.dump()
+}
+
+// Load is to replace registry with snapshot, loaded servers are alive within grace, in a cluster it is up to primary
+// and installed by every backup afterwards
+func (s *RegistryServer) Load(snapshot *RegistrySnapshot, grace time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cluster != nil {
+		if !s.cluster.isPrimary() {
+			return fmt.Errorf("registry: failed to load state, err: not primary")
+		}
+		s.cluster.resetLog()
+	}
+	s.restore(snapshot, grace)
+	if s.store != nil {
+		return s.store.save(s.dump())
+	}
+	return nil
+}
+
+// dump is to copy servers into a snapshot, caller must hold the mutex
+func (s *RegistryServer) dump() *RegistrySnapshot {
+	snapshot := &RegistrySnapshot{
+		Revision: s.revision,
+		Servers:  make([]*RegistryServerItem, 0, len(s.servers)),
+	}
+	for _, item := range s.servers {
+		itemCopy := *item
+		snapshot.Servers = append(snapshot.Servers, &itemCopy)
+	}
+	return snapshot
+}
+
+// restore is to replace servers by snapshot, caller must hold the mutex
+func (s *RegistryServer) restore(snapshot *RegistrySnapshot, grace time.Duration) {
+	// restored servers should heartbeat again within grace, or they will be expired
+	startAt := time.Now()
+	if s.timeout != 0 {
+		startAt = startAt.Add(grace - s.timeout)
+	}
+
+	s.servers = make(map[string]*RegistryServerItem)
+	for _, item := range snapshot.Servers {
+		s.servers[item.Addr] = &RegistryServerItem{
+			Addr:    item.Addr,
+			StartAt: startAt,
+		}
+	}
+
+	if snapshot.Revision > s.revision {
+		s.revision = snapshot.Revision
+	}
+	s.notifyChanged()
+}
+
+func (s *RegistryServer) snapshotPeriodically(stop chan struct{}, period time.Duration) {
+	t := time.NewTicker(period)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			s.mu.Lock()
+			if s.store != nil {
+				if err := s.store.save(s.dump()); err != nil {
+					logger.Default().Log(logger.Error, "registry: failed to save snapshot", logger.Err(err))
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+const registryStateMethod = "Registry.State"
+
+// RegistryStateServer includes registry server, and authenticator and policy of admins checked for the pseudo method
+// Registry.State, it is not mounted by HandleHTTP and rejects every request unless authenticator is set
+type RegistryStateServer struct {
+	*RegistryServer
+
+	Authenticator auth.Authenticator
+	Policy        *auth.Policy
+}
+
+// ServeHTTP is to dump or load registry state for an authorized admin, loading replaces all servers
+func (s *RegistryStateServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.Authenticator == nil {
+		http.Error(w, "403 Registry State Disabled", http.StatusForbidden)
+		return
+	}
+	req := &auth.Request{
+		ServiceMethod: registryStateMethod,
+		Authorization: r.Header.Get("Authorization"),
+		Identity:      (&Peer{Addr: r.RemoteAddr, TLS: r.TLS}).Identity(),
+	}
+	principal, err := s.Authenticator.Authenticate(req)
+	if err != nil {
+		logger.Default().Log(logger.Warn, "registry: unauthenticated state request", logger.Peer(r.RemoteAddr), logger.Err(err))
+		http.Error(w, "401 Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if s.Policy != nil && !s.Policy.Allow(principal, registryStateMethod) {
+		http.Error(w, "403 Forbidden", http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		// dump state
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(s.Dump())
+	case "POST":
+		// load state
+		var snapshot RegistrySnapshot
+		if err := json.NewDecoder(r.Body).Decode(&snapshot); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if err := s.Load(&snapshot, s.timeout); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		logger.Default().Log(logger.Info, "registry: state loaded", logger.Peer(r.RemoteAddr), logger.Any("principal", principal))
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
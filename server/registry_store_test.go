@@ -0,0 +1,87 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+// crash is to stop persisting without taking a last snapshot, as if the registry was killed
+func crash(s *RegistryServer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	close(s.stopSnapshot)
+	_ = s.store.close()
+	s.store = nil
+}
+
+func TestRegistryStoreRecovery(t *testing.T) {
+	tests := []struct {
+		name      string
+		closed    bool
+		tornTail  bool
+		wantAddrs []string
+	}{
+		{"recovered from write-ahead log after crash", false, false, []string{"tcp@127.0.0.1:2", "tcp@127.0.0.1:3"}},
+		{"recovered from snapshot after close", true, false, []string{"tcp@127.0.0.1:2", "tcp@127.0.0.1:3"}},
+		{"torn tail of write-ahead log ignored", false, true, []string{"tcp@127.0.0.1:2", "tcp@127.0.0.1:3"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			s := NewRegistryServer(time.Minute)
+			if err := s.Persist(dir, time.Minute); err != nil {
+				t.Fatalf("persist: %v", err)
+			}
+			for _, addr := range []string{"tcp@127.0.0.1:1", "tcp@127.0.0.1:2", "tcp@127.0.0.1:3"} {
+				s.registerServer(addr)
+			}
+			// expire the first server
+			s.mu.Lock()
+			s.servers["tcp@127.0.0.1:1"].StartAt = time.Now().Add(-time.Hour)
+			s.mu.Unlock()
+			_, revision := s.exploreServers()
+
+			if tt.closed {
+				if err := s.Close(); err != nil {
+					t.Fatalf("close: %v", err)
+				}
+			} else {
+				crash(s)
+			}
+			if tt.tornTail {
+				wal, err := os.OpenFile(filepath.Join(dir, registryWalFile), os.O_APPEND|os.O_WRONLY, 0644)
+				if err != nil {
+					t.Fatalf("open write-ahead log: %v", err)
+				}
+				_, _ = wal.WriteString(`{"Op":"register","Addr":"tcp@127.0`)
+				_ = wal.Close()
+			}
+			if _, err := os.Stat(filepath.Join(dir, registrySnapshotFile+".tmp")); !os.IsNotExist(err) {
+				t.Fatalf("temporary snapshot left, err: %v", err)
+			}
+
+			reopened := NewRegistryServer(time.Minute)
+			if err := reopened.Persist(dir, time.Minute); err != nil {
+				t.Fatalf("reopen: %v", err)
+			}
+			defer func() {
+				_ = reopened.Close()
+			}()
+
+			addrs, reopenedRevision := reopened.exploreServers()
+			sort.Strings(addrs)
+			if len(addrs) != len(tt.wantAddrs) || addrs[0] != tt.wantAddrs[0] || addrs[1] != tt.wantAddrs[1] {
+				t.Fatalf("addrs = %v, want %v", addrs, tt.wantAddrs)
+			}
+			// watchers of the former registry see a change instead of the same revision
+			if reopenedRevision <= revision {
+				t.Fatalf("revision = %d, want greater than %d", reopenedRevision, revision)
+			}
+		})
+	}
+}
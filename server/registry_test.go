@@ -0,0 +1,40 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealthCheckOnce(t *testing.T) {
+	registry := httptest.NewServer(NewRegistryServer(time.Minute))
+	defer registry.Close()
+	unavailable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer unavailable.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	tests := []struct {
+		name       string
+		serverAddr string
+		wantErr    bool
+	}{
+		{"registered", registry.URL, false},
+		{"failed over from unreachable registry", down.URL + "," + registry.URL, false},
+		{"failed over from unavailable registry", unavailable.URL + "," + registry.URL, false},
+		{"unavailable registry", unavailable.URL, true},
+		{"no registry", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := HealthCheckOnce(tt.serverAddr, "tcp@127.0.0.1:9000")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
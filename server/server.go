@@ -1,30 +1,49 @@
 package server
 
 import (
+	"bufio"
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"gingle-rpc/codec"
+	"gingle-rpc/logger"
+	"gingle-rpc/metrics"
 	"gingle-rpc/service"
+	"gingle-rpc/status"
+	"gingle-rpc/trace"
+	"gingle-rpc/websocket"
 	"io"
-	"log"
 	"net"
 	"net/http"
 	"reflect"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const (
 	defaultHandlePath   = "/gingle/handle"
 	defaultDebugPath    = "/gingle/debug"
+	defaultMetricsPath  = "/gingle/metrics"
 	defaultRegistryPath = "/gingle/registry"
+	defaultJSONRPCPath  = "/gingle/jsonrpc"
+	defaultWSPath       = "/gingle/ws"
 
-	defaultTimeout = 5 * time.Minute
-	defaultPeriod  = 3 * time.Minute
+	defaultTimeout      = 5 * time.Minute
+	defaultPeriod       = 3 * time.Minute
+	defaultWatchTimeout = 30 * time.Second
+
+	defaultHealthCheckTimeout = 10 * time.Second
+
+	defaultSnapshotPeriod = time.Minute
+
+	defaultHandshakeTimeout = 10 * time.Second
 )
 
-// Call includes header, service, method, args and reply
+// Call includes header, service, method, args, reply, start time and request size
 type Call struct {
 	Header *codec.Header
 
@@ -33,21 +52,57 @@ type Call struct {
 
 	Args  reflect.Value
 	Reply reflect.Value
+
+	startAt      time.Time
+	requestBytes uint64
 }
 
-// Server includes services
+// Server includes services, health, connection counts, batch limit, codec errors, tracer, logger, access log, slow call log,
+// auth, interceptors and the registry mounted by HandleHTTP
 type Server struct {
+	conns         int64
+	acceptedConns uint64
+	maxBatchSize  int64
+	maxBatchBytes int64
+
 	Services sync.Map
+
+	health      *Health
+	codecErrors metrics.CounterVec // operation -> errors
+	tracer      atomic.Value       // *trace.Tracer
+	logger      atomic.Value       // logger.Logger
+	accessLog   atomic.Value       // *accessLog
+	slowCallLog atomic.Value       // *slowCallLog
+	auth        atomic.Value       // *authConfig
+
+	interceptors      atomic.Value // []Interceptor
+	muForInterceptors sync.Mutex
+
+	registry      *RegistryServer
+	muForRegistry sync.Mutex
 }
 
-// NewServer is to create server
+// NewServer is to create server with the built-in reflection and health services, logging by the default logger
 func NewServer() *Server {
-	return &Server{}
+	s := &Server{}
+	s.SetLogger(nil)
+	s.health = newHealth(s)
+	_ = s.RegisterServiceName(ReflectionServiceName, &Reflection{server: s})
+	_ = s.RegisterServiceName(service.HealthServiceName, s.health)
+	return s
 }
 
-// RegisterService is to register service to server map
+// RegisterService is to register service named by its type to server map
 func (s *Server) RegisterService(instance interface{}) error {
-	service := service.NewService(instance)
+	return s.RegisterServiceName("", instance)
+}
+
+// RegisterServiceName is to register service under name to server map, or named by its type if name is empty
+func (s *Server) RegisterServiceName(name string, instance interface{}) error {
+	service, err := service.NewNamedService(name, instance, s.getLogger())
+	if err != nil {
+		return err
+	}
 	if _, ok := s.Services.LoadOrStore(service.Name, service); ok {
 		return fmt.Errorf("server: service %s already defined", service.Name)
 	}
@@ -58,14 +113,14 @@ func (s *Server) RegisterService(instance interface{}) error {
 func (s *Server) RetrieveService(serviceMethod string) (svc *service.Service, rpcMethod *service.RpcMethod, err error) {
 	dot := strings.LastIndex(serviceMethod, ".")
 	if dot < 0 {
-		err = fmt.Errorf("server: service.method %s format not correct", serviceMethod)
+		err = status.Errorf(status.InvalidArgument, "server: service.method %s format not correct", serviceMethod)
 		return
 	}
 
 	serviceName := serviceMethod[:dot]
 	svcInterface, ok := s.Services.Load(serviceName)
 	if !ok {
-		err = fmt.Errorf("server: service.method %s service not found", serviceMethod)
+		err = status.Errorf(status.NotFound, "server: service.method %s service not found", serviceMethod)
 		return
 	}
 	svc = svcInterface.(*service.Service)
@@ -73,24 +128,55 @@ func (s *Server) RetrieveService(serviceMethod string) (svc *service.Service, rp
 	methodName := serviceMethod[dot+1:]
 	rpcMethod, ok = svc.RpcMethods[methodName]
 	if !ok {
-		err = fmt.Errorf("server: service.method %s method not found", serviceMethod)
+		err = status.Errorf(status.NotFound, "server: service.method %s method not found", serviceMethod)
 		return
 	}
 
 	return
 }
 
+// SetTracer is to trace later calls by tracer, as child spans of caller spans in request headers
+func (s *Server) SetTracer(tracer *trace.Tracer) {
+	s.tracer.Store(tracer)
+}
+
+// SetLogger is to log later services and connections by l, nil means the default logger
+func (s *Server) SetLogger(l logger.Logger) {
+	s.logger.Store(&loggerHolder{logger.OrDefault(l)})
+}
+
+// loggerHolder keeps atomic value of the same concrete type for any logger
+type loggerHolder struct {
+	logger.Logger
+}
+
+func (s *Server) getLogger() logger.Logger {
+	return s.logger.Load().(*loggerHolder).Logger
+}
+
+// ActiveConns is to get the number of connections being served
+func (s *Server) ActiveConns() int64 {
+	return atomic.LoadInt64(&s.conns)
+}
+
 // Accept is to listen and serve client connection
 func (s *Server) Accept(lis net.Listener) {
 	for {
 		conn, err := lis.Accept()
 		if err != nil {
-			log.Printf("server: failed to accept listener, err: %v\n", err)
+			s.getLogger().Log(logger.Error, "server: failed to accept listener", logger.Err(err))
+			return
 		}
 		go s.ServeConn(conn)
 	}
 }
 
+// AcceptTLS is to listen and serve client connection over tls, config.ClientAuth with client cas enables mutual tls
+// whose verified client identity is given to handlers by PeerFromContext
+func (s *Server) AcceptTLS(lis net.Listener, config *tls.Config) {
+	s.Accept(tls.NewListener(lis, config))
+}
+
 // ServeHTTP is to exchange http to rpc protocol
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "CONNECT" {
@@ -108,7 +194,7 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, _ = io.WriteString(w, "HTTP/1.0 200 Connected to Gingle RPC\n\n")
+	_, _ = io.WriteString(conn, "HTTP/1.0 200 Connected to Gingle RPC\n\n")
 	s.ServeConn(conn)
 }
 
@@ -116,74 +202,291 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 func (s *Server) HandleHTTP() {
 	http.Handle(defaultHandlePath, s)
 	http.Handle(defaultDebugPath, &DebugServer{Server: s})
-	http.Handle(defaultRegistryPath, NewRegistryServer(defaultTimeout))
+	http.Handle(defaultMetricsPath, &MetricsServer{Server: s})
+	http.Handle(defaultJSONRPCPath, &JSONRPCServer{Server: s})
+	http.Handle(defaultWSPath, &WebSocketServer{Server: s})
+	http.Handle(defaultRegistryPath, s.Registry())
+}
+
+// SetRegistry is to mount registry by HandleHTTP instead of a default one, e.g. a registry persisted by Persist
+func (s *Server) SetRegistry(registry *RegistryServer) {
+	s.muForRegistry.Lock()
+	defer s.muForRegistry.Unlock()
+
+	s.registry = registry
+}
+
+// Registry is to get the registry mounted by HandleHTTP, a default one is created if not set
+func (s *Server) Registry() *RegistryServer {
+	s.muForRegistry.Lock()
+	defer s.muForRegistry.Unlock()
+
+	if s.registry == nil {
+		s.registry = NewRegistryServer(defaultTimeout)
+	}
+	return s.registry
 }
 
 // ServeConn is to parse option, choose a codec func and serve codec
 func (s *Server) ServeConn(conn io.ReadWriteCloser) {
+	atomic.AddInt64(&s.conns, 1)
+	atomic.AddUint64(&s.acceptedConns, 1)
 	defer func() {
+		atomic.AddInt64(&s.conns, -1)
 		_ = conn.Close()
 	}()
 
+	peer := &Peer{}
+	if netConn, ok := conn.(net.Conn); ok {
+		peer.Addr = netConn.RemoteAddr().String()
+	}
+	l := s.getLogger()
+
+	// handshake before reading option so that the peer identity is known, http hijacked tls connections are done already
+	tlsConn, ok := conn.(*tls.Conn)
+	if wsConn, isWS := conn.(*websocket.Conn); isWS {
+		tlsConn, ok = wsConn.UnderlyingConn().(*tls.Conn)
+	}
+	if ok {
+		_ = tlsConn.SetDeadline(time.Now().Add(defaultHandshakeTimeout))
+		if err := tlsConn.Handshake(); err != nil {
+			l.Log(logger.Warn, "server: failed to handshake tls", logger.Peer(peer.Addr), logger.Err(err))
+			return
+		}
+		_ = tlsConn.SetDeadline(time.Time{})
+		state := tlsConn.ConnectionState()
+		peer.TLS = &state
+	}
+
 	var opt codec.Option
-	if err := json.NewDecoder(conn).Decode(&opt); err != nil {
-		log.Printf("server: failed to decode option, err: %v\n", err)
+	dec := json.NewDecoder(conn)
+	if err := dec.Decode(&opt); err != nil {
+		l.Log(logger.Warn, "server: failed to decode option", logger.Peer(peer.Addr), logger.Err(err))
+		s.codecErrors.Add("read_option", 1)
 		return
 	}
 	if opt.MagicNumber != codec.MagicNumber {
-		log.Printf("server: failed to verify magic number, num: %d\n", opt.MagicNumber)
+		l.Log(logger.Warn, "server: failed to verify magic number", logger.Peer(peer.Addr), logger.Any("magic", opt.MagicNumber))
 		return
 	}
 
 	fc, ok := codec.NewCodecFuncMap[opt.CodecType]
 	if fc == nil || !ok {
-		log.Printf("server: failed to generate codec func\n")
+		l.Log(logger.Warn, "server: failed to generate codec func", logger.Peer(peer.Addr), logger.Any("codec", opt.CodecType))
 		return
 	}
 
-	s.serveCodec(fc(conn), &opt)
+	// the option decoder may have buffered the beginning of the first request, skip the newline ending the option
+	reader := bufio.NewReader(io.MultiReader(dec.Buffered(), conn))
+	if b, err := reader.Peek(1); err == nil && b[0] == '\n' {
+		_, _ = reader.Discard(1)
+	}
+
+	counter := &countingConn{reader: reader, ReadWriteCloser: conn, peer: peer}
+	cc := fc(counter)
+	if setter, ok := cc.(codec.LoggerSetter); ok {
+		setter.SetLogger(l)
+	}
+	s.serveCodec(cc, &opt, counter)
+}
+
+// countingConn includes the reader left by option decoder, the connection, its peer and bytes read and written through them
+type countingConn struct {
+	reader *bufio.Reader
+	io.ReadWriteCloser
+	peer *Peer
+
+	read    uint64
+	written uint64
+
+	// limit and exceeded are only used by the goroutine reading requests
+	limit    uint64
+	exceeded bool
+}
+
+// errReadLimit is returned by reads beyond the limit of countingConn
+var errReadLimit = errors.New("read limit exceeded")
+
+// limitRead is to fail reads once n more bytes are read, 0 means no limit, exceeded is kept until the next limit
+func (c *countingConn) limitRead(n uint64) {
+	c.limit = 0
+	if n > 0 {
+		c.limit, c.exceeded = atomic.LoadUint64(&c.read)+n, false
+	}
+}
+
+// remaining is to get the number of bytes left to read within the limit, -1 if not limited
+func (c *countingConn) remaining() int {
+	if c.limit == 0 {
+		return -1
+	}
+	if read := atomic.LoadUint64(&c.read); read < c.limit {
+		return int(c.limit - read)
+	}
+	c.exceeded = true
+	return 0
+}
+
+// Read is to read from the reader left by option decoder
+func (c *countingConn) Read(p []byte) (int, error) {
+	if remaining := c.remaining(); remaining == 0 {
+		return 0, errReadLimit
+	} else if remaining > 0 && remaining < len(p) {
+		p = p[:remaining]
+	}
+	n, err := c.reader.Read(p)
+	atomic.AddUint64(&c.read, uint64(n))
+	return n, err
+}
+
+// ReadByte is to read one byte, which keeps gob decoder from buffering ahead so that bytes are counted per request
+func (c *countingConn) ReadByte() (byte, error) {
+	if c.remaining() == 0 {
+		return 0, errReadLimit
+	}
+	b, err := c.reader.ReadByte()
+	if err == nil {
+		atomic.AddUint64(&c.read, 1)
+	}
+	return b, err
+}
+
+// Write is to write to the connection
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Write(p)
+	atomic.AddUint64(&c.written, uint64(n))
+	return n, err
 }
 
-func (s *Server) serveCodec(cc codec.Codec, opt *codec.Option) {
+func (s *Server) serveCodec(cc codec.Codec, opt *codec.Option, counter *countingConn) {
 	mu := new(sync.Mutex)
 
+	conn := newConn(s, cc, opt, mu, counter)
+	ctx := ContextWithConn(ContextWithPeer(context.Background(), counter.peer), conn)
+	streams := newStreamTable()
 	wg := new(sync.WaitGroup)
 	for {
-		call, err := s.readRequest(cc)
+		readBefore := atomic.LoadUint64(&counter.read)
+		header, err := s.readRequestHeader(cc, counter)
 		if err != nil {
-			if call == nil {
+			break
+		}
+
+		// responses of callbacks from server to client
+		if header.Flags&codec.FlagCallback != 0 {
+			if err := conn.receive(header); err != nil {
+				break
+			}
+			continue
+		}
+
+		// frames of opened streams are dispatched here and never wait for handlers
+		if header.Flags&codec.FlagStream != 0 {
+			if err := s.readStreamFrame(cc, header, streams, counter); err != nil {
 				break
 			}
+			continue
+		}
 
-			call.Header.Error = err.Error()
-			s.sendResponse(cc, call.Header, struct{}{}, mu)
+		if header.Flags&codec.FlagBatch != 0 {
+			call, request, err := s.readBatch(cc, header, counter, readBefore)
+			if err != nil {
+				call.Header.SetError(err)
+				s.logCall(call, opt, counter, s.sendResponse(cc, call.Header, struct{}{}, mu, counter))
+				// the rest of a batch over the byte limit is left unread, so the connection cannot be read any further
+				if counter.exceeded {
+					break
+				}
+				continue
+			}
+			wg.Add(1)
+			go s.serveBatch(ctx, cc, opt, call, request, mu, wg, counter)
 			continue
 		}
 
+		call, err := s.readRequest(cc, header, counter, readBefore)
+		if err == nil && call.RpcMethod.ServerStreaming && header.Flags&codec.FlagOneWay != 0 {
+			err = status.Errorf(status.InvalidArgument, "server: service.method %s streams cannot be one way", header.ServiceMethod)
+		}
+		if err != nil {
+			call.Header.SetError(err)
+			if header.Flags&codec.FlagOneWay != 0 {
+				s.getLogger().Log(logger.Warn, "server: failed to read one way request", logger.Method(header.ServiceMethod), logger.Seq(header.SequenceNumber),
+					logger.Peer(counter.peer.Addr), logger.Err(err))
+				s.logCall(call, opt, counter, 0)
+				continue
+			}
+			s.logCall(call, opt, counter, s.sendResponse(cc, call.Header, struct{}{}, mu, counter))
+			continue
+		}
+		if call.RpcMethod.ServerStreaming {
+			s.openStream(ctx, cc, opt, call, mu, counter, streams)
+		}
+
 		wg.Add(1)
-		go s.serveHandler(cc, opt, call, mu, wg)
+		go s.serveHandler(ctx, cc, opt, call, mu, wg, counter)
 	}
+	conn.close()
+	streams.cancelAll()
 	wg.Wait()
 
 	_ = cc.Close()
 }
 
-func (s *Server) serveHandler(cc codec.Codec, opt *codec.Option, call *Call, mu *sync.Mutex, wg *sync.WaitGroup) {
+// serveHandler is to serve call, ctx carries the peer and connection of call
+func (s *Server) serveHandler(ctx context.Context, cc codec.Codec, opt *codec.Option, call *Call, mu *sync.Mutex, wg *sync.WaitGroup, counter *countingConn) {
 	defer wg.Done()
 
-	callMethodChan := make(chan struct{})
-	sendResponseChan := make(chan struct{})
+	var stream *serverStream
+	if call.RpcMethod.ServerStreaming {
+		stream = call.Reply.Interface().(*serverStream)
+		ctx = stream.ctx
+	}
+	ctx, span := s.startSpan(ctx, call, counter.peer.Addr)
+	if opt.HandleTimeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opt.HandleTimeout)
+		defer cancel()
+	}
+
+	// a stream is bounded by its context rather than cut off by a timeout response
+	if stream != nil {
+		s.serveStream(ctx, cc, opt, call, mu, counter, span)
+		return
+	}
+	if call.Header.Flags&codec.FlagOneWay != 0 {
+		s.serveOneWay(ctx, opt, call, counter, span)
+		return
+	}
+
+	// buffered so that the handler never blocks after a timeout, and whoever claims replied first sends the only response
+	callMethodChan := make(chan struct{}, 1)
+	sendResponseChan := make(chan struct{}, 1)
+	var replied int32
 
 	go func() {
-		err := call.Service.CallMethod(call.RpcMethod, call.Args, call.Reply)
+		startAt := time.Now()
+		err := s.handle(ctx, call)
+		s.getLogger().Log(logger.Debug, "server: handled call", logger.Method(call.Header.ServiceMethod), logger.Seq(call.Header.SequenceNumber),
+			logger.Peer(counter.peer.Addr), logger.Latency(time.Since(startAt)), logger.Err(err))
 		callMethodChan <- struct{}{}
-		if err != nil {
-			call.Header.Error = err.Error()
-			s.sendResponse(cc, call.Header, struct{}{}, mu)
-			sendResponseChan <- struct{}{}
+		if !atomic.CompareAndSwapInt32(&replied, 0, 1) {
+			// the timeout response has been sent
 			return
 		}
-		s.sendResponse(cc, call.Header, call.Reply, mu)
+		finishCall(call, span, err)
+		var responseBytes uint64
+		if err != nil {
+			call.Header.SetError(err)
+			responseBytes = s.sendResponse(cc, call.Header, struct{}{}, mu, counter)
+		} else if !call.Reply.IsValid() {
+			// a one way method called with a response only acknowledges it
+			responseBytes = s.sendResponse(cc, call.Header, struct{}{}, mu, counter)
+		} else {
+			responseBytes = s.sendResponse(cc, call.Header, call.Reply.Interface(), mu, counter)
+		}
+		call.RpcMethod.RecordResponse(responseBytes)
+		s.logCall(call, opt, counter, responseBytes)
 		sendResponseChan <- struct{}{}
 	}()
 
@@ -195,19 +498,82 @@ func (s *Server) serveHandler(cc codec.Codec, opt *codec.Option, call *Call, mu
 	}
 	select {
 	case <-time.After(opt.HandleTimeout):
-		call.Header.Error = fmt.Sprintf("server: failed to handle, err: handle timeout expected within %s", opt.HandleTimeout)
-		s.sendResponse(cc, call.Header, struct{}{}, mu)
+		if !atomic.CompareAndSwapInt32(&replied, 0, 1) {
+			// the handler returned just in time and is sending its response
+			<-sendResponseChan
+			return
+		}
+		err := status.Errorf(status.DeadlineExceeded, "server: failed to handle, err: handle timeout expected within %s", opt.HandleTimeout)
+		call.Header.SetError(err)
+		s.getLogger().Log(logger.Warn, "server: failed to handle in time", logger.Method(call.Header.ServiceMethod), logger.Seq(call.Header.SequenceNumber),
+			logger.Peer(counter.peer.Addr), logger.Latency(opt.HandleTimeout))
+		finishCall(call, span, err)
+		responseBytes := s.sendResponse(cc, call.Header, struct{}{}, mu, counter)
+		call.RpcMethod.RecordResponse(responseBytes)
+		s.logCall(call, opt, counter, responseBytes)
 	case <-callMethodChan:
 		<-sendResponseChan
 	}
 }
 
-func (s *Server) readRequestHeader(cc codec.Codec) (*codec.Header, error) {
+// serveOneWay is to call the method of a one way request and send nothing back, the error is logged instead
+func (s *Server) serveOneWay(ctx context.Context, opt *codec.Option, call *Call, counter *countingConn, span *trace.Span) {
+	startAt := time.Now()
+	err := s.handle(ctx, call)
+	finishCall(call, span, err)
+
+	level := logger.Debug
+	if err != nil {
+		level = logger.Warn
+	}
+	s.getLogger().Log(level, "server: handled one way call", logger.Method(call.Header.ServiceMethod), logger.Seq(call.Header.SequenceNumber),
+		logger.Peer(counter.peer.Addr), logger.Latency(time.Since(startAt)), logger.Err(err))
+
+	call.Header.SetError(err)
+	s.logCall(call, opt, counter, 0)
+}
+
+// startSpan is to start server span as the child of caller span in request header, and return ctx carrying it,
+// nil span if not traced. The caller span is carried by ctx even if not traced, so that calls made by the handler
+// keep propagating it
+func (s *Server) startSpan(ctx context.Context, call *Call, peer string) (context.Context, *trace.Span) {
+	if call.Header.Traceparent != "" {
+		parent, err := trace.ParseTraceparent(call.Header.Traceparent)
+		if err != nil {
+			s.getLogger().Log(logger.Warn, "server: failed to parse traceparent", logger.Method(call.Header.ServiceMethod), logger.Seq(call.Header.SequenceNumber),
+				logger.Peer(peer), logger.Err(err))
+		} else {
+			ctx = trace.ContextWithSpanContext(ctx, parent)
+		}
+	}
+
+	tracer, _ := s.tracer.Load().(*trace.Tracer)
+	if tracer == nil {
+		return ctx, nil
+	}
+
+	ctx, span := tracer.Start(ctx, call.Header.ServiceMethod, trace.Server)
+	span.Peer = peer
+	return ctx, span
+}
+
+// finishCall is to finish the span of call and count its error, on the only path replying the call
+func finishCall(call *Call, span *trace.Span, err error) {
+	if span != nil {
+		span.Finish(err)
+	}
+	if err != nil {
+		call.RpcMethod.RecordError(service.ErrorKind(err))
+	}
+}
+
+func (s *Server) readRequestHeader(cc codec.Codec, counter *countingConn) (*codec.Header, error) {
 	header := &codec.Header{}
 
 	if err := cc.ReadHeader(header); err != nil {
 		if err != io.EOF && err != io.ErrUnexpectedEOF {
-			log.Printf("server: failed to read request header, err: %v\n", err)
+			s.getLogger().Log(logger.Error, "server: failed to read request header", logger.Peer(counter.peer.Addr), logger.Err(err))
+			s.codecErrors.Add("read_header", 1)
 		}
 		return nil, err
 	}
@@ -215,49 +581,68 @@ func (s *Server) readRequestHeader(cc codec.Codec) (*codec.Header, error) {
 	return header, nil
 }
 
-func (s *Server) readRequestBody(cc codec.Codec, body interface{}) error {
+func (s *Server) readRequestBody(cc codec.Codec, header *codec.Header, body interface{}, counter *countingConn) error {
 	if err := cc.ReadBody(body); err != nil {
-		log.Printf("server: failed to read request body, err: %v\n", err)
-		return err
+		s.getLogger().Log(logger.Error, "server: failed to read request body", logger.Method(header.ServiceMethod), logger.Seq(header.SequenceNumber),
+			logger.Peer(counter.peer.Addr), logger.Err(err))
+		s.codecErrors.Add("read_body", 1)
+		return status.Errorf(status.InvalidArgument, "server: failed to read request body, err: %v", err)
 	}
 
 	return nil
 }
 
-func (s *Server) readRequest(cc codec.Codec) (*Call, error) {
+// readRequest is to read the request body of header, readBefore is bytes read before header
+func (s *Server) readRequest(cc codec.Codec, header *codec.Header, counter *countingConn, readBefore uint64) (*Call, error) {
 	var err error
-	call := &Call{}
-
-	header, err := s.readRequestHeader(cc)
-	if err != nil {
-		return nil, err
-	}
-	call.Header = header
+	call := &Call{Header: header, startAt: time.Now()}
+	defer func() {
+		call.requestBytes = atomic.LoadUint64(&counter.read) - readBefore
+	}()
 
 	call.Service, call.RpcMethod, err = s.RetrieveService(call.Header.ServiceMethod)
 	if err != nil {
+		// drain the body so that the next header can be read
+		_ = cc.ReadBody(nil)
 		return call, err
 	}
 	call.Args = call.RpcMethod.NewArgsValue()
 	call.Reply = call.RpcMethod.NewReplyValue()
 
-	argsInterface := call.Args.Interface()
-	if call.Args.Type().Kind() != reflect.Ptr {
-		argsInterface = call.Args.Addr().Interface()
+	// the request opening a bidirectional stream has no args
+	var argsInterface interface{}
+	if call.Args.IsValid() {
+		argsInterface = call.Args.Interface()
+		if call.Args.Type().Kind() != reflect.Ptr {
+			argsInterface = call.Args.Addr().Interface()
+		}
 	}
-	err = s.readRequestBody(cc, argsInterface)
+	err = s.readRequestBody(cc, call.Header, argsInterface, counter)
 	if err != nil {
 		return call, err
 	}
+	call.RpcMethod.RecordRequest(atomic.LoadUint64(&counter.read) - readBefore)
 
 	return call, nil
 }
 
-func (s *Server) sendResponse(cc codec.Codec, header *codec.Header, body codec.Body, mu *sync.Mutex) {
+// sendResponse is to write response and return its size in bytes
+func (s *Server) sendResponse(cc codec.Codec, header *codec.Header, body codec.Body, mu *sync.Mutex, counter *countingConn) uint64 {
+	n, _ := s.writeResponse(cc, header, body, mu, counter)
+	return n
+}
+
+// writeResponse is to write response and return its size in bytes and the write error
+func (s *Server) writeResponse(cc codec.Codec, header *codec.Header, body codec.Body, mu *sync.Mutex, counter *countingConn) (uint64, error) {
 	mu.Lock()
 	defer mu.Unlock()
 
-	if err := cc.Write(header, body); err != nil {
-		log.Printf("server: failed to send response, err: %v\n", err)
+	writtenBefore := atomic.LoadUint64(&counter.written)
+	err := cc.Write(header, body)
+	if err != nil {
+		s.getLogger().Log(logger.Error, "server: failed to send response", logger.Method(header.ServiceMethod), logger.Seq(header.SequenceNumber),
+			logger.Peer(counter.peer.Addr), logger.Err(err))
+		s.codecErrors.Add("write", 1)
 	}
+	return atomic.LoadUint64(&counter.written) - writtenBefore, err
 }
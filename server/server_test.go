@@ -0,0 +1,166 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"gingle-rpc/client"
+	"gingle-rpc/codec"
+	"gingle-rpc/status"
+	"gingle-rpc/trace"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Slow is the service of tests replying after a delay, or failing
+type Slow struct{}
+
+func (Slow) Sleep(ctx context.Context, d time.Duration, reply *int) error {
+	time.Sleep(d)
+	return nil
+}
+
+func (Slow) Fail(args int, reply *int) error {
+	return errors.New("failed")
+}
+
+// Traced is the service of tests replying the span context carried by the context of handler
+type Traced struct{}
+
+func (Traced) Traceparent(ctx context.Context, args int, reply *string) error {
+	if sc, ok := trace.SpanContextFromContext(ctx); ok {
+		*reply = sc.Traceparent()
+	}
+	return nil
+}
+
+// startTestServer is to serve services on a local listener, and return the server and its address
+func startTestServer(t *testing.T, services ...interface{}) (*Server, string) {
+	s := NewServer()
+	for _, svc := range services {
+		if err := s.RegisterService(svc); err != nil {
+			t.Fatalf("register: %v", err)
+		}
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = lis.Close()
+	})
+	go s.Accept(lis)
+	return s, lis.Addr().String()
+}
+
+func dialTestServer(t *testing.T, addr string, opts ...*codec.Option) *client.Client {
+	c, err := client.DialRPC("tcp", addr, opts...)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = c.Close()
+	})
+	return c
+}
+
+func TestHandleErrorRecordedOnce(t *testing.T) {
+	tests := []struct {
+		name          string
+		serviceMethod string
+		args          interface{}
+		wantCode      status.Code
+		wantKinds     map[string]uint64
+	}{
+		{"handler error", "Slow.Fail", 1, status.Unknown, map[string]uint64{"*errors.errorString": 1}},
+		{"timeout before late handler", "Slow.Sleep", 200 * time.Millisecond, status.DeadlineExceeded, map[string]uint64{"DeadlineExceeded": 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, addr := startTestServer(t, Slow{})
+			opt := *codec.DefaultOption
+			opt.HandleTimeout = 50 * time.Millisecond
+			c := dialTestServer(t, addr, &opt)
+
+			var reply int
+			err := c.Call(context.Background(), tt.serviceMethod, tt.args, &reply)
+			if status.CodeOf(err) != tt.wantCode {
+				t.Fatalf("err = %v, want %s", err, tt.wantCode)
+			}
+
+			// the late handler returns without counting its own outcome
+			_, rpcMethod, _ := s.RetrieveService(tt.serviceMethod)
+			time.Sleep(300 * time.Millisecond)
+			stats := rpcMethod.Stats()
+			if stats.ErrorTimes != 1 || !reflect.DeepEqual(stats.ErrorKinds, tt.wantKinds) {
+				t.Fatalf("errors = %d of %v, want 1 of %v", stats.ErrorTimes, stats.ErrorKinds, tt.wantKinds)
+			}
+		})
+	}
+}
+
+func TestTraceparentPropagated(t *testing.T) {
+	const traceparent = "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+	parent, _ := trace.ParseTraceparent(traceparent)
+
+	tests := []struct {
+		name       string
+		traced     bool
+		wantParent bool
+	}{
+		{"without tracer", false, true},
+		{"with tracer", true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, addr := startTestServer(t, Traced{})
+			if tt.traced {
+				s.SetTracer(trace.NewTracer(trace.NewMemoryExporter(), 1))
+			}
+			c := dialTestServer(t, addr)
+
+			var reply string
+			ctx := trace.ContextWithSpanContext(context.Background(), parent)
+			if err := c.Call(ctx, "Traced.Traceparent", 0, &reply); err != nil {
+				t.Fatalf("call: %v", err)
+			}
+			// the handler sees the caller span itself, or the server span of the same trace as its child
+			got, err := trace.ParseTraceparent(reply)
+			if err != nil || got.TraceID != parent.TraceID || (got.SpanID == parent.SpanID) != tt.wantParent {
+				t.Fatalf("traceparent = %q, err: %v, want the trace of %q", reply, err, traceparent)
+			}
+		})
+	}
+}
+
+func TestGoDoneChannel(t *testing.T) {
+	tests := []struct {
+		name     string
+		done     chan *client.Call
+		wantCode status.Code
+	}{
+		{"default done channel", nil, status.OK},
+		{"buffered done channel", make(chan *client.Call, 1), status.OK},
+		{"unbuffered done channel", make(chan *client.Call), status.InvalidArgument},
+	}
+
+	_, addr := startTestServer(t, Slow{})
+	c := dialTestServer(t, addr)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var reply int
+			call := c.Go("Slow.Sleep", time.Duration(0), &reply, tt.done)
+			select {
+			case <-call.Done:
+			case <-time.After(time.Second):
+				t.Fatalf("call not done")
+			}
+			if status.CodeOf(call.Error) != tt.wantCode {
+				t.Fatalf("err = %v, want %s", call.Error, tt.wantCode)
+			}
+		})
+	}
+}
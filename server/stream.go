@@ -0,0 +1,237 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"gingle-rpc/codec"
+	"gingle-rpc/flowcontrol"
+	"gingle-rpc/logger"
+	"gingle-rpc/service"
+	"gingle-rpc/status"
+	"gingle-rpc/trace"
+	"io"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// serverStream includes context, codec, call, write mutex, connection, send window, received args queue,
+// streams of connection and bytes sent of a streaming call
+type serverStream struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	server  *Server
+	cc      codec.Codec
+	opt     *codec.Option
+	call    *Call
+	mu      *sync.Mutex
+	counter *countingConn
+
+	window  *flowcontrol.Window
+	queue   *flowcontrol.Queue
+	streams *streamTable
+
+	sentBytes uint64
+}
+
+var _ service.Stream = (*serverStream)(nil)
+
+// streamTable includes streams of a connection by stream id
+type streamTable struct {
+	streams map[uint64]*serverStream
+	mu      sync.Mutex
+}
+
+func newStreamTable() *streamTable {
+	return &streamTable{streams: make(map[uint64]*serverStream)}
+}
+
+func (t *streamTable) get(id uint64) *serverStream {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.streams[id]
+}
+
+func (t *streamTable) remove(id uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.streams, id)
+}
+
+// cancelAll is to cancel all streams once the connection is gone
+func (t *streamTable) cancelAll() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, stream := range t.streams {
+		stream.abort(status.Errorf(status.Unavailable, "server: failed to receive stream, err: connection closed"))
+	}
+}
+
+// openStream is to register the stream of call before its handler runs, so that frames following the request are not lost
+func (s *Server) openStream(ctx context.Context, cc codec.Codec, opt *codec.Option, call *Call, mu *sync.Mutex, counter *countingConn, streams *streamTable) *serverStream {
+	ctx, cancel := context.WithCancel(ctx)
+	stream := &serverStream{
+		ctx:     ctx,
+		cancel:  cancel,
+		server:  s,
+		cc:      cc,
+		opt:     opt,
+		call:    call,
+		mu:      mu,
+		counter: counter,
+		window:  flowcontrol.NewWindow(flowcontrol.DefaultWindow),
+		queue:   flowcontrol.NewQueue(flowcontrol.DefaultWindow),
+		streams: streams,
+	}
+	call.Reply = reflect.ValueOf(stream)
+
+	streams.mu.Lock()
+	streams.streams[call.Header.SequenceNumber] = stream
+	streams.mu.Unlock()
+	return stream
+}
+
+// abort is to end receiving with err and cancel the handler, args not received yet are dropped
+func (s *serverStream) abort(err error) {
+	s.queue.Abort(err)
+	s.window.Close(err)
+	s.cancel()
+}
+
+// Context is to get context of the call, which is done when the call times out or the client cancels the stream
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+// Send is to send one reply as a stream frame, waiting while the client has not granted window for it
+func (s *serverStream) Send(reply interface{}) error {
+	if err := s.ctx.Err(); err != nil {
+		return status.Errorf(status.CodeOf(err), "server: failed to send stream, err: %v", err)
+	}
+
+	marshal := codec.MarshalFuncMap[s.opt.CodecType]
+	if marshal == nil {
+		return status.Errorf(status.Unimplemented, "server: failed to send stream, err: codec %s does not support streams", s.opt.CodecType)
+	}
+	message, err := marshal(reply)
+	if err != nil {
+		return status.Errorf(status.Internal, "server: failed to marshal stream message, err: %v", err)
+	}
+	if err := s.window.Acquire(s.ctx, message); err != nil {
+		if errors.Is(err, flowcontrol.ErrTooLarge) {
+			return status.Errorf(status.ResourceExhausted, "server: failed to send stream, err: %v", err)
+		}
+		return status.Errorf(status.CodeOf(err), "server: failed to send stream, err: %v", err)
+	}
+
+	n, err := s.writeFrame(codec.FlagStream, 0, message)
+	atomic.AddUint64(&s.sentBytes, n)
+	if err != nil {
+		return status.Errorf(status.Unavailable, "server: failed to send stream, err: %v", err)
+	}
+	return nil
+}
+
+// Recv is to wait for the next args from the client and copy it into args, io.EOF after the client half closes the stream,
+// window is granted back to the client as args are consumed
+func (s *serverStream) Recv(args interface{}) error {
+	message, grant, err := s.queue.Pop(s.ctx)
+	if err == io.EOF {
+		return err
+	}
+	if err != nil {
+		return status.Errorf(status.CodeOf(err), "server: failed to receive stream, err: %v", err)
+	}
+
+	if grant > 0 {
+		n, _ := s.writeFrame(codec.FlagStream|codec.FlagWindowUpdate, uint32(grant), struct{}{})
+		atomic.AddUint64(&s.sentBytes, n)
+	}
+
+	unmarshal := codec.UnmarshalFuncMap[s.opt.CodecType]
+	if unmarshal == nil {
+		return status.Errorf(status.Unimplemented, "server: failed to receive stream, err: codec %s does not support streams", s.opt.CodecType)
+	}
+	if err := unmarshal(message, args); err != nil {
+		return status.Errorf(status.InvalidArgument, "server: failed to unmarshal stream message, err: %v", err)
+	}
+	return nil
+}
+
+func (s *serverStream) writeFrame(flags uint8, window uint32, body codec.Body) (uint64, error) {
+	header := &codec.Header{
+		ServiceMethod:  s.call.Header.ServiceMethod,
+		SequenceNumber: s.call.Header.SequenceNumber,
+		StreamID:       s.call.Header.SequenceNumber,
+		Flags:          flags,
+		Window:         window,
+	}
+	return s.server.writeResponse(s.cc, header, body, s.mu, s.counter)
+}
+
+// readStreamFrame is to dispatch a stream frame from the client to its stream without blocking the connection,
+// frames of unknown streams are dropped and a client exceeding the window fails its stream
+func (s *Server) readStreamFrame(cc codec.Codec, header *codec.Header, streams *streamTable, counter *countingConn) error {
+	stream := streams.get(header.StreamID)
+
+	var message []byte
+	var body interface{}
+	if header.Flags&(codec.FlagEndStream|codec.FlagWindowUpdate|codec.FlagReset) == 0 {
+		body = &message
+	}
+	if err := cc.ReadBody(body); err != nil {
+		s.getLogger().Log(logger.Error, "server: failed to read stream frame", logger.Method(header.ServiceMethod), logger.Seq(header.StreamID),
+			logger.Peer(counter.peer.Addr), logger.Err(err))
+		s.codecErrors.Add("read_body", 1)
+		return err
+	}
+	if stream == nil {
+		return nil
+	}
+
+	switch {
+	case header.Flags&codec.FlagReset != 0:
+		stream.abort(status.Errorf(status.Canceled, "server: failed to receive stream, err: stream canceled by client"))
+	case header.Flags&codec.FlagWindowUpdate != 0:
+		stream.window.Grant(int(header.Window))
+	case header.Flags&codec.FlagEndStream != 0:
+		stream.queue.Close(io.EOF)
+	default:
+		if err := stream.queue.Push(message); err != nil {
+			s.getLogger().Log(logger.Warn, "server: failed to queue stream message", logger.Method(header.ServiceMethod), logger.Seq(header.StreamID),
+				logger.Peer(counter.peer.Addr), logger.Err(err))
+			stream.abort(status.Errorf(status.ResourceExhausted, "server: failed to receive stream, err: %v", err))
+		}
+	}
+	return nil
+}
+
+// serveStream is to call the streaming method, whose replies are sent by stream frames until the end frame
+func (s *Server) serveStream(ctx context.Context, cc codec.Codec, opt *codec.Option, call *Call, mu *sync.Mutex, counter *countingConn, span *trace.Span) {
+	stream := call.Reply.Interface().(*serverStream)
+	stream.ctx = ctx
+	defer func() {
+		stream.streams.remove(call.Header.SequenceNumber)
+		stream.abort(status.Errorf(status.Canceled, "server: failed to receive stream, err: stream ended"))
+	}()
+
+	startAt := time.Now()
+	err := s.handle(ctx, call)
+	if err == nil && ctx.Err() != nil {
+		err = status.Errorf(status.CodeOf(ctx.Err()), "server: failed to handle, err: %v", ctx.Err())
+	}
+	finishCall(call, span, err)
+	s.getLogger().Log(logger.Debug, "server: handled stream", logger.Method(call.Header.ServiceMethod), logger.Seq(call.Header.SequenceNumber),
+		logger.Peer(counter.peer.Addr), logger.Latency(time.Since(startAt)), logger.Err(err))
+
+	call.Header.StreamID = call.Header.SequenceNumber
+	call.Header.Flags = codec.FlagStream | codec.FlagEndStream
+	call.Header.SetError(err)
+	responseBytes := atomic.LoadUint64(&stream.sentBytes) + s.sendResponse(cc, call.Header, struct{}{}, mu, counter)
+	call.RpcMethod.RecordResponse(responseBytes)
+	s.logCall(call, opt, counter, responseBytes)
+}
@@ -0,0 +1,207 @@
+package server
+
+import (
+	"context"
+	"gingle-rpc/flowcontrol"
+	"gingle-rpc/service"
+	"gingle-rpc/status"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Streamer is the service of tests streaming numbers, echoing messages and telling when its streams are canceled
+type Streamer struct {
+	canceled chan error
+}
+
+func (s *Streamer) Count(n int, stream service.ServerStream) error {
+	for i := 0; i < n; i++ {
+		if err := stream.Send(i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Streamer) Echo(stream service.Stream) error {
+	for {
+		var message string
+		err := stream.Recv(&message)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(message); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Streamer) Forever(n int, stream service.ServerStream) error {
+	for i := 0; ; i++ {
+		if err := stream.Send(i); err != nil {
+			s.canceled <- stream.Context().Err()
+			return err
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func (s *Streamer) Large(size int, stream service.ServerStream) error {
+	return stream.Send(strings.Repeat("x", size))
+}
+
+func TestServerStreamEOF(t *testing.T) {
+	tests := []struct {
+		name string
+		n    int
+	}{
+		{"empty stream", 0},
+		{"three replies", 3},
+	}
+
+	_, addr := startTestServer(t, &Streamer{})
+	c := dialTestServer(t, addr)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream, err := c.NewServerStream(context.Background(), "Streamer.Count", tt.n)
+			if err != nil {
+				t.Fatalf("new stream: %v", err)
+			}
+			for i := 0; i < tt.n; i++ {
+				var reply int
+				if err := stream.Recv(&reply); err != nil || reply != i {
+					t.Fatalf("reply = %d, err: %v, want %d", reply, err, i)
+				}
+			}
+			var reply int
+			if err := stream.Recv(&reply); err != io.EOF {
+				t.Fatalf("err = %v, want EOF", err)
+			}
+		})
+	}
+}
+
+func TestBidirectionalStream(t *testing.T) {
+	_, addr := startTestServer(t, &Streamer{})
+	c := dialTestServer(t, addr)
+
+	stream, err := c.NewStream(context.Background(), "Streamer.Echo")
+	if err != nil {
+		t.Fatalf("new stream: %v", err)
+	}
+	messages := []string{"a", "b", "c"}
+	for _, message := range messages {
+		if err := stream.Send(message); err != nil {
+			t.Fatalf("send: %v", err)
+		}
+	}
+	if err := stream.CloseSend(); err != nil {
+		t.Fatalf("close send: %v", err)
+	}
+	if err := stream.Send("d"); status.CodeOf(err) != status.FailedPrecondition {
+		t.Fatalf("send after close err = %v, want failed precondition", err)
+	}
+
+	// replies are still received after half closing, until the handler returns on EOF
+	for _, want := range messages {
+		var reply string
+		if err := stream.Recv(&reply); err != nil || reply != want {
+			t.Fatalf("reply = %q, err: %v, want %q", reply, err, want)
+		}
+	}
+	var reply string
+	if err := stream.Recv(&reply); err != io.EOF {
+		t.Fatalf("err = %v, want EOF", err)
+	}
+}
+
+func TestStreamCancel(t *testing.T) {
+	tests := []struct {
+		name   string
+		cancel func(cancel context.CancelFunc, close func() error)
+	}{
+		{"context canceled", func(cancel context.CancelFunc, close func() error) { cancel() }},
+		{"stream closed", func(cancel context.CancelFunc, close func() error) { _ = close() }},
+	}
+
+	streamer := &Streamer{canceled: make(chan error, 1)}
+	_, addr := startTestServer(t, streamer)
+	c := dialTestServer(t, addr)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			stream, err := c.NewServerStream(ctx, "Streamer.Forever", 0)
+			if err != nil {
+				t.Fatalf("new stream: %v", err)
+			}
+			var reply int
+			if err := stream.Recv(&reply); err != nil {
+				t.Fatalf("recv: %v", err)
+			}
+
+			// the reset reaches the handler, whose context is done mid-stream
+			tt.cancel(cancel, stream.Close)
+			select {
+			case err := <-streamer.canceled:
+				if err != context.Canceled {
+					t.Fatalf("handler context err = %v, want canceled", err)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("handler not canceled")
+			}
+			for {
+				if err := stream.Recv(&reply); err != nil {
+					if status.CodeOf(err) != status.Canceled {
+						t.Fatalf("err = %v, want canceled", err)
+					}
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestStreamTooLarge(t *testing.T) {
+	large := flowcontrol.DefaultWindow + 1
+
+	_, addr := startTestServer(t, &Streamer{})
+	c := dialTestServer(t, addr)
+
+	t.Run("sent by server", func(t *testing.T) {
+		stream, err := c.NewServerStream(context.Background(), "Streamer.Large", large)
+		if err != nil {
+			t.Fatalf("new stream: %v", err)
+		}
+		var reply string
+		if err := stream.Recv(&reply); status.CodeOf(err) != status.ResourceExhausted {
+			t.Fatalf("err = %v, want resource exhausted", err)
+		}
+	})
+
+	t.Run("sent by client", func(t *testing.T) {
+		stream, err := c.NewStream(context.Background(), "Streamer.Echo")
+		if err != nil {
+			t.Fatalf("new stream: %v", err)
+		}
+		defer func() {
+			_ = stream.Close()
+		}()
+		if err := stream.Send(strings.Repeat("x", large)); status.CodeOf(err) != status.ResourceExhausted {
+			t.Fatalf("err = %v, want resource exhausted", err)
+		}
+		// the stream is still usable for messages within the window
+		if err := stream.Send("small"); err != nil {
+			t.Fatalf("send: %v", err)
+		}
+		var reply string
+		if err := stream.Recv(&reply); err != nil || reply != "small" {
+			t.Fatalf("reply = %q, err: %v", reply, err)
+		}
+	})
+}
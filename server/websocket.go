@@ -0,0 +1,25 @@
+package server
+
+import (
+	"gingle-rpc/logger"
+	"gingle-rpc/websocket"
+	"net/http"
+)
+
+// WebSocketServer includes server and the origin check of browsers, nil means only the same host, it serves rpc over
+// websocket with one frame per message
+type WebSocketServer struct {
+	*Server
+
+	CheckOrigin func(r *http.Request) bool
+}
+
+// ServeHTTP is to upgrade http to websocket and serve rpc over it
+func (s *WebSocketServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := websocket.Upgrade(w, r, s.CheckOrigin)
+	if err != nil {
+		s.getLogger().Log(logger.Warn, "server: failed to upgrade websocket", logger.Peer(r.RemoteAddr), logger.Err(err))
+		return
+	}
+	s.ServeConn(conn)
+}
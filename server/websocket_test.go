@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"gingle-rpc/client"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// writeCountingListener includes listener whose connections count their writes
+type writeCountingListener struct {
+	net.Listener
+	writes int64
+}
+
+func (l *writeCountingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &writeCountingConn{Conn: conn, writes: &l.writes}, nil
+}
+
+// writeCountingConn includes connection and the write count shared by its listener
+type writeCountingConn struct {
+	net.Conn
+	writes *int64
+}
+
+func (c *writeCountingConn) Write(p []byte) (int, error) {
+	atomic.AddInt64(c.writes, 1)
+	return c.Conn.Write(p)
+}
+
+func TestWebSocketServer(t *testing.T) {
+	s, _ := startTestServer(t, &Recorder{}, &Streamer{})
+	mux := http.NewServeMux()
+	mux.Handle(defaultWSPath, &WebSocketServer{Server: s})
+	mux.Handle("/other/ws", &WebSocketServer{Server: s})
+	ts := httptest.NewUnstartedServer(mux)
+	lis := &writeCountingListener{Listener: ts.Listener}
+	ts.Listener = lis
+	ts.Start()
+	defer ts.Close()
+	addr := strings.TrimPrefix(ts.URL, "http://")
+
+	tests := []struct {
+		name    string
+		pattern string
+	}{
+		{"default path", "ws@" + addr},
+		{"path", "ws@" + addr + "/other/ws"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := client.XDial(tt.pattern)
+			if err != nil {
+				t.Fatalf("dial: %v", err)
+			}
+			defer func() {
+				_ = c.Close()
+			}()
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			var reply string
+			if err := c.Call(ctx, "Recorder.Echo", "warm", &reply); err != nil {
+				t.Fatalf("call: %v", err)
+			}
+
+			// each response is one frame written in one websocket message, however large
+			for _, size := range []int{1, 1 << 10, 1 << 20} {
+				before := atomic.LoadInt64(&lis.writes)
+				args := strings.Repeat("x", size)
+				if err := c.Call(ctx, "Recorder.Echo", args, &reply); err != nil || reply != args {
+					t.Fatalf("call of %d bytes: %d bytes replied, err: %v", size, len(reply), err)
+				}
+				if writes := atomic.LoadInt64(&lis.writes) - before; writes != 1 {
+					t.Fatalf("response of %d bytes written in %d writes, want 1", size, writes)
+				}
+			}
+
+			stream, err := c.NewServerStream(ctx, "Streamer.Count", 3)
+			if err != nil {
+				t.Fatalf("new stream: %v", err)
+			}
+			for i := 0; i < 3; i++ {
+				var n int
+				if err := stream.Recv(&n); err != nil || n != i {
+					t.Fatalf("recv = %d, err: %v, want %d", n, err, i)
+				}
+			}
+		})
+	}
+}
+
+func TestWebSocketServerRejectsPlainHTTP(t *testing.T) {
+	s, _ := startTestServer(t)
+	ts := httptest.NewServer(&WebSocketServer{Server: s})
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	_ = res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("http status = %d, want 400", res.StatusCode)
+	}
+}
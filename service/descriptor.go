@@ -0,0 +1,152 @@
+package service
+
+import (
+	"go/ast"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// ServiceDescriptor includes name, methods and all types referenced by methods
+type ServiceDescriptor struct {
+	Name    string
+	Methods []MethodDescriptor
+	Types   []TypeDescriptor
+}
+
+// MethodDescriptor includes name, args type name, reply type name, whether it is one way, streams args or replies and call times,
+// args type name is empty for bidirectional streaming method and reply type name is empty for one way method
+type MethodDescriptor struct {
+	Name            string
+	ArgsType        string
+	ReplyType       string
+	OneWay          bool
+	ClientStreaming bool
+	ServerStreaming bool
+	CallTimes       uint64
+}
+
+// TypeDescriptor includes name, kind, element type, key type, length and fields of a type,
+// nested types are referred by name and described in ServiceDescriptor.Types
+type TypeDescriptor struct {
+	Name   string
+	Kind   string
+	Elem   string // element type of pointer, slice, array, map and chan
+	Key    string // key type of map
+	Len    int    // length of array
+	Fields []FieldDescriptor
+}
+
+// FieldDescriptor includes name, type name, tag and whether it is embedded
+type FieldDescriptor struct {
+	Name     string
+	Type     string
+	Tag      string
+	Embedded bool
+}
+
+// Describe is to describe the service, its methods and the types they reference
+func (s *Service) Describe() *ServiceDescriptor {
+	desc := &ServiceDescriptor{Name: s.Name}
+
+	types := make(map[string]*TypeDescriptor)
+	for name, m := range s.RpcMethods {
+		var argsType, replyType string
+		if m.ArgsType != nil {
+			argsType = describeType(m.ArgsType, types)
+		}
+		if m.ReplyType != nil {
+			replyType = describeType(m.ReplyType, types)
+		}
+		desc.Methods = append(desc.Methods, MethodDescriptor{
+			Name:            name,
+			ArgsType:        argsType,
+			ReplyType:       replyType,
+			OneWay:          m.OneWay,
+			ClientStreaming: m.ClientStreaming,
+			ServerStreaming: m.ServerStreaming,
+			CallTimes:       m.GetCallTimes(),
+		})
+	}
+	sort.Slice(desc.Methods, func(i, j int) bool {
+		return desc.Methods[i].Name < desc.Methods[j].Name
+	})
+
+	for _, t := range types {
+		desc.Types = append(desc.Types, *t)
+	}
+	sort.Slice(desc.Types, func(i, j int) bool {
+		return desc.Types[i].Name < desc.Types[j].Name
+	})
+
+	return desc
+}
+
+// describeType is to describe type and its nested types into the type table, and return the type name
+func describeType(t reflect.Type, types map[string]*TypeDescriptor) string {
+	name := typeName(t)
+	if _, ok := types[name]; ok {
+		return name
+	}
+
+	desc := &TypeDescriptor{
+		Name: name,
+		Kind: t.Kind().String(),
+	}
+	// register before recursion so that recursive types terminate
+	types[name] = desc
+
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Chan:
+		desc.Elem = describeType(t.Elem(), types)
+	case reflect.Array:
+		desc.Elem = describeType(t.Elem(), types)
+		desc.Len = t.Len()
+	case reflect.Map:
+		desc.Key = describeType(t.Key(), types)
+		desc.Elem = describeType(t.Elem(), types)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			// unexported fields are never encoded
+			if !ast.IsExported(field.Name) {
+				continue
+			}
+			desc.Fields = append(desc.Fields, FieldDescriptor{
+				Name:     field.Name,
+				Type:     describeType(field.Type, types),
+				Tag:      string(field.Tag),
+				Embedded: field.Anonymous,
+			})
+		}
+	}
+
+	return name
+}
+
+// typeName is to name type uniquely, named types are qualified by package path so that
+// same-named types of different packages never collide, and composite types are named by their qualified elements
+func typeName(t reflect.Type) string {
+	if t.Name() != "" {
+		if t.PkgPath() == "" {
+			return t.Name()
+		}
+		return t.PkgPath() + "." + t.Name()
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return "*" + typeName(t.Elem())
+	case reflect.Slice:
+		return "[]" + typeName(t.Elem())
+	case reflect.Array:
+		return "[" + strconv.Itoa(t.Len()) + "]" + typeName(t.Elem())
+	case reflect.Map:
+		return "map[" + typeName(t.Key()) + "]" + typeName(t.Elem())
+	case reflect.Chan:
+		return t.ChanDir().String() + " " + typeName(t.Elem())
+	default:
+		// anonymous structs, funcs and interfaces
+		return t.String()
+	}
+}
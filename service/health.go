@@ -0,0 +1,39 @@
+package service
+
+// HealthServiceName is the name the built-in health service is registered under, namespaced to leave "Health" to users
+const HealthServiceName = "gingle.Health"
+
+// HealthStatus is the serving status of a server or one of its services
+type HealthStatus int
+
+const (
+	Unknown HealthStatus = iota
+	Serving
+	NotServing
+	ServiceUnknown
+)
+
+// String is to get the name of health status
+func (st HealthStatus) String() string {
+	switch st {
+	case Serving:
+		return "serving"
+	case NotServing:
+		return "not serving"
+	case ServiceUnknown:
+		return "service unknown"
+	default:
+		return "unknown"
+	}
+}
+
+// HealthArgs includes service name, empty for the whole server, and the last known status used by watch
+type HealthArgs struct {
+	Service string
+	Status  HealthStatus
+}
+
+// HealthReply includes status
+type HealthReply struct {
+	Status HealthStatus
+}
@@ -0,0 +1,100 @@
+package service
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// histogramBounds are upper bounds of latency buckets, growing exponentially from 1us to about 67s
+var histogramBounds = func() []time.Duration {
+	bounds := make([]time.Duration, 0, 27)
+	for d := time.Microsecond; len(bounds) < cap(bounds); d *= 2 {
+		bounds = append(bounds, d)
+	}
+	return bounds
+}()
+
+// Histogram includes total latency and counts of latency buckets
+type Histogram struct {
+	sum    int64
+	counts []uint64 // the last bucket counts latencies beyond all bounds
+}
+
+// NewHistogram is to create latency histogram
+func NewHistogram() *Histogram {
+	return &Histogram{
+		counts: make([]uint64, len(histogramBounds)+1),
+	}
+}
+
+// Observe is to record one latency
+func (h *Histogram) Observe(d time.Duration) {
+	idx := len(histogramBounds)
+	for i, bound := range histogramBounds {
+		if d <= bound {
+			idx = i
+			break
+		}
+	}
+
+	atomic.AddUint64(&h.counts[idx], 1)
+	atomic.AddInt64(&h.sum, int64(d))
+}
+
+// HistogramSnapshot includes bucket bounds and counts, total count and total latency at a moment
+type HistogramSnapshot struct {
+	Bounds []time.Duration
+	Counts []uint64
+	Count  uint64
+	Sum    time.Duration
+}
+
+// Snapshot is to copy the histogram
+func (h *Histogram) Snapshot() *HistogramSnapshot {
+	snapshot := &HistogramSnapshot{
+		Bounds: histogramBounds,
+		Counts: make([]uint64, len(h.counts)),
+	}
+	for i := range h.counts {
+		snapshot.Counts[i] = atomic.LoadUint64(&h.counts[i])
+		snapshot.Count += snapshot.Counts[i]
+	}
+	snapshot.Sum = time.Duration(atomic.LoadInt64(&h.sum))
+	return snapshot
+}
+
+// Percentile is to estimate the latency at quantile q in [0, 1] by interpolating inside its bucket
+func (s *HistogramSnapshot) Percentile(q float64) time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+
+	rank := q * float64(s.Count)
+	var seen float64
+	for i, count := range s.Counts {
+		if count == 0 || seen+float64(count) < rank {
+			seen += float64(count)
+			continue
+		}
+
+		var lower time.Duration
+		if i > 0 {
+			lower = s.Bounds[i-1]
+		}
+		if i == len(s.Bounds) {
+			// beyond all bounds, the lower bound is the best estimation
+			return lower
+		}
+		upper := s.Bounds[i]
+		return lower + time.Duration(float64(upper-lower)*(rank-seen)/float64(count))
+	}
+	return s.Bounds[len(s.Bounds)-1]
+}
+
+// Mean is to get the average latency
+func (s *HistogramSnapshot) Mean() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Sum / time.Duration(s.Count)
+}
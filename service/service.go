@@ -1,22 +1,39 @@
 package service
 
 import (
+	"context"
+	"fmt"
+	"gingle-rpc/logger"
 	"go/ast"
-	"log"
 	"reflect"
 	"sync/atomic"
+	"time"
 )
 
-// RpcMethod includes method, args type, reply type and call times
+// RpcMethod includes method, args type, reply type, whether it takes context, is one way, streams args or streams replies,
+// call times, error times, latency and other stats, args type is nil for bidirectional streaming method
+// and reply type is nil for one way method
 type RpcMethod struct {
-	Method    reflect.Method
-	ArgsType  reflect.Type
-	ReplyType reflect.Type
-	CallTimes uint64
+	Method          reflect.Method
+	ArgsType        reflect.Type
+	ReplyType       reflect.Type
+	WithContext     bool
+	OneWay          bool
+	ClientStreaming bool
+	ServerStreaming bool
+	CallTimes       uint64
+	ErrorTimes      uint64
+	Latency         *Histogram
+
+	stats methodStats
 }
 
-// NewArgsValue is to create args value
+// NewArgsValue is to create args value, which is invalid for bidirectional streaming method
 func (m *RpcMethod) NewArgsValue() reflect.Value {
+	if m.ClientStreaming {
+		return reflect.Value{}
+	}
+
 	var argsValue reflect.Value
 	if m.ArgsType.Kind() == reflect.Ptr {
 		argsValue = reflect.New(m.ArgsType.Elem())
@@ -26,8 +43,12 @@ func (m *RpcMethod) NewArgsValue() reflect.Value {
 	return argsValue
 }
 
-// NewReplyValue is to create reply value
+// NewReplyValue is to create reply value, which is invalid for streaming and one way method
 func (m *RpcMethod) NewReplyValue() reflect.Value {
+	if m.ServerStreaming || m.OneWay {
+		return reflect.Value{}
+	}
+
 	replyValue := reflect.New(m.ReplyType.Elem())
 	switch m.ReplyType.Elem().Kind() {
 	case reflect.Map:
@@ -43,56 +64,172 @@ func (m *RpcMethod) GetCallTimes() uint64 {
 	return atomic.LoadUint64(&m.CallTimes)
 }
 
-// Service includes name, type, instance and rpc methods
+// GetErrorTimes is to get error times
+func (m *RpcMethod) GetErrorTimes() uint64 {
+	return atomic.LoadUint64(&m.ErrorTimes)
+}
+
+// Service includes name, type, instance, rpc methods and logger
 type Service struct {
 	Name       string
 	Type       reflect.Type
 	Instance   reflect.Value
 	RpcMethods map[string]*RpcMethod
+
+	logger logger.Logger
+}
+
+// NewService is create service named by the type of instance, l logs registered methods and nil means the default logger
+func NewService(instance interface{}, l logger.Logger) (*Service, error) {
+	return NewNamedService("", instance, l)
 }
 
-// NewService is create service
-func NewService(instance interface{}) *Service {
+// NewNamedService is to create service under name, which may be qualified like "gingle.Health",
+// or named by the type of instance if name is empty
+func NewNamedService(name string, instance interface{}, l logger.Logger) (*Service, error) {
+	if instance == nil {
+		return nil, fmt.Errorf("service: instance is nil")
+	}
+
 	service := &Service{
-		Name:       reflect.ValueOf(instance).Type().Name(),
+		Name:       reflect.Indirect(reflect.ValueOf(instance)).Type().Name(),
 		Type:       reflect.TypeOf(instance),
 		Instance:   reflect.ValueOf(instance),
 		RpcMethods: make(map[string]*RpcMethod),
+		logger:     logger.OrDefault(l),
 	}
 
-	if !(ast.IsExported(service.Name) || service.Type.PkgPath() == "") {
-		log.Fatalf("service: %s is not exported or built in\n", service.Name)
+	if service.Name == "" {
+		return nil, fmt.Errorf("service: %s is not a named type", service.Type)
+	}
+	if !(ast.IsExported(service.Name) || reflect.Indirect(service.Instance).Type().PkgPath() == "") {
+		return nil, fmt.Errorf("service: %s is not exported or built in", service.Name)
+	}
+	if name != "" {
+		service.Name = name
 	}
 
 	service.RegisterMethods()
 
-	return service
+	return service, nil
 }
 
-// RegisterMethods is to register methods to service map
+// ServerStream is to send many replies of a server streaming method, whose context is done when the call is canceled
+type ServerStream interface {
+	Context() context.Context
+	Send(reply interface{}) error
+}
+
+// Stream is to receive many args and send many replies of a bidirectional streaming method,
+// Recv returns io.EOF after the client half closes the stream
+type Stream interface {
+	ServerStream
+	Recv(args interface{}) error
+}
+
+// OneWay is the marker taking the place of reply to opt in one way methods like func (t *T) M(args A, _ OneWay) error
+type OneWay struct{}
+
+var (
+	typeOfContext      = reflect.TypeOf((*context.Context)(nil)).Elem()
+	typeOfError        = reflect.TypeOf((*error)(nil)).Elem()
+	typeOfServerStream = reflect.TypeOf((*ServerStream)(nil)).Elem()
+	typeOfStream       = reflect.TypeOf((*Stream)(nil)).Elem()
+	typeOfOneWay       = reflect.TypeOf(OneWay{})
+)
+
+// RegisterMethods is to register methods like func (t *T) M(args A, reply *R) error
+// or func (t *T) M(ctx context.Context, args A, reply *R) error to service map,
+// server streaming methods like func (t *T) M(args A, stream ServerStream) error
+// bidirectional streaming methods like func (t *T) M(stream Stream) error, client streaming methods are bidirectional
+// ones sending one reply, and one way methods like func (t *T) M(args A, _ OneWay) error
 func (s *Service) RegisterMethods() {
 	for i := 0; i < s.Type.NumMethod(); i++ {
 		method := s.Type.Method(i)
 		methodType := method.Type
-		argsType, replyType := methodType.In(1), methodType.In(2)
+		if s.registerStreamMethod(method) || s.registerOneWayMethod(method) {
+			continue
+		}
+
+		withContext := methodType.NumIn() == 4 && methodType.In(1) == typeOfContext
+		if !(methodType.NumIn() == 3 || withContext) || methodType.NumOut() != 1 {
+			continue
+		}
+		argsType, replyType := methodType.In(methodType.NumIn()-2), methodType.In(methodType.NumIn()-1)
 
 		if !s.checkRpcMethodFormat(methodType, argsType, replyType) {
 			continue
 		}
 
 		s.RpcMethods[method.Name] = &RpcMethod{
-			Method:    method,
-			ArgsType:  argsType,
-			ReplyType: argsType,
-			CallTimes: 0,
+			Method:          method,
+			ArgsType:        argsType,
+			ReplyType:       replyType,
+			WithContext:     withContext,
+			ServerStreaming: replyType == typeOfServerStream,
+			CallTimes:       0,
+			Latency:         NewHistogram(),
 		}
 
-		log.Printf("service: register %s.%s\n", s.Name, method.Name)
+		s.logger.Log(logger.Info, "service: register method", logger.Method(s.Name+"."+method.Name))
 	}
 }
 
+// registerStreamMethod is to register bidirectional streaming method like func (t *T) M(stream Stream) error
+// or func (t *T) M(ctx context.Context, stream Stream) error, false if method is not of the form
+func (s *Service) registerStreamMethod(method reflect.Method) bool {
+	methodType := method.Type
+	withContext := methodType.NumIn() == 3 && methodType.In(1) == typeOfContext
+	if !(methodType.NumIn() == 2 || withContext) || methodType.In(methodType.NumIn()-1) != typeOfStream {
+		return false
+	}
+	if methodType.NumOut() != 1 || methodType.Out(0) != typeOfError {
+		return false
+	}
+
+	s.RpcMethods[method.Name] = &RpcMethod{
+		Method:          method,
+		ReplyType:       typeOfStream,
+		WithContext:     withContext,
+		ClientStreaming: true,
+		ServerStreaming: true,
+		Latency:         NewHistogram(),
+	}
+
+	s.logger.Log(logger.Info, "service: register stream method", logger.Method(s.Name+"."+method.Name))
+	return true
+}
+
+// registerOneWayMethod is to register one way method opted in by the OneWay marker like func (t *T) M(args A, _ OneWay) error
+// or func (t *T) M(ctx context.Context, args A, _ OneWay) error, whose error is only logged by server, false if method is not of the form
+func (s *Service) registerOneWayMethod(method reflect.Method) bool {
+	methodType := method.Type
+	withContext := methodType.NumIn() == 4 && methodType.In(1) == typeOfContext
+	if !(methodType.NumIn() == 3 || withContext) || methodType.In(methodType.NumIn()-1) != typeOfOneWay {
+		return false
+	}
+	if methodType.NumOut() != 1 || methodType.Out(0) != typeOfError {
+		return false
+	}
+	argsType := methodType.In(methodType.NumIn() - 2)
+	if !(ast.IsExported(argsType.Name()) || argsType.PkgPath() == "") {
+		return false
+	}
+
+	s.RpcMethods[method.Name] = &RpcMethod{
+		Method:      method,
+		ArgsType:    argsType,
+		WithContext: withContext,
+		OneWay:      true,
+		Latency:     NewHistogram(),
+	}
+
+	s.logger.Log(logger.Info, "service: register one way method", logger.Method(s.Name+"."+method.Name))
+	return true
+}
+
 func (s *Service) checkRpcMethodFormat(methodType, argsType, replyType reflect.Type) bool {
-	if methodType.NumIn() != 3 || methodType.NumOut() != 1 {
+	if methodType.NumOut() != 1 || !(replyType.Kind() == reflect.Ptr || replyType == typeOfServerStream) {
 		return false
 	}
 
@@ -103,19 +240,41 @@ func (s *Service) checkRpcMethodFormat(methodType, argsType, replyType reflect.T
 		return false
 	}
 
-	if methodType.Out(0) != reflect.TypeOf((*error)(nil)).Elem() {
+	if methodType.Out(0) != typeOfError {
 		return false
 	}
 
 	return true
 }
 
-// CallMethod is to call the method from service map
-func (s *Service) CallMethod(rpcMethod *RpcMethod, argsValue, replyValue reflect.Value) error {
+// CallMethod is to call the method from service map, ctx is passed to methods taking context,
+// replyValue is the stream of streaming methods and ignored by one way methods, and argsValue is ignored by bidirectional
+// streaming methods, the error is counted by the caller which knows whether it is the outcome replied
+func (s *Service) CallMethod(ctx context.Context, rpcMethod *RpcMethod, argsValue, replyValue reflect.Value) error {
 	atomic.AddUint64(&rpcMethod.CallTimes, 1)
+	atomic.AddInt64(&rpcMethod.stats.inFlight, 1)
+	defer atomic.AddInt64(&rpcMethod.stats.inFlight, -1)
 
+	startAt := time.Now()
 	fn := rpcMethod.Method.Func
-	returnValues := fn.Call([]reflect.Value{s.Instance, argsValue, replyValue})
+	in := []reflect.Value{s.Instance}
+	if rpcMethod.WithContext {
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		in = append(in, reflect.ValueOf(ctx))
+	}
+	if !rpcMethod.ClientStreaming {
+		in = append(in, argsValue)
+	}
+	if rpcMethod.OneWay {
+		in = append(in, reflect.ValueOf(OneWay{}))
+	} else {
+		in = append(in, replyValue)
+	}
+	returnValues := fn.Call(in)
+	rpcMethod.Latency.Observe(time.Since(startAt))
+
 	if errInterface := returnValues[0].Interface(); errInterface != nil {
 		return errInterface.(error)
 	}
@@ -0,0 +1,90 @@
+package service
+
+import (
+	"errors"
+	"gingle-rpc/status"
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+// methodStats includes in flight calls, request and response bytes and error times by kind of one rpc method
+type methodStats struct {
+	inFlight      int64
+	requests      uint64
+	requestBytes  uint64
+	responses     uint64
+	responseBytes uint64
+
+	errorKinds sync.Map // kind -> *uint64
+}
+
+// MethodStats includes all metrics of one rpc method at a moment, which is read by debug page and metrics exporters
+type MethodStats struct {
+	CallTimes     uint64
+	ErrorTimes    uint64
+	ErrorKinds    map[string]uint64
+	InFlight      int64
+	Requests      uint64
+	RequestBytes  uint64
+	Responses     uint64
+	ResponseBytes uint64
+	Latency       *HistogramSnapshot
+}
+
+// RecordError is to count one error of the kind
+func (m *RpcMethod) RecordError(kind string) {
+	atomic.AddUint64(&m.ErrorTimes, 1)
+
+	countInterface, ok := m.stats.errorKinds.Load(kind)
+	if !ok {
+		countInterface, _ = m.stats.errorKinds.LoadOrStore(kind, new(uint64))
+	}
+	atomic.AddUint64(countInterface.(*uint64), 1)
+}
+
+// RecordRequest is to count one request of size bytes
+func (m *RpcMethod) RecordRequest(size uint64) {
+	atomic.AddUint64(&m.stats.requests, 1)
+	atomic.AddUint64(&m.stats.requestBytes, size)
+}
+
+// RecordResponse is to count one response of size bytes
+func (m *RpcMethod) RecordResponse(size uint64) {
+	atomic.AddUint64(&m.stats.responses, 1)
+	atomic.AddUint64(&m.stats.responseBytes, size)
+}
+
+// GetInFlight is to get the number of calls being handled
+func (m *RpcMethod) GetInFlight() int64 {
+	return atomic.LoadInt64(&m.stats.inFlight)
+}
+
+// Stats is to get all metrics of the rpc method
+func (m *RpcMethod) Stats() *MethodStats {
+	stats := &MethodStats{
+		CallTimes:     m.GetCallTimes(),
+		ErrorTimes:    m.GetErrorTimes(),
+		ErrorKinds:    make(map[string]uint64),
+		InFlight:      m.GetInFlight(),
+		Requests:      atomic.LoadUint64(&m.stats.requests),
+		RequestBytes:  atomic.LoadUint64(&m.stats.requestBytes),
+		Responses:     atomic.LoadUint64(&m.stats.responses),
+		ResponseBytes: atomic.LoadUint64(&m.stats.responseBytes),
+		Latency:       m.Latency.Snapshot(),
+	}
+	m.stats.errorKinds.Range(func(kindInterface, countInterface interface{}) bool {
+		stats.ErrorKinds[kindInterface.(string)] = atomic.LoadUint64(countInterface.(*uint64))
+		return true
+	})
+	return stats
+}
+
+// ErrorKind is to name the kind of error by its status code, or by its type if it has no code
+func ErrorKind(err error) string {
+	var st *status.Error
+	if errors.As(err, &st) {
+		return st.Code.String()
+	}
+	return reflect.TypeOf(err).String()
+}
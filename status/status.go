@@ -0,0 +1,166 @@
+package status
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// Code classifies errors crossing the wire, numbered the same as grpc codes
+type Code uint32
+
+const (
+	OK Code = iota
+	Canceled
+	Unknown
+	InvalidArgument
+	DeadlineExceeded
+	NotFound
+	AlreadyExists
+	PermissionDenied
+	ResourceExhausted
+	FailedPrecondition
+	Aborted
+	OutOfRange
+	Unimplemented
+	Internal
+	Unavailable
+	DataLoss
+	Unauthenticated
+)
+
+var codeNames = [...]string{
+	OK:                 "OK",
+	Canceled:           "Canceled",
+	Unknown:            "Unknown",
+	InvalidArgument:    "InvalidArgument",
+	DeadlineExceeded:   "DeadlineExceeded",
+	NotFound:           "NotFound",
+	AlreadyExists:      "AlreadyExists",
+	PermissionDenied:   "PermissionDenied",
+	ResourceExhausted:  "ResourceExhausted",
+	FailedPrecondition: "FailedPrecondition",
+	Aborted:            "Aborted",
+	OutOfRange:         "OutOfRange",
+	Unimplemented:      "Unimplemented",
+	Internal:           "Internal",
+	Unavailable:        "Unavailable",
+	DataLoss:           "DataLoss",
+	Unauthenticated:    "Unauthenticated",
+}
+
+// String is to get name of code
+func (c Code) String() string {
+	if int(c) < len(codeNames) {
+		return codeNames[c]
+	}
+	return "Code(" + strconv.FormatUint(uint64(c), 10) + ")"
+}
+
+// statusClientClosedRequest is the non-standard http status of a request canceled by client
+const statusClientClosedRequest = 499
+
+var httpStatuses = [...]int{
+	OK:                 http.StatusOK,
+	Canceled:           statusClientClosedRequest,
+	Unknown:            http.StatusInternalServerError,
+	InvalidArgument:    http.StatusBadRequest,
+	DeadlineExceeded:   http.StatusGatewayTimeout,
+	NotFound:           http.StatusNotFound,
+	AlreadyExists:      http.StatusConflict,
+	PermissionDenied:   http.StatusForbidden,
+	ResourceExhausted:  http.StatusTooManyRequests,
+	FailedPrecondition: http.StatusBadRequest,
+	Aborted:            http.StatusConflict,
+	OutOfRange:         http.StatusBadRequest,
+	Unimplemented:      http.StatusNotImplemented,
+	Internal:           http.StatusInternalServerError,
+	Unavailable:        http.StatusServiceUnavailable,
+	DataLoss:           http.StatusInternalServerError,
+	Unauthenticated:    http.StatusUnauthorized,
+}
+
+// HTTPStatus is to get the http status of code, unknown codes are internal server errors
+func (c Code) HTTPStatus() int {
+	if int(c) < len(httpStatuses) {
+		return httpStatuses[c]
+	}
+	return http.StatusInternalServerError
+}
+
+// Error includes code, message and optional details of a failed call
+type Error struct {
+	Code    Code
+	Message string
+	Details map[string]string
+}
+
+// New is to create error of code and message
+func New(code Code, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+// Errorf is to create error of code and formatted message
+func Errorf(code Code, format string, args ...interface{}) error {
+	return New(code, fmt.Sprintf(format, args...))
+}
+
+// WithDetails is to attach key value details, which are carried to the client as well
+func (e *Error) WithDetails(details map[string]string) *Error {
+	if e.Details == nil {
+		e.Details = make(map[string]string, len(details))
+	}
+	for key, value := range details {
+		e.Details[key] = value
+	}
+	return e
+}
+
+// Error is to get message, which is the same string as before codes are introduced
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Is is to match target error of the same code, and of the same message if target has one,
+// e.g. errors.Is(err, status.New(status.NotFound, ""))
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	if !ok {
+		return false
+	}
+	return t.Code == e.Code && (t.Message == "" || t.Message == e.Message)
+}
+
+// FromError is to convert err to status error, errors of context are mapped to their codes and others are unknown,
+// nil for nil err
+func FromError(err error) *Error {
+	if err == nil {
+		return nil
+	}
+
+	var e *Error
+	switch {
+	case errors.As(err, &e):
+		if e.Message != err.Error() {
+			// keep the context added by wrapping
+			return &Error{Code: e.Code, Message: err.Error(), Details: e.Details}
+		}
+		return e
+	case errors.Is(err, context.DeadlineExceeded):
+		return New(DeadlineExceeded, err.Error())
+	case errors.Is(err, context.Canceled):
+		return New(Canceled, err.Error())
+	default:
+		return New(Unknown, err.Error())
+	}
+}
+
+// CodeOf is to get code of err, OK for nil err
+func CodeOf(err error) Code {
+	if err == nil {
+		return OK
+	}
+	return FromError(err).Code
+}
@@ -0,0 +1,103 @@
+package trace
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+)
+
+// Exporter is to export finished and sampled spans
+type Exporter interface {
+	Export(span *Span) error
+}
+
+// MemoryExporter includes exported spans kept in memory
+type MemoryExporter struct {
+	spans []Span
+	mu    sync.Mutex
+}
+
+var _ Exporter = (*MemoryExporter)(nil)
+
+// NewMemoryExporter is to create exporter keeping spans in memory
+func NewMemoryExporter() *MemoryExporter {
+	return &MemoryExporter{}
+}
+
+// Export is to keep a copy of span
+func (e *MemoryExporter) Export(span *Span) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.spans = append(e.spans, Span{
+		TraceID:      span.TraceID,
+		SpanID:       span.SpanID,
+		ParentSpanID: span.ParentSpanID,
+		Name:         span.Name,
+		Kind:         span.Kind,
+		Start:        span.Start,
+		End:          span.End,
+		Duration:     span.Duration,
+		Peer:         span.Peer,
+		Error:        span.Error,
+		context:      span.context,
+	})
+	return nil
+}
+
+// Spans is to get all exported spans in export order
+func (e *MemoryExporter) Spans() []Span {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	spans := make([]Span, len(e.spans))
+	copy(spans, e.spans)
+	return spans
+}
+
+// Reset is to drop all exported spans
+func (e *MemoryExporter) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.spans = nil
+}
+
+// FileExporter includes file and encoder writing spans as json lines
+type FileExporter struct {
+	file *os.File
+	enc  *json.Encoder
+	mu   sync.Mutex
+}
+
+var _ Exporter = (*FileExporter)(nil)
+var _ io.Closer = (*FileExporter)(nil)
+
+// NewFileExporter is to create exporter appending spans to file as json lines
+func NewFileExporter(path string) (*FileExporter, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileExporter{
+		file: file,
+		enc:  json.NewEncoder(file),
+	}, nil
+}
+
+// Export is to append span as a json line
+func (e *FileExporter) Export(span *Span) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.enc.Encode(span)
+}
+
+// Close is to close file
+func (e *FileExporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.file.Close()
+}
@@ -0,0 +1,179 @@
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// | traceparent: {version}-{trace id}-{parent span id}-{trace flags} | e.g. 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01 |
+
+const (
+	traceparentVersion = "00"
+	flagSampled        = 0x01
+)
+
+// TraceID identifies a whole trace across services
+type TraceID [16]byte
+
+// SpanID identifies one span inside a trace
+type SpanID [8]byte
+
+// String is to encode trace id as lowercase hex
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// IsValid is to check whether trace id is not all zeros
+func (id TraceID) IsValid() bool {
+	return id != TraceID{}
+}
+
+// String is to encode span id as lowercase hex
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// IsValid is to check whether span id is not all zeros
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+// SpanContext includes trace id, span id and sampling flag propagated between services
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// IsValid is to check whether both trace id and span id are valid
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Traceparent is to encode span context as w3c traceparent
+func (sc SpanContext) Traceparent() string {
+	flags := 0
+	if sc.Sampled {
+		flags |= flagSampled
+	}
+	return fmt.Sprintf("%s-%s-%s-%02x", traceparentVersion, sc.TraceID, sc.SpanID, flags)
+}
+
+// ParseTraceparent is to decode w3c traceparent, fields appended by future versions are ignored
+func ParseTraceparent(traceparent string) (SpanContext, error) {
+	var sc SpanContext
+
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 {
+		return sc, fmt.Errorf("trace: failed to parse traceparent %q, err: too few fields", traceparent)
+	}
+	version, traceID, spanID, flags := parts[0], parts[1], parts[2], parts[3]
+	if len(version) != 2 || !isLowerHex(version) || version == "ff" {
+		return sc, fmt.Errorf("trace: failed to parse traceparent %q, err: invalid version", traceparent)
+	}
+	if version == traceparentVersion && len(parts) != 4 {
+		return sc, fmt.Errorf("trace: failed to parse traceparent %q, err: too many fields", traceparent)
+	}
+	if len(traceID) != 32 || !isLowerHex(traceID) || len(spanID) != 16 || !isLowerHex(spanID) || len(flags) != 2 || !isLowerHex(flags) {
+		return sc, fmt.Errorf("trace: failed to parse traceparent %q, err: invalid field", traceparent)
+	}
+
+	_, _ = hex.Decode(sc.TraceID[:], []byte(traceID))
+	_, _ = hex.Decode(sc.SpanID[:], []byte(spanID))
+	var flag [1]byte
+	_, _ = hex.Decode(flag[:], []byte(flags))
+	sc.Sampled = flag[0]&flagSampled != 0
+
+	if !sc.IsValid() {
+		return SpanContext{}, fmt.Errorf("trace: failed to parse traceparent %q, err: all zero id", traceparent)
+	}
+	return sc, nil
+}
+
+func isLowerHex(s string) bool {
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// SpanKind tells whether span is created by caller or callee
+type SpanKind string
+
+const (
+	Client SpanKind = "client"
+	Server SpanKind = "server"
+)
+
+// Span includes ids, name, kind, timing, peer and error of one call on one side
+type Span struct {
+	TraceID      string
+	SpanID       string
+	ParentSpanID string `json:",omitempty"`
+
+	Name string
+	Kind SpanKind
+
+	Start    time.Time
+	End      time.Time
+	Duration time.Duration
+
+	Peer  string `json:",omitempty"`
+	Error string `json:",omitempty"`
+
+	context SpanContext
+	tracer  *Tracer
+	ended   int32
+}
+
+// Context is to get span context which is propagated to the callee
+func (s *Span) Context() SpanContext {
+	return s.context
+}
+
+// Finish is to record end time and error, and export the span if it is sampled, only the first finish counts
+func (s *Span) Finish(err error) {
+	if !atomic.CompareAndSwapInt32(&s.ended, 0, 1) {
+		return
+	}
+
+	s.End = time.Now()
+	s.Duration = s.End.Sub(s.Start)
+	if err != nil {
+		s.Error = err.Error()
+	}
+	if s.context.Sampled {
+		s.tracer.export(s)
+	}
+}
+
+type spanContextKey struct{}
+
+// ContextWithSpanContext is to carry span context, the parent of spans started from ctx
+func ContextWithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, sc)
+}
+
+// SpanContextFromContext is to get span context carried by ctx
+func SpanContextFromContext(ctx context.Context) (SpanContext, bool) {
+	sc, ok := ctx.Value(spanContextKey{}).(SpanContext)
+	return sc, ok && sc.IsValid()
+}
+
+// newID is to fill id with random bytes
+func newID(id []byte) {
+	if _, err := rand.Read(id); err != nil {
+		// crypto rand never fails on supported platforms, fall back to time to keep ids unique enough
+		now := time.Now().UnixNano()
+		for i := range id {
+			id[i] = byte(now >> (8 * (i % 8)))
+		}
+	}
+}
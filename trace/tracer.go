@@ -0,0 +1,69 @@
+package trace
+
+import (
+	"context"
+	"encoding/binary"
+	"gingle-rpc/logger"
+	"math"
+	"time"
+)
+
+// Tracer includes exporter and sample ratio of new traces
+type Tracer struct {
+	exporter Exporter
+	ratio    float64
+}
+
+// NewTracer is to create tracer which samples ratio in [0, 1] of new traces, spans of a trace follow its sampling flag
+func NewTracer(exporter Exporter, ratio float64) *Tracer {
+	return &Tracer{
+		exporter: exporter,
+		ratio:    math.Max(0, math.Min(1, ratio)),
+	}
+}
+
+// Start is to start a span as the child of span context carried by ctx, or as the root of a new trace,
+// and return ctx carrying the new span context
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	span := &Span{
+		Name:   name,
+		Kind:   kind,
+		tracer: t,
+	}
+
+	if parent, ok := SpanContextFromContext(ctx); ok {
+		span.context.TraceID = parent.TraceID
+		span.context.Sampled = parent.Sampled
+		span.ParentSpanID = parent.SpanID.String()
+	} else {
+		for !span.context.TraceID.IsValid() {
+			newID(span.context.TraceID[:])
+		}
+		span.context.Sampled = t.shouldSample(span.context.TraceID)
+	}
+	for !span.context.SpanID.IsValid() {
+		newID(span.context.SpanID[:])
+	}
+
+	span.TraceID = span.context.TraceID.String()
+	span.SpanID = span.context.SpanID.String()
+	span.Start = time.Now()
+	return ContextWithSpanContext(ctx, span.context), span
+}
+
+// shouldSample is to decide by the random trace id, so that the decision is consistent for the same trace
+func (t *Tracer) shouldSample(id TraceID) bool {
+	if t.ratio >= 1 {
+		return true
+	}
+	return float64(binary.BigEndian.Uint64(id[8:])) < t.ratio*math.MaxUint64
+}
+
+func (t *Tracer) export(span *Span) {
+	if t.exporter == nil {
+		return
+	}
+	if err := t.exporter.Export(span); err != nil {
+		logger.Default().Log(logger.Error, "trace: failed to export span", logger.Err(err))
+	}
+}
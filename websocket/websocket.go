@@ -0,0 +1,437 @@
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// | rfc 6455 | 1 bit fin | 3 bits rsv | 4 bits opcode | 1 bit mask | 7 bits length | 16 or 64 bits extended length | 32 bits masking key | payload |
+
+const (
+	acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	version    = "13"
+
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+
+	finBit  = 0x80
+	rsvBits = 0x70
+	maskBit = 0x80
+
+	maxControlPayload = 125
+	closeTimeout      = time.Second
+)
+
+// Close status codes sent in close frames
+const (
+	CloseNormalClosure    = 1000
+	CloseProtocolError    = 1002
+	CloseNoStatusReceived = 1005
+)
+
+var errClosed = errors.New("websocket: connection closed")
+
+// Conn includes the connection, its reader, whether it is the client side masking frames, the frame being read and mutexes,
+// it is a net.Conn whose writes are binary messages of their own and whose reads stream payloads of data messages,
+// control frames are handled while reading
+type Conn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	client bool
+
+	remaining  uint64
+	maskKey    [4]byte
+	maskPos    int
+	fragmented bool
+	readErr    error
+	muForRead  sync.Mutex
+
+	closeSent  bool
+	muForWrite sync.Mutex
+}
+
+var _ net.Conn = (*Conn)(nil)
+
+func newConn(conn net.Conn, reader *bufio.Reader, client bool) *Conn {
+	return &Conn{conn: conn, reader: reader, client: client}
+}
+
+// UnderlyingConn is to get the connection websocket runs over
+func (c *Conn) UnderlyingConn() net.Conn {
+	return c.conn
+}
+
+// Upgrade is to upgrade http request to websocket, the http error is written if the request is not a valid handshake,
+// checkOrigin nil means only requests without origin or of the same host are accepted
+func Upgrade(w http.ResponseWriter, r *http.Request, checkOrigin func(r *http.Request) bool) (*Conn, error) {
+	fail := func(code int, reason string) (*Conn, error) {
+		http.Error(w, fmt.Sprintf("%d %s", code, reason), code)
+		return nil, fmt.Errorf("websocket: failed to upgrade, err: %s", reason)
+	}
+
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		return fail(http.StatusMethodNotAllowed, "Must Get")
+	}
+	if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") {
+		return fail(http.StatusBadRequest, "Not WebSocket Handshake")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != version {
+		w.Header().Set("Sec-WebSocket-Version", version)
+		return fail(http.StatusUpgradeRequired, "Unsupported WebSocket Version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return fail(http.StatusBadRequest, "Invalid WebSocket Key")
+	}
+	if checkOrigin == nil {
+		checkOrigin = sameOrigin
+	}
+	if !checkOrigin(r) {
+		return fail(http.StatusForbidden, "Origin Not Allowed")
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return fail(http.StatusInternalServerError, "Hijack Connection Failed")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return fail(http.StatusInternalServerError, "Hijack Connection Failed")
+	}
+
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n\r\n"
+	if _, err := io.WriteString(conn, response); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("websocket: failed to write handshake, err: %v", err)
+	}
+	return newConn(conn, rw.Reader, false), nil
+}
+
+// Handshake is to open websocket at path of host over conn as the client side
+func Handshake(conn net.Conn, host, path string) (*Conn, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("websocket: failed to generate key, err: %v", err)
+	}
+	key := base64.StdEncoding.EncodeToString(b)
+
+	req := &http.Request{
+		Method:     http.MethodGet,
+		URL:        &url.URL{Path: path},
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header: http.Header{
+			"Upgrade":               {"websocket"},
+			"Connection":            {"Upgrade"},
+			"Sec-WebSocket-Key":     {key},
+			"Sec-WebSocket-Version": {version},
+		},
+		Host: host,
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, fmt.Errorf("websocket: failed to write handshake, err: %v", err)
+	}
+
+	reader := bufio.NewReader(conn)
+	res, err := http.ReadResponse(reader, req)
+	if err != nil {
+		return nil, fmt.Errorf("websocket: failed to read handshake, err: %v", err)
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols || !headerContains(res.Header, "Upgrade", "websocket") ||
+		res.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		return nil, fmt.Errorf("websocket: failed to handshake, err: unexpected http response %s", res.Status)
+	}
+	return newConn(conn, reader, true), nil
+}
+
+// Read is to read payloads of data messages as a stream, pings are answered, and the close of peer is answered with io.EOF
+func (c *Conn) Read(p []byte) (int, error) {
+	c.muForRead.Lock()
+	defer c.muForRead.Unlock()
+
+	for c.remaining == 0 {
+		if c.readErr != nil {
+			return 0, c.readErr
+		}
+		if err := c.nextFrame(); err != nil {
+			c.readErr = err
+			return 0, err
+		}
+	}
+
+	if uint64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.reader.Read(p)
+	if !c.client {
+		c.maskPos = mask(c.maskKey, c.maskPos, p[:n])
+	}
+	c.remaining -= uint64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// nextFrame is to read the header of the next data frame, handling control frames before it
+func (c *Conn) nextFrame() error {
+	for {
+		var head [2]byte
+		if _, err := io.ReadFull(c.reader, head[:]); err != nil {
+			return err
+		}
+		fin, opcode, masked := head[0]&finBit != 0, head[0]&0x0F, head[1]&maskBit != 0
+
+		if head[0]&rsvBits != 0 {
+			return c.fail("reserved bits set without extension")
+		}
+		if masked == c.client {
+			return c.fail("frames from client must be masked and frames from server must not")
+		}
+
+		length := uint64(head[1] &^ maskBit)
+		switch length {
+		case 126:
+			var ext [2]byte
+			if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+				return err
+			}
+			length = uint64(binary.BigEndian.Uint16(ext[:]))
+		case 127:
+			var ext [8]byte
+			if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+				return err
+			}
+			length = binary.BigEndian.Uint64(ext[:])
+			if length>>63 != 0 {
+				return c.fail("payload length overflow")
+			}
+		}
+
+		var key [4]byte
+		if masked {
+			if _, err := io.ReadFull(c.reader, key[:]); err != nil {
+				return err
+			}
+		}
+
+		switch opcode {
+		case opText, opBinary, opContinuation:
+			if (opcode == opContinuation) != c.fragmented {
+				return c.fail("unexpected continuation of message")
+			}
+			c.fragmented = !fin
+			c.remaining, c.maskKey, c.maskPos = length, key, 0
+			if length > 0 {
+				return nil
+			}
+		case opClose, opPing, opPong:
+			if !fin || length > maxControlPayload {
+				return c.fail("control frames must not be fragmented or longer than 125 bytes")
+			}
+			payload := make([]byte, length)
+			if _, err := io.ReadFull(c.reader, payload); err != nil {
+				return err
+			}
+			if masked {
+				mask(key, 0, payload)
+			}
+			if err := c.control(opcode, payload); err != nil {
+				return err
+			}
+		default:
+			return c.fail(fmt.Sprintf("unknown opcode %d", opcode))
+		}
+	}
+}
+
+// control is to answer ping by pong, and close by close returning io.EOF
+func (c *Conn) control(opcode byte, payload []byte) error {
+	switch opcode {
+	case opPing:
+		if err := c.writeFrame(opPong, payload); err != nil && err != errClosed {
+			return err
+		}
+	case opClose:
+		code := CloseNoStatusReceived
+		if len(payload) >= 2 {
+			code = int(binary.BigEndian.Uint16(payload))
+		}
+		if code == CloseNoStatusReceived {
+			_ = c.writeClose(CloseNormalClosure, "")
+		} else {
+			_ = c.writeClose(code, "")
+		}
+		return io.EOF
+	}
+	return nil
+}
+
+// fail is to close websocket for protocol error of reason
+func (c *Conn) fail(reason string) error {
+	_ = c.writeClose(CloseProtocolError, reason)
+	return fmt.Errorf("websocket: protocol error, err: %s", reason)
+}
+
+// Write is to write p as a binary message of its own
+func (c *Conn) Write(p []byte) (int, error) {
+	if err := c.writeFrame(opBinary, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close is to send close frame and close the connection
+func (c *Conn) Close() error {
+	_ = c.writeClose(CloseNormalClosure, "")
+	return c.conn.Close()
+}
+
+// LocalAddr is to get the local address of the connection
+func (c *Conn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+// RemoteAddr is to get the remote address of the connection
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// SetDeadline is to set the read and write deadlines of the connection
+func (c *Conn) SetDeadline(t time.Time) error {
+	return c.conn.SetDeadline(t)
+}
+
+// SetReadDeadline is to set the read deadline of the connection
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline is to set the write deadline of the connection
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+// writeClose is to send close frame of code and reason once, no more frames are written after it
+func (c *Conn) writeClose(code int, reason string) error {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	payload = append(payload, reason...)
+	if len(payload) > maxControlPayload {
+		payload = payload[:maxControlPayload]
+	}
+
+	c.muForWrite.Lock()
+	defer c.muForWrite.Unlock()
+
+	if c.closeSent {
+		return errClosed
+	}
+	c.closeSent = true
+	_ = c.conn.SetWriteDeadline(time.Now().Add(closeTimeout))
+	defer func() { _ = c.conn.SetWriteDeadline(time.Time{}) }()
+	return c.write(opClose, payload)
+}
+
+func (c *Conn) writeFrame(opcode byte, payload []byte) error {
+	c.muForWrite.Lock()
+	defer c.muForWrite.Unlock()
+
+	if c.closeSent {
+		return errClosed
+	}
+	return c.write(opcode, payload)
+}
+
+// write is to write a final frame of opcode in one write, masked with a random key on the client side
+func (c *Conn) write(opcode byte, payload []byte) error {
+	frame := make([]byte, 0, 14+len(payload))
+	frame = append(frame, finBit|opcode)
+
+	var maskFlag byte
+	if c.client {
+		maskFlag = maskBit
+	}
+	switch n := len(payload); {
+	case n <= 125:
+		frame = append(frame, maskFlag|byte(n))
+	case n <= 0xFFFF:
+		frame = append(frame, maskFlag|126, byte(n>>8), byte(n))
+	default:
+		var ext [8]byte
+		binary.BigEndian.PutUint64(ext[:], uint64(n))
+		frame = append(append(frame, maskFlag|127), ext[:]...)
+	}
+
+	if !c.client {
+		_, err := c.conn.Write(append(frame, payload...))
+		return err
+	}
+
+	var key [4]byte
+	if _, err := rand.Read(key[:]); err != nil {
+		return fmt.Errorf("websocket: failed to generate masking key, err: %v", err)
+	}
+	frame = append(append(frame, key[:]...), payload...)
+	mask(key, 0, frame[len(frame)-len(payload):])
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+// mask is to mask or unmask b in place by key from pos, and return the next pos
+func mask(key [4]byte, pos int, b []byte) int {
+	for i := range b {
+		b[i] ^= key[pos&3]
+		pos++
+	}
+	return pos & 3
+}
+
+func acceptKey(key string) string {
+	h := sha1.New()
+	_, _ = io.WriteString(h, key+acceptGUID)
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// headerContains is to check whether the comma separated tokens of header key contain token, case insensitive
+func headerContains(header http.Header, key, token string) bool {
+	for _, value := range header.Values(key) {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sameOrigin is to accept requests without origin, which are not from browsers, or whose origin is of the requested host
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
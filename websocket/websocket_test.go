@@ -0,0 +1,285 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startTestServer is to serve websocket upgraded from http by serve
+func startTestServer(t *testing.T, serve func(c *Conn)) string {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer func() {
+			_ = c.Close()
+		}()
+		serve(c)
+	}))
+	t.Cleanup(ts.Close)
+	return strings.TrimPrefix(ts.URL, "http://")
+}
+
+func dialTestServer(t *testing.T, addr string) *Conn {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	c, err := Handshake(conn, addr, "/")
+	if err != nil {
+		t.Fatalf("handshake: %v", err)
+	}
+	return c
+}
+
+// writeRawFrame is to write a frame of its own to conn bypassing the checks of Conn
+func writeRawFrame(t *testing.T, conn net.Conn, head byte, masked bool, payload []byte) {
+	frame := []byte{head, byte(len(payload))}
+	payload = append([]byte(nil), payload...)
+	if masked {
+		key := [4]byte{1, 2, 3, 4}
+		frame[1] |= maskBit
+		frame = append(frame, key[:]...)
+		mask(key, 0, payload)
+	}
+	if _, err := conn.Write(append(frame, payload...)); err != nil {
+		t.Fatalf("write frame: %v", err)
+	}
+}
+
+// rawFrame includes head and payload of a frame read bypassing Conn
+type rawFrame struct {
+	fin     bool
+	opcode  byte
+	masked  bool
+	payload []byte
+}
+
+// readRawFrame is to read a frame from the reader of c bypassing the checks of Conn
+func readRawFrame(t *testing.T, c *Conn) *rawFrame {
+	var head [2]byte
+	if _, err := io.ReadFull(c.reader, head[:]); err != nil {
+		t.Fatalf("read frame: %v", err)
+	}
+	length := uint64(head[1] &^ maskBit)
+	switch length {
+	case 126:
+		var ext [2]byte
+		_, _ = io.ReadFull(c.reader, ext[:])
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		_, _ = io.ReadFull(c.reader, ext[:])
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	frame := &rawFrame{fin: head[0]&finBit != 0, opcode: head[0] & 0x0F, masked: head[1]&maskBit != 0, payload: make([]byte, length)}
+	if _, err := io.ReadFull(c.reader, frame.payload); err != nil {
+		t.Fatalf("read payload: %v", err)
+	}
+	return frame
+}
+
+func closeCode(frame *rawFrame) int {
+	if frame.opcode != opClose || len(frame.payload) < 2 {
+		return 0
+	}
+	return int(binary.BigEndian.Uint16(frame.payload))
+}
+
+func TestMessageFrames(t *testing.T) {
+	sizes := []int{0, 1, 125, 126, 0xFFFF, 0x10000}
+	addr := startTestServer(t, func(c *Conn) {
+		// echo each message as one write
+		for _, size := range sizes {
+			b := make([]byte, size)
+			if _, err := io.ReadFull(c, b); err != nil {
+				return
+			}
+			_, _ = c.Write(b)
+		}
+	})
+	c := dialTestServer(t, addr)
+
+	for _, size := range sizes {
+		payload := bytes.Repeat([]byte{'x'}, size)
+		if _, err := c.Write(payload); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		frame := readRawFrame(t, c)
+		if !frame.fin || frame.opcode != opBinary || frame.masked || !bytes.Equal(frame.payload, payload) {
+			t.Fatalf("frame of %d bytes = fin %v, opcode %d, masked %v, %d bytes, want one unmasked binary frame",
+				size, frame.fin, frame.opcode, frame.masked, len(frame.payload))
+		}
+	}
+}
+
+// readResult includes data read by server until the websocket is closed and the error of reading
+type readResult struct {
+	data []byte
+	err  error
+}
+
+func TestReadFrames(t *testing.T) {
+	type frame struct {
+		head    byte
+		masked  bool
+		payload string
+	}
+	closePayload := func(code int) string {
+		var b [2]byte
+		binary.BigEndian.PutUint16(b[:], uint16(code))
+		return string(b[:])
+	}
+
+	tests := []struct {
+		name      string
+		frames    []frame
+		wantData  string
+		wantErr   bool
+		wantPong  string
+		wantClose int
+	}{
+		{
+			name:      "close of peer",
+			frames:    []frame{{finBit | opBinary, true, "hello"}, {finBit | opClose, true, closePayload(CloseNormalClosure)}},
+			wantData:  "hello",
+			wantClose: CloseNormalClosure,
+		},
+		{
+			name:      "close without status",
+			frames:    []frame{{finBit | opClose, true, ""}},
+			wantClose: CloseNormalClosure,
+		},
+		{
+			name: "fragmented message with ping between",
+			frames: []frame{
+				{opText, true, "hel"},
+				{finBit | opPing, true, "ping"},
+				{opContinuation, true, ""},
+				{finBit | opContinuation, true, "lo"},
+				{finBit | opClose, true, closePayload(CloseNormalClosure)},
+			},
+			wantData:  "hello",
+			wantPong:  "ping",
+			wantClose: CloseNormalClosure,
+		},
+		{
+			name:      "pong ignored",
+			frames:    []frame{{finBit | opPong, true, "pong"}, {finBit | opBinary, true, "a"}, {finBit | opClose, true, ""}},
+			wantData:  "a",
+			wantClose: CloseNormalClosure,
+		},
+		{
+			name:      "unmasked frame from client",
+			frames:    []frame{{finBit | opBinary, false, "hello"}},
+			wantErr:   true,
+			wantClose: CloseProtocolError,
+		},
+		{
+			name:      "continuation without message",
+			frames:    []frame{{finBit | opContinuation, true, "lo"}},
+			wantErr:   true,
+			wantClose: CloseProtocolError,
+		},
+		{
+			name:      "message before the fragmented one is done",
+			frames:    []frame{{opBinary, true, "hel"}, {finBit | opBinary, true, "lo"}},
+			wantData:  "hel",
+			wantErr:   true,
+			wantClose: CloseProtocolError,
+		},
+		{
+			name:      "fragmented control frame",
+			frames:    []frame{{opPing, true, "ping"}},
+			wantErr:   true,
+			wantClose: CloseProtocolError,
+		},
+		{
+			name:      "reserved bits",
+			frames:    []frame{{finBit | 0x40 | opBinary, true, "hello"}},
+			wantErr:   true,
+			wantClose: CloseProtocolError,
+		},
+		{
+			name:      "unknown opcode",
+			frames:    []frame{{finBit | 0x3, true, "hello"}},
+			wantErr:   true,
+			wantClose: CloseProtocolError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := make(chan readResult, 1)
+			addr := startTestServer(t, func(c *Conn) {
+				data, err := ioutil.ReadAll(c)
+				results <- readResult{data: data, err: err}
+			})
+			c := dialTestServer(t, addr)
+
+			for _, f := range tt.frames {
+				writeRawFrame(t, c.conn, f.head, f.masked, []byte(f.payload))
+			}
+
+			if tt.wantPong != "" {
+				if frame := readRawFrame(t, c); frame.opcode != opPong || string(frame.payload) != tt.wantPong {
+					t.Fatalf("frame = opcode %d, payload %q, want pong %q", frame.opcode, frame.payload, tt.wantPong)
+				}
+			}
+			if frame := readRawFrame(t, c); closeCode(frame) != tt.wantClose || frame.masked {
+				t.Fatalf("frame = opcode %d, payload %q, want unmasked close %d", frame.opcode, frame.payload, tt.wantClose)
+			}
+
+			select {
+			case result := <-results:
+				if string(result.data) != tt.wantData || (result.err != nil) != tt.wantErr {
+					t.Fatalf("read %q, err: %v, want %q, err %v", result.data, result.err, tt.wantData, tt.wantErr)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("server still reading")
+			}
+		})
+	}
+}
+
+func TestClientClose(t *testing.T) {
+	results := make(chan readResult, 1)
+	addr := startTestServer(t, func(c *Conn) {
+		data, err := ioutil.ReadAll(c)
+		results <- readResult{data: data, err: err}
+	})
+	c := dialTestServer(t, addr)
+
+	if _, err := c.Write([]byte("bye")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := c.writeClose(CloseNormalClosure, ""); err != nil {
+		t.Fatalf("write close: %v", err)
+	}
+	// the close is answered, after which the client reads io.EOF and writes no more
+	if _, err := c.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("read err = %v, want io.EOF", err)
+	}
+	if _, err := c.Write([]byte("more")); err == nil {
+		t.Fatalf("write after close succeeded")
+	}
+
+	result := <-results
+	if string(result.data) != "bye" || result.err != nil {
+		t.Fatalf("read %q, err: %v, want bye", result.data, result.err)
+	}
+}